@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/config"
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/storage"
+	"github.com/BrownBear56/contractor/internal/storage/file"
+	"github.com/BrownBear56/contractor/internal/storage/postgres"
+)
+
+const commandsUsage = `usage:
+  shortener [flags] compact              compact the storage file (-storage file:... or -f),
+                                         add -repair to drop corrupted records
+  shortener [flags] schema up            apply pending migrations
+  shortener [flags] schema down [steps]  roll back the last steps migrations (default 1)
+  shortener [flags] schema status        list migrations and their state
+  shortener [flags] migrate -from URI -to URI [-batch N] [-checkpoint path] [-verify=false]
+                                         copy all links between storages keeping short IDs`
+
+// runCommand выполняет служебную команду вместо запуска сервера.
+func runCommand(ctx context.Context, cfg *config.Config, appLogger logger.Logger, args []string) error {
+	switch args[0] {
+	case "compact":
+		return runCompactCommand(ctx, cfg, appLogger)
+	case "schema":
+		return runSchemaCommand(ctx, cfg, appLogger, args[1:])
+	case "migrate":
+		return runMigrateCommand(ctx, cfg, appLogger, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], commandsUsage)
+	}
+}
+
+// runCompactCommand переписывает журнал файлового хранилища снимком живых записей.
+func runCompactCommand(ctx context.Context, cfg *config.Config, appLogger logger.Logger) error {
+	uri, err := storage.ParseURI(cfg.StorageURI)
+	if err != nil {
+		return err
+	}
+	if uri.Scheme != storage.SchemeFile {
+		return fmt.Errorf("compact requires file storage, got %s: set -storage file:///path or -f", uri.Scheme)
+	}
+
+	// Фоновые задачи не нужны: журнал сжимается один раз и сразу закрывается.
+	options := cfg.FileStorage
+	options.Compaction.Interval = 0
+	options.SyncPolicy = file.SyncAlways
+	store, err := file.NewFileStore(storage.URIPath(uri), options, appLogger.Named("Storage"))
+	if err != nil {
+		return fmt.Errorf("failed to open storage file: %w", err)
+	}
+	if err := store.Compact(ctx); err != nil {
+		return fmt.Errorf("failed to compact storage file: %w", err)
+	}
+	if err := store.Close(ctx); err != nil {
+		return fmt.Errorf("failed to close storage file: %w", err)
+	}
+	return nil
+}
+
+// runSchemaCommand применяет, откатывает или показывает миграции схемы PostgreSQL.
+func runSchemaCommand(ctx context.Context, cfg *config.Config, appLogger logger.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New(commandsUsage)
+	}
+	if cfg.DatabaseDSN == "" {
+		return errors.New("database DSN is required: set -storage postgres://..., -d or DATABASE_DSN")
+	}
+
+	pool, err := postgres.Connect(ctx, cfg.DatabaseDSN, cfg.Postgres, appLogger.Named("Storage"))
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	migrator, err := postgres.NewMigrator(pool, appLogger.Named("Migrator"))
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	switch args[0] {
+	case "up":
+		if err := migrator.Up(ctx); err != nil {
+			return fmt.Errorf("failed to apply migrations: %w", err)
+		}
+		return nil
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		if err := migrator.Down(ctx, steps); err != nil {
+			return fmt.Errorf("failed to roll back migrations: %w", err)
+		}
+		return nil
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get migrations status: %w", err)
+		}
+		return printMigrationStatus(statuses)
+	default:
+		return fmt.Errorf("unknown schema command %q\n%s", args[0], commandsUsage)
+	}
+}
+
+func printMigrationStatus(statuses []postgres.MigrationStatus) error {
+	const padding = 2
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
+	_, _ = fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.Applied {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to print status: %w", err)
+	}
+	return nil
+}
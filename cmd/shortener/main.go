@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/BrownBear56/contractor/internal/config"
 	"github.com/BrownBear56/contractor/internal/logger"
@@ -24,9 +29,25 @@ func main() {
 
 	cfg := config.NewConfig(appLogger)
 
+	// Аргументы после флагов задают служебную команду, например миграции схемы.
+	if args := flag.Args(); len(args) > 0 {
+		if err := runCommand(context.Background(), cfg, appLogger, args); err != nil {
+			appLogger.Fatal("Command failed", zap.Error(err))
+		}
+		return
+	}
+
 	srv := server.New(cfg, appLogger)
 
-	if err := srv.Start(); err != nil {
-		appLogger.Error("Server failed to start", zap.Error(err))
+	// SIGINT и SIGTERM запускают штатную остановку: повторный сигнал завершает процесс сразу.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := srv.Run(ctx); err != nil {
+		appLogger.Error("Server stopped with error", zap.Error(err))
 	}
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/BrownBear56/contractor/internal/config"
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/storage"
+	"github.com/BrownBear56/contractor/internal/storage/transfer"
+	"go.uber.org/zap"
+)
+
+// runMigrateCommand переносит все ссылки из одного хранилища в другое и сверяет результат.
+// С -checkpoint после каждой порции сохраняется ID её последней записи, и повторный запуск
+// продолжает с него. Без него повторный запуск проходит всё заново, но уже перенесённые
+// записи только учитываются в отчёте.
+func runMigrateCommand(ctx context.Context, cfg *config.Config, appLogger logger.Logger, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := flags.String("from", "", "Source storage URI.")
+	to := flags.String("to", "", "Destination storage URI.")
+	batchSize := flags.Int("batch", transfer.DefaultBatchSize, "Number of links saved per batch.")
+	checkpointPath := flags.String("checkpoint", "", "File to store progress in and resume from.")
+	verify := flags.Bool("verify", true, "Compare every source link with the destination after copying.")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w\n%s", err, commandsUsage)
+	}
+	if *from == "" || *to == "" {
+		return fmt.Errorf("both -from and -to are required\n%s", commandsUsage)
+	}
+	if *from == *to {
+		return errors.New("source and destination storages are the same")
+	}
+
+	storageLogger := appLogger.Named("Storage")
+	src, err := openMigrateStorage(*from, cfg, storageLogger)
+	if err != nil {
+		return fmt.Errorf("failed to open source storage: %w", err)
+	}
+	defer closeMigrateStorage(src, appLogger)
+	backend, err := openMigrateStorage(*to, cfg, storageLogger)
+	if err != nil {
+		return fmt.Errorf("failed to open destination storage: %w", err)
+	}
+	defer closeMigrateStorage(backend, appLogger)
+	// Ограничение длины URL то же, что у сервера, иначе перенесённые ссылки отличались бы от созданных.
+	dst := storage.NewLimitedStorage(backend, cfg.MaxURLLength)
+
+	options := transfer.Options{BatchSize: *batchSize}
+	if *checkpointPath != "" {
+		afterID, err := readCheckpoint(*checkpointPath)
+		if err != nil {
+			return err
+		}
+		if afterID != "" {
+			appLogger.Info("Resuming migration", zap.String("after_id", afterID))
+		}
+		options.AfterID = afterID
+		options.Checkpoint = func(lastID string) error {
+			return writeCheckpoint(*checkpointPath, lastID)
+		}
+	}
+
+	report, err := transfer.Copy(ctx, src, dst, options)
+	if err != nil {
+		// Отчёт о перенесённой части помогает понять, откуда продолжит повторный запуск.
+		_ = printMigrateReport(report, nil)
+		return fmt.Errorf("migration stopped after %q: %w", report.LastID, err)
+	}
+	if err := dst.Flush(ctx); err != nil {
+		return fmt.Errorf("failed to flush destination storage: %w", err)
+	}
+
+	var verification *transfer.Verification
+	if *verify {
+		result, err := transfer.Verify(ctx, src, dst)
+		if err != nil {
+			return fmt.Errorf("failed to verify migration: %w", err)
+		}
+		verification = &result
+	}
+	if err := printMigrateReport(report, verification); err != nil {
+		return err
+	}
+
+	if *checkpointPath != "" {
+		if err := os.Remove(*checkpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			appLogger.Error("Failed to remove checkpoint", zap.Error(err))
+		}
+	}
+	if verification != nil && len(verification.Mismatches) > 0 {
+		return fmt.Errorf("verification found %d mismatches", len(verification.Mismatches))
+	}
+	return nil
+}
+
+// openMigrateStorage открывает хранилище по URI без кеша и фильтра: каждая запись читается один раз.
+func openMigrateStorage(uri string, cfg *config.Config, storageLogger logger.Logger) (storage.Storage, error) {
+	store, err := storage.Open(storage.Config{
+		URI: uri, File: cfg.FileStorage, Bitcask: cfg.Bitcask, Postgres: cfg.Postgres,
+	}, storageLogger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	return store, nil
+}
+
+func closeMigrateStorage(store storage.Storage, appLogger logger.Logger) {
+	if err := store.Close(context.Background()); err != nil {
+		appLogger.Error("Failed to close storage", zap.Error(err))
+	}
+}
+
+// readCheckpoint возвращает ID, сохранённый прерванным переносом, или пустую строку.
+func readCheckpoint(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeCheckpoint атомарно заменяет файл прогресса, чтобы сбой не оставил его обрезанным.
+func writeCheckpoint(path, lastID string) error {
+	const filePerm = 0o600
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, []byte(lastID+"\n"), filePerm); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace checkpoint: %w", err)
+	}
+	return nil
+}
+
+func printMigrateReport(report transfer.Report, verification *transfer.Verification) error {
+	const padding = 2
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, ' ', 0)
+	conflicts := make(map[transfer.ConflictKind]int)
+	for _, conflict := range report.Conflicts {
+		conflicts[conflict.Kind]++
+	}
+
+	_, _ = fmt.Fprintf(w, "read\t%d\n", report.Read)
+	_, _ = fmt.Fprintf(w, "created\t%d\n", report.Created)
+	_, _ = fmt.Fprintf(w, "already present\t%d\n", report.AlreadyPresent)
+	_, _ = fmt.Fprintf(w, "deleted\t%d\n", report.Deleted)
+	_, _ = fmt.Fprintf(w, "skipped expired\t%d\n", report.Expired)
+	_, _ = fmt.Fprintf(w, "url conflicts\t%d\n", conflicts[transfer.ConflictURL])
+	_, _ = fmt.Fprintf(w, "id conflicts\t%d\n", conflicts[transfer.ConflictID])
+	_, _ = fmt.Fprintf(w, "too long urls\t%d\n", conflicts[transfer.ConflictTooLong])
+	if verification != nil {
+		_, _ = fmt.Fprintf(w, "verified\t%d\n", verification.Checked)
+		_, _ = fmt.Fprintf(w, "mismatches\t%d\n", len(verification.Mismatches))
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to print report: %w", err)
+	}
+
+	if len(report.Conflicts) > 0 {
+		_, _ = fmt.Fprintln(w, "\nCONFLICT\tID\tURL\tEXISTING ID")
+		for _, conflict := range report.Conflicts {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+				conflict.Kind, conflict.Record.ShortID, conflict.Record.OriginalURL, conflict.ExistingID)
+		}
+	}
+	if verification != nil && len(verification.Mismatches) > 0 {
+		_, _ = fmt.Fprintln(w, "\nMISMATCH\tEXPECTED\tACTUAL")
+		for _, mismatch := range verification.Mismatches {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", mismatch.ShortID, mismatch.Expected, mismatch.Actual)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to print report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"go.uber.org/zap"
+)
+
+// CookieName — имя cookie с подписанным ID пользователя.
+const CookieName = "user_id"
+
+type contextKey struct{}
+
+type identity struct {
+	userID        string
+	authenticated bool
+}
+
+// UserIDFromContext возвращает ID пользователя, установленный AuthMiddleware.
+// Второе значение равно true, если ID пришёл в валидной подписанной cookie, а не был выдан этим запросом.
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(identity)
+	if !ok {
+		return "", false
+	}
+	return id.userID, id.authenticated
+}
+
+// WithUserID возвращает контекст с ID пользователя, прошедшего аутентификацию.
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity{userID: userID, authenticated: true})
+}
+
+// Sign возвращает значение cookie: ID пользователя и его HMAC-SHA256 подпись.
+func Sign(userID string, secretKey []byte) string {
+	return userID + "." + hex.EncodeToString(signature(userID, secretKey))
+}
+
+// Verify проверяет подпись значения cookie и возвращает ID пользователя.
+func Verify(value string, secretKey []byte) (string, error) {
+	userID, sig, ok := strings.Cut(value, ".")
+	if !ok || userID == "" {
+		return "", errors.New("malformed cookie value")
+	}
+
+	decodedSig, err := hex.DecodeString(sig)
+	if err != nil {
+		return "", fmt.Errorf("malformed cookie signature: %w", err)
+	}
+	if !hmac.Equal(decodedSig, signature(userID, secretKey)) {
+		return "", errors.New("invalid cookie signature")
+	}
+	return userID, nil
+}
+
+func signature(userID string, secretKey []byte) []byte {
+	mac := hmac.New(sha256.New, secretKey)
+	_, _ = mac.Write([]byte(userID))
+	return mac.Sum(nil)
+}
+
+func generateUserID() (string, error) {
+	const userIDLength = 16
+	bytes := make([]byte, userIDLength)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate user ID: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// AuthMiddleware определяет пользователя по подписанной cookie.
+// Если cookie нет или подпись неверна, выдаёт новый ID и устанавливает cookie.
+func AuthMiddleware(next http.Handler, secretKey []byte, log logger.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(CookieName); err == nil {
+			if userID, err := Verify(cookie.Value, secretKey); err == nil {
+				next.ServeHTTP(w, r.WithContext(WithUserID(r.Context(), userID)))
+				return
+			}
+		}
+
+		userID, err := generateUserID()
+		if err != nil {
+			log.Error("Error issuing user ID", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     CookieName,
+			Value:    Sign(userID, secretKey),
+			Path:     "/",
+			HttpOnly: true,
+		})
+
+		ctx := context.WithValue(r.Context(), contextKey{}, identity{userID: userID, authenticated: false})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AdminMiddleware пропускает только запросы с заголовком Authorization: Bearer <token>.
+func AdminMiddleware(next http.Handler, token string, log logger.Logger) http.Handler {
+	// Сравниваются хеши, чтобы время сравнения не выдавало и длину токена.
+	expected := sha256.Sum256([]byte(token))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		actual := sha256.Sum256([]byte(bearer))
+		if !ok || !hmac.Equal(actual[:], expected[:]) {
+			log.Warn("Rejected admin request", zap.String("path", r.URL.Path), zap.String("remote", r.RemoteAddr))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	secretKey := []byte("secret")
+	value := Sign("user-1", secretKey)
+
+	tests := []struct {
+		name        string
+		value       string
+		key         []byte
+		expectedID  string
+		expectError bool
+	}{
+		{name: "Valid cookie", value: value, key: secretKey, expectedID: "user-1"},
+		{name: "Wrong key", value: value, key: []byte("other"), expectError: true},
+		{name: "Tampered user ID", value: "user-2" + value[len("user-1"):], key: secretKey, expectError: true},
+		{name: "No signature", value: "user-1", key: secretKey, expectError: true},
+		{name: "Malformed signature", value: "user-1.zz", key: secretKey, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := Verify(tt.value, tt.key)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedID, userID)
+		})
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	zapLogger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Errorf("Failed to initialize logger: %v", err)
+		return
+	}
+	defer func() {
+		_ = zapLogger.Sync()
+	}()
+
+	testLogger := logger.NewZapLogger(zapLogger)
+	secretKey := []byte("secret")
+
+	var gotUserID string
+	var gotAuthenticated bool
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID, gotAuthenticated = UserIDFromContext(r.Context())
+	}), secretKey, testLogger)
+
+	tests := []struct {
+		name                  string
+		cookie                *http.Cookie
+		expectedUserID        string
+		expectedAuthenticated bool
+		expectNewCookie       bool
+	}{
+		{
+			name:            "No cookie",
+			expectNewCookie: true,
+		},
+		{
+			name:                  "Valid cookie",
+			cookie:                &http.Cookie{Name: CookieName, Value: Sign("user-1", secretKey)},
+			expectedUserID:        "user-1",
+			expectedAuthenticated: true,
+		},
+		{
+			name:            "Forged cookie",
+			cookie:          &http.Cookie{Name: CookieName, Value: Sign("user-1", []byte("other"))},
+			expectNewCookie: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					t.Errorf("failed to close response body: %v", err)
+				}
+			}()
+
+			assert.Equal(t, tt.expectedAuthenticated, gotAuthenticated, "unexpected authentication state")
+			if !tt.expectNewCookie {
+				assert.Equal(t, tt.expectedUserID, gotUserID, "unexpected user ID")
+				assert.Empty(t, resp.Cookies(), "expected no new cookie")
+				return
+			}
+
+			// Новый ID должен прийти в подписанной cookie.
+			cookies := resp.Cookies()
+			if !assert.Len(t, cookies, 1, "expected new cookie") {
+				return
+			}
+			userID, err := Verify(cookies[0].Value, secretKey)
+			assert.NoError(t, err, "expected issued cookie to be valid")
+			assert.Equal(t, gotUserID, userID, "expected issued cookie to carry request user ID")
+		})
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	handler := AdminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), "token", logger.NewZapLogger(zap.NewNop()))
+
+	tests := []struct {
+		name          string
+		authorization string
+		expected      int
+	}{
+		{name: "Valid token", authorization: "Bearer token", expected: http.StatusOK},
+		{name: "Wrong token", authorization: "Bearer other", expected: http.StatusUnauthorized},
+		{name: "Token prefix", authorization: "Bearer tok", expected: http.StatusUnauthorized},
+		{name: "No scheme", authorization: "token", expected: http.StatusUnauthorized},
+		{name: "No header", expected: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/admin/export", http.NoBody)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.expected, w.Code)
+		})
+	}
+}
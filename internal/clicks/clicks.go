@@ -0,0 +1,140 @@
+package clicks
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultFlushInterval — период записи накопленных переходов в хранилище.
+	DefaultFlushInterval = 5 * time.Second
+
+	flushTimeout = 10 * time.Second
+)
+
+// Store — часть хранилища, необходимая для записи переходов.
+type Store interface {
+	RecordClicks(ctx context.Context, clicks []models.ClickStat) error
+}
+
+// Tracker считает переходы по ссылкам в памяти и периодически записывает
+// накопленные приращения в хранилище, не замедляя редирект записью на каждый переход.
+type Tracker struct {
+	store     Store
+	logger    logger.Logger
+	mu        *sync.Mutex
+	pending   map[string]models.ClickStat
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce *sync.Once
+	interval  time.Duration
+}
+
+// New создаёт Tracker и запускает фоновую горутину записи.
+func New(store Store, parentLogger logger.Logger, interval time.Duration) *Tracker {
+	t := &Tracker{
+		store:     store,
+		logger:    parentLogger,
+		mu:        &sync.Mutex{},
+		pending:   make(map[string]models.ClickStat),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
+		interval:  interval,
+	}
+	go t.run()
+	return t
+}
+
+// Record учитывает переход по ссылке в момент at.
+func (t *Tracker) Record(id string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.add(models.ClickStat{ShortID: id, Clicks: 1, LastAccessedAt: at})
+}
+
+// Pending возвращает переходы по ссылке, ещё не записанные в хранилище.
+func (t *Tracker) Pending(id string) models.ClickStat {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.pending[id]
+}
+
+// Flush записывает накопленные переходы в хранилище.
+// При ошибке переходы возвращаются в буфер и будут записаны при следующей попытке.
+func (t *Tracker) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	if len(t.pending) == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	batch := make([]models.ClickStat, 0, len(t.pending))
+	for _, stat := range t.pending {
+		batch = append(batch, stat)
+	}
+	t.pending = make(map[string]models.ClickStat)
+	t.mu.Unlock()
+
+	if err := t.store.RecordClicks(ctx, batch); err != nil {
+		t.mu.Lock()
+		for _, stat := range batch {
+			t.add(stat)
+		}
+		t.mu.Unlock()
+		return fmt.Errorf("failed to record clicks: %w", err)
+	}
+	return nil
+}
+
+// Close останавливает фоновую горутину и записывает оставшиеся переходы.
+func (t *Tracker) Close(ctx context.Context) error {
+	t.closeOnce.Do(func() {
+		close(t.stop)
+	})
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return fmt.Errorf("close tracker canceled: %w", ctx.Err())
+	}
+	return t.Flush(ctx)
+}
+
+// add прибавляет приращение к буферу. Вызывается под t.mu.
+func (t *Tracker) add(stat models.ClickStat) {
+	current := t.pending[stat.ShortID]
+	current.ShortID = stat.ShortID
+	current.Clicks += stat.Clicks
+	if stat.LastAccessedAt.After(current.LastAccessedAt) {
+		current.LastAccessedAt = stat.LastAccessedAt
+	}
+	t.pending[stat.ShortID] = current
+}
+
+func (t *Tracker) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			if err := t.Flush(ctx); err != nil {
+				t.logger.Error("Failed to flush clicks", zap.Error(err))
+			}
+			cancel()
+		case <-t.stop:
+			return
+		}
+	}
+}
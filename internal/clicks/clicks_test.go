@@ -0,0 +1,133 @@
+package clicks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// recordingStore суммирует записанные переходы по ID и возвращает err, пока она задана.
+type recordingStore struct {
+	mu     sync.Mutex
+	err    error
+	clicks map[string]models.ClickStat
+	calls  int
+}
+
+func newRecordingStore() *recordingStore {
+	return &recordingStore{clicks: make(map[string]models.ClickStat)}
+}
+
+func (s *recordingStore) RecordClicks(_ context.Context, clicks []models.ClickStat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.err != nil {
+		return s.err
+	}
+	for _, click := range clicks {
+		current := s.clicks[click.ShortID]
+		current.ShortID = click.ShortID
+		current.Clicks += click.Clicks
+		if click.LastAccessedAt.After(current.LastAccessedAt) {
+			current.LastAccessedAt = click.LastAccessedAt
+		}
+		s.clicks[click.ShortID] = current
+	}
+	return nil
+}
+
+func (s *recordingStore) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+}
+
+func (s *recordingStore) stat(id string) models.ClickStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.clicks[id]
+}
+
+func newTestTracker(t *testing.T, store Store, interval time.Duration) *Tracker {
+	t.Helper()
+
+	tracker := New(store, logger.NewZapLogger(zap.NewNop()), interval)
+	t.Cleanup(func() {
+		_ = tracker.Close(context.Background())
+	})
+	return tracker
+}
+
+func TestAggregation(t *testing.T) {
+	ctx := context.Background()
+	store := newRecordingStore()
+	tracker := newTestTracker(t, store, time.Hour)
+
+	first := time.Now().Add(-time.Minute)
+	last := time.Now()
+	tracker.Record("a", last)
+	tracker.Record("a", first)
+	tracker.Record("b", first)
+	assert.Equal(t, models.ClickStat{ShortID: "a", Clicks: 2, LastAccessedAt: last}, tracker.Pending("a"))
+
+	require.NoError(t, tracker.Flush(ctx))
+	assert.Equal(t, models.ClickStat{ShortID: "a", Clicks: 2, LastAccessedAt: last}, store.stat("a"))
+	assert.Equal(t, int64(1), store.stat("b").Clicks)
+	assert.Zero(t, tracker.Pending("a"))
+
+	// Пустой буфер не записывается.
+	require.NoError(t, tracker.Flush(ctx))
+	assert.Equal(t, 1, store.calls)
+}
+
+func TestFlushInterval(t *testing.T) {
+	store := newRecordingStore()
+	tracker := newTestTracker(t, store, 10*time.Millisecond)
+
+	tracker.Record("a", time.Now())
+	assert.Eventually(t, func() bool {
+		return store.stat("a").Clicks == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestCloseFlushes(t *testing.T) {
+	ctx := context.Background()
+	store := newRecordingStore()
+	tracker := New(store, logger.NewZapLogger(zap.NewNop()), time.Hour)
+
+	tracker.Record("a", time.Now())
+	tracker.Record("a", time.Now())
+	require.NoError(t, tracker.Close(ctx))
+	assert.Equal(t, int64(2), store.stat("a").Clicks)
+}
+
+func TestFailedFlushKeepsClicks(t *testing.T) {
+	ctx := context.Background()
+	unavailable := errors.New("unavailable")
+	store := newRecordingStore()
+	store.setErr(unavailable)
+	tracker := newTestTracker(t, store, time.Hour)
+
+	tracker.Record("a", time.Now())
+	require.ErrorIs(t, tracker.Flush(ctx), unavailable)
+	// Переходы возвращаются в буфер и складываются с новыми.
+	tracker.Record("a", time.Now())
+	assert.Equal(t, int64(2), tracker.Pending("a").Clicks)
+
+	store.setErr(nil)
+	require.NoError(t, tracker.Flush(ctx))
+	assert.Equal(t, int64(2), store.stat("a").Clicks)
+	assert.Zero(t, tracker.Pending("a"))
+}
@@ -1,19 +1,54 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"log"
+	"math"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/storage"
+	"github.com/BrownBear56/contractor/internal/storage/bitcask"
+	"github.com/BrownBear56/contractor/internal/storage/bloom"
+	"github.com/BrownBear56/contractor/internal/storage/cache"
+	"github.com/BrownBear56/contractor/internal/storage/file"
+	"github.com/BrownBear56/contractor/internal/storage/postgres"
 	"go.uber.org/zap/zapcore"
 )
 
 type Config struct {
+	// StorageURI выбирает хранилище, например memory://, file:///data/storage.json или postgres://...
+	// Если не задан, выводится из FileStoragePath, DatabaseDSN и BitcaskDir.
+	StorageURI      string
 	Address         string
 	BaseURL         string
 	FileStoragePath string
 	DatabaseDSN     string
+	SecretKey       string
+	BitcaskDir      string
+	// AdminToken открывает служебные эндпоинты /api/admin для запросов с этим Bearer-токеном.
+	// Пустое значение отключает их.
+	AdminToken string
+	// Bitcask — настройки хранилища Bitcask, используемого при непустом BitcaskDir.
+	Bitcask bitcask.Options
+	// FileStorage — настройки сжатия, сброса на диск и восстановления файлового хранилища.
+	FileStorage file.Options
+	// Postgres — настройки хранилища PostgreSQL.
+	Postgres postgres.Options
+	// Cache — настройки кеша чтения ссылок поверх хранилища.
+	Cache cache.Options
+	// IDFilter — настройки фильтра Блума известных ID.
+	IDFilter bloom.Options
+	// MaxURLLength — наибольшая длина сокращаемого URL в байтах, одинаковая для всех хранилищ.
+	MaxURLLength int
+	// ShutdownTimeout ограничивает ожидание активных запросов при остановке сервера
+	// и, отдельно, сброс и закрытие хранилища после них.
+	ShutdownTimeout time.Duration
 }
 
 func NewConfig(parentLogger logger.Logger) *Config {
@@ -43,9 +78,66 @@ func NewConfig(parentLogger logger.Logger) *Config {
 
 	// Флаги командной строки.
 	addressFlag := flag.String("a", "localhost:8080", "HTTP server address.")
+	const defaultShutdownTimeout = 10 * time.Second
+	shutdownTimeoutFlag := flag.Duration("shutdown-timeout", defaultShutdownTimeout,
+		"Time to drain in-flight requests on shutdown, and then to flush and close storage.")
 	baseURLFlag := flag.String("b", "http://localhost:8080", "Base URL for shortened links.")
 	filePathFlag := flag.String("f", "storage.json", "Path to file storage.")
 	databaseDSNFlag := flag.String("d", "", "Database connection string.")
+	secretKeyFlag := flag.String("k", "", "Secret key for signing user cookies.")
+	maxURLLengthFlag := flag.Int("max-url-length", storage.DefaultMaxURLLength, "Maximum URL length in bytes.")
+	adminTokenFlag := flag.String("admin-token", "", "Bearer token for /api/admin endpoints, empty disables them.")
+	storageFlag := flag.String("storage", "",
+		"Storage URI: memory://, file:///path, bitcask:///dir or postgres://... Overrides -f, -d and -bitcask-dir.")
+	bitcaskDirFlag := flag.String("bitcask-dir", "", "Directory of the Bitcask storage, used when no database is set.")
+	defaultBitcaskOptions := bitcask.DefaultOptions()
+	bitcaskSyncFlag := flag.Bool("bitcask-sync", defaultBitcaskOptions.Sync, "Fsync Bitcask segments after every write.")
+	defaultFileOptions := file.DefaultOptions()
+	compactIntervalFlag := flag.Duration("compact-interval", defaultFileOptions.Compaction.Interval,
+		"Interval between storage file compaction checks, 0 disables periodic compaction.")
+	compactMinSizeFlag := flag.Int64("compact-min-size", defaultFileOptions.Compaction.MinSize,
+		"Minimal storage file size in bytes to compact.")
+	compactMinStaleFlag := flag.Int("compact-min-stale", defaultFileOptions.Compaction.MinStaleRecords,
+		"Minimal number of stale storage file records to compact.")
+	fsyncFlag := flag.String("fsync", string(defaultFileOptions.SyncPolicy),
+		"Storage file fsync policy: always, interval or never.")
+	fsyncIntervalFlag := flag.Duration("fsync-interval", defaultFileOptions.SyncInterval,
+		"Storage file fsync interval for the interval policy.")
+	fileFormatFlag := flag.String("file-format", string(defaultFileOptions.Format),
+		"Storage file format: json or binary. Existing file is converted on start.")
+	repairFlag := flag.Bool("repair", false, "Skip corrupted storage file records instead of refusing to start.")
+	defaultPostgresOptions := postgres.DefaultOptions()
+	copyThresholdFlag := flag.Int("pg-copy-threshold", defaultPostgresOptions.CopyThreshold,
+		"Batch size from which PostgreSQL batches are loaded with COPY, 0 disables COPY.")
+	replicasFlag := flag.String("pg-replicas", "", "Comma-separated DSNs of PostgreSQL read replicas.")
+	replicaCheckIntervalFlag := flag.Duration("pg-replica-check-interval",
+		defaultPostgresOptions.ReplicaCheckInterval, "Interval between PostgreSQL replica health checks.")
+	readYourWritesFlag := flag.Duration("pg-read-your-writes", defaultPostgresOptions.ReadYourWritesWindow,
+		"Time to read a user's links from the primary after the user saves one, 0 disables pinning.")
+	defaultPool := defaultPostgresOptions.Pool
+	maxConnsFlag := flag.Int("pg-max-conns", int(defaultPool.MaxConns), "Maximum size of the PostgreSQL pool.")
+	minConnsFlag := flag.Int("pg-min-conns", int(defaultPool.MinConns), "Minimum size of the PostgreSQL pool.")
+	maxConnLifetimeFlag := flag.Duration("pg-max-conn-lifetime", defaultPool.MaxConnLifetime,
+		"Lifetime of a PostgreSQL connection, 0 keeps the driver default.")
+	maxConnIdleTimeFlag := flag.Duration("pg-max-conn-idle-time", defaultPool.MaxConnIdleTime,
+		"Idle time after which a PostgreSQL connection is closed, 0 keeps the driver default.")
+	healthCheckPeriodFlag := flag.Duration("pg-health-check-period", defaultPool.HealthCheckPeriod,
+		"Interval between PostgreSQL pool health checks, 0 keeps the driver default.")
+	statementTimeoutFlag := flag.Duration("pg-statement-timeout", defaultPool.StatementTimeout,
+		"Server-side PostgreSQL statement timeout, 0 disables it.")
+	connectAttemptsFlag := flag.Int("pg-connect-attempts", defaultPostgresOptions.ConnectRetry.MaxAttempts,
+		"Attempts to reach PostgreSQL on startup.")
+	queryAttemptsFlag := flag.Int("pg-query-attempts", defaultPostgresOptions.QueryRetry.MaxAttempts,
+		"Attempts of idempotent PostgreSQL queries on transient errors.")
+	defaultCacheOptions := cache.DefaultOptions()
+	cacheSizeFlag := flag.Int("cache-size", defaultCacheOptions.Size,
+		"Number of cached storage lookups, 0 disables the read cache.")
+	cacheTTLFlag := flag.Duration("cache-ttl", defaultCacheOptions.TTL, "Lifetime of cached storage lookups.")
+	defaultFilterOptions := bloom.DefaultOptions()
+	filterRateFlag := flag.Float64("id-filter-fp-rate", defaultFilterOptions.FalsePositiveRate,
+		"False positive rate of the known ID filter, 0 disables the filter.")
+	filterMaxBytesFlag := flag.Int64("id-filter-max-bytes", defaultFilterOptions.MaxBytes,
+		"Memory budget of the known ID filter in bytes.")
 
 	flag.Parse()
 
@@ -55,6 +147,18 @@ func NewConfig(parentLogger logger.Logger) *Config {
 		address = envAddress
 	}
 
+	shutdownTimeout := *shutdownTimeoutFlag
+	if env, ok := os.LookupEnv("SHUTDOWN_TIMEOUT"); ok {
+		shutdownTimeout, err = time.ParseDuration(env)
+		if err != nil {
+			log.Fatalf("Invalid SHUTDOWN_TIMEOUT: %v", err)
+		}
+	}
+	if shutdownTimeout <= 0 {
+		configLogger.Info("Shutdown timeout must be positive. Using default value.")
+		shutdownTimeout = defaultShutdownTimeout
+	}
+
 	baseURL := *baseURLFlag
 	if envBaseURL, ok := os.LookupEnv("BASE_URL"); ok {
 		baseURL = envBaseURL
@@ -70,16 +174,318 @@ func NewConfig(parentLogger logger.Logger) *Config {
 		databaseDSN = envDSN
 	}
 
+	secretKey := *secretKeyFlag
+	if envSecretKey, ok := os.LookupEnv("SECRET_KEY"); ok {
+		secretKey = envSecretKey
+	}
+
+	maxURLLength := *maxURLLengthFlag
+	if env, ok := os.LookupEnv("MAX_URL_LENGTH"); ok {
+		maxURLLength, err = strconv.Atoi(env)
+		if err != nil {
+			log.Fatalf("Invalid MAX_URL_LENGTH: %v", err)
+		}
+	}
+	if maxURLLength <= 0 || maxURLLength > storage.MaxURLLengthLimit {
+		log.Fatalf("Invalid max URL length %d: must be in [1, %d]", maxURLLength, storage.MaxURLLengthLimit)
+	}
+
+	adminToken := *adminTokenFlag
+	if env, ok := os.LookupEnv("ADMIN_TOKEN"); ok {
+		adminToken = env
+	}
+
+	bitcaskDir := *bitcaskDirFlag
+	if env, ok := os.LookupEnv("BITCASK_DIR"); ok {
+		bitcaskDir = env
+	}
+
+	bitcaskOptions := defaultBitcaskOptions
+	bitcaskOptions.Sync = *bitcaskSyncFlag
+	if env, ok := os.LookupEnv("BITCASK_SYNC"); ok {
+		bitcaskOptions.Sync, err = strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalf("Invalid BITCASK_SYNC: %v", err)
+		}
+	}
+
+	compaction := file.CompactionConfig{
+		Interval:        *compactIntervalFlag,
+		MinSize:         *compactMinSizeFlag,
+		MinStaleRecords: *compactMinStaleFlag,
+	}
+	if env, ok := os.LookupEnv("FILE_COMPACT_INTERVAL"); ok {
+		interval, err := time.ParseDuration(env)
+		if err != nil {
+			log.Fatalf("Invalid FILE_COMPACT_INTERVAL: %v", err)
+		}
+		compaction.Interval = interval
+	}
+	if env, ok := os.LookupEnv("FILE_COMPACT_MIN_SIZE"); ok {
+		minSize, err := strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid FILE_COMPACT_MIN_SIZE: %v", err)
+		}
+		compaction.MinSize = minSize
+	}
+	if env, ok := os.LookupEnv("FILE_COMPACT_MIN_STALE"); ok {
+		minStale, err := strconv.Atoi(env)
+		if err != nil {
+			log.Fatalf("Invalid FILE_COMPACT_MIN_STALE: %v", err)
+		}
+		compaction.MinStaleRecords = minStale
+	}
+
+	fsyncPolicy := *fsyncFlag
+	if env, ok := os.LookupEnv("FILE_FSYNC"); ok {
+		fsyncPolicy = env
+	}
+	syncPolicy, err := file.ParseSyncPolicy(fsyncPolicy)
+	if err != nil {
+		log.Fatalf("Invalid fsync policy: %v", err)
+	}
+
+	syncInterval := *fsyncIntervalFlag
+	if env, ok := os.LookupEnv("FILE_FSYNC_INTERVAL"); ok {
+		syncInterval, err = time.ParseDuration(env)
+		if err != nil {
+			log.Fatalf("Invalid FILE_FSYNC_INTERVAL: %v", err)
+		}
+	}
+	if syncPolicy == file.SyncInterval && syncInterval <= 0 {
+		configLogger.Info("Fsync interval must be positive. Using default value.")
+		syncInterval = defaultFileOptions.SyncInterval
+	}
+
+	fileFormatValue := *fileFormatFlag
+	if env, ok := os.LookupEnv("FILE_STORAGE_FORMAT"); ok {
+		fileFormatValue = env
+	}
+	fileFormat, err := file.ParseFormat(fileFormatValue)
+	if err != nil {
+		log.Fatalf("Invalid storage file format: %v", err)
+	}
+
+	repair := *repairFlag
+	if env, ok := os.LookupEnv("FILE_REPAIR"); ok {
+		repair, err = strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalf("Invalid FILE_REPAIR: %v", err)
+		}
+	}
+
+	postgresOptions := defaultPostgresOptions
+	postgresOptions.CopyThreshold = *copyThresholdFlag
+	if env, ok := os.LookupEnv("PG_COPY_THRESHOLD"); ok {
+		postgresOptions.CopyThreshold, err = strconv.Atoi(env)
+		if err != nil {
+			log.Fatalf("Invalid PG_COPY_THRESHOLD: %v", err)
+		}
+	}
+	replicas := *replicasFlag
+	if env, ok := os.LookupEnv("PG_REPLICAS"); ok {
+		replicas = env
+	}
+	for _, dsn := range strings.Split(replicas, ",") {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			postgresOptions.ReplicaDSNs = append(postgresOptions.ReplicaDSNs, dsn)
+		}
+	}
+	postgresOptions.ReplicaCheckInterval = *replicaCheckIntervalFlag
+	if env, ok := os.LookupEnv("PG_REPLICA_CHECK_INTERVAL"); ok {
+		postgresOptions.ReplicaCheckInterval, err = time.ParseDuration(env)
+		if err != nil {
+			log.Fatalf("Invalid PG_REPLICA_CHECK_INTERVAL: %v", err)
+		}
+	}
+	if postgresOptions.ReplicaCheckInterval <= 0 {
+		configLogger.Info("Replica check interval must be positive. Using default value.")
+		postgresOptions.ReplicaCheckInterval = defaultPostgresOptions.ReplicaCheckInterval
+	}
+	postgresOptions.ReadYourWritesWindow = *readYourWritesFlag
+	if env, ok := os.LookupEnv("PG_READ_YOUR_WRITES"); ok {
+		postgresOptions.ReadYourWritesWindow, err = time.ParseDuration(env)
+		if err != nil {
+			log.Fatalf("Invalid PG_READ_YOUR_WRITES: %v", err)
+		}
+	}
+	postgresOptions.Pool = poolOptions(*maxConnsFlag, *minConnsFlag, postgres.PoolOptions{
+		MaxConnLifetime:   *maxConnLifetimeFlag,
+		MaxConnIdleTime:   *maxConnIdleTimeFlag,
+		HealthCheckPeriod: *healthCheckPeriodFlag,
+		StatementTimeout:  *statementTimeoutFlag,
+	})
+	postgresOptions.ConnectRetry.MaxAttempts = *connectAttemptsFlag
+	if env, ok := os.LookupEnv("PG_CONNECT_ATTEMPTS"); ok {
+		postgresOptions.ConnectRetry.MaxAttempts, err = strconv.Atoi(env)
+		if err != nil {
+			log.Fatalf("Invalid PG_CONNECT_ATTEMPTS: %v", err)
+		}
+	}
+	postgresOptions.QueryRetry.MaxAttempts = *queryAttemptsFlag
+	if env, ok := os.LookupEnv("PG_QUERY_ATTEMPTS"); ok {
+		postgresOptions.QueryRetry.MaxAttempts, err = strconv.Atoi(env)
+		if err != nil {
+			log.Fatalf("Invalid PG_QUERY_ATTEMPTS: %v", err)
+		}
+	}
+
+	cacheOptions := cache.Options{Size: *cacheSizeFlag, TTL: *cacheTTLFlag}
+	if env, ok := os.LookupEnv("CACHE_SIZE"); ok {
+		cacheOptions.Size, err = strconv.Atoi(env)
+		if err != nil {
+			log.Fatalf("Invalid CACHE_SIZE: %v", err)
+		}
+	}
+	if env, ok := os.LookupEnv("CACHE_TTL"); ok {
+		cacheOptions.TTL, err = time.ParseDuration(env)
+		if err != nil {
+			log.Fatalf("Invalid CACHE_TTL: %v", err)
+		}
+	}
+
+	filterOptions := defaultFilterOptions
+	filterOptions.FalsePositiveRate = *filterRateFlag
+	filterOptions.MaxBytes = *filterMaxBytesFlag
+	if env, ok := os.LookupEnv("ID_FILTER_FP_RATE"); ok {
+		filterOptions.FalsePositiveRate, err = strconv.ParseFloat(env, 64)
+		if err != nil {
+			log.Fatalf("Invalid ID_FILTER_FP_RATE: %v", err)
+		}
+	}
+	if env, ok := os.LookupEnv("ID_FILTER_MAX_BYTES"); ok {
+		filterOptions.MaxBytes, err = strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid ID_FILTER_MAX_BYTES: %v", err)
+		}
+	}
+	if filterOptions.FalsePositiveRate < 0 || filterOptions.FalsePositiveRate >= 1 {
+		log.Fatalf("Invalid ID filter false positive rate %v: must be in [0, 1)", filterOptions.FalsePositiveRate)
+	}
+
+	storageURI := *storageFlag
+	if env, ok := os.LookupEnv("STORAGE_URI"); ok {
+		storageURI = env
+	}
+	storageURI, databaseDSN = resolveStorage(storageURI, fileStoragePath, databaseDSN, bitcaskDir)
+
 	// Валидация базового URL.
 	if baseURL == "" {
 		configLogger.Info("Base URL cannot be empty. Using default value.")
 		baseURL = "http://localhost:8080"
 	}
 
+	// Без ключа подписи генерируем случайный: cookie перестанут быть валидными после перезапуска.
+	if secretKey == "" {
+		configLogger.Info("Secret key is not set. Using random key, user cookies will not survive restart.")
+		const secretKeyLength = 32
+		bytes := make([]byte, secretKeyLength)
+		if _, err := rand.Read(bytes); err != nil {
+			log.Fatalf("Failed to generate secret key: %v", err)
+		}
+		secretKey = hex.EncodeToString(bytes)
+	}
+
 	return &Config{
+		StorageURI:      storageURI,
 		Address:         address,
+		ShutdownTimeout: shutdownTimeout,
 		BaseURL:         baseURL,
 		FileStoragePath: fileStoragePath,
 		DatabaseDSN:     databaseDSN,
+		SecretKey:       secretKey,
+		BitcaskDir:      bitcaskDir,
+		AdminToken:      adminToken,
+		Bitcask:         bitcaskOptions,
+		FileStorage: file.Options{
+			Format:       fileFormat,
+			Compaction:   compaction,
+			SyncPolicy:   syncPolicy,
+			SyncInterval: syncInterval,
+			Repair:       repair,
+		},
+		Postgres:     postgresOptions,
+		Cache:        cacheOptions,
+		IDFilter:     filterOptions,
+		MaxURLLength: maxURLLength,
+	}
+}
+
+// poolOptions применяет к настройкам пула PostgreSQL из флагов переменные окружения
+// и проверяет их согласованность. Размеры пула из флагов и из окружения проверяются одинаково.
+func poolOptions(maxConns, minConns int, options postgres.PoolOptions) postgres.PoolOptions {
+	conns := []struct {
+		value *int
+		flag  string
+		env   string
+	}{
+		{value: &maxConns, flag: "pg-max-conns", env: "PG_MAX_CONNS"},
+		{value: &minConns, flag: "pg-min-conns", env: "PG_MIN_CONNS"},
+	}
+	for _, conn := range conns {
+		if env, ok := os.LookupEnv(conn.env); ok {
+			parsed, err := strconv.Atoi(env)
+			if err != nil {
+				log.Fatalf("Invalid %s: %v", conn.env, err)
+			}
+			*conn.value = parsed
+		}
+		if *conn.value < 0 || *conn.value > math.MaxInt32 {
+			log.Fatalf("Invalid PostgreSQL pool size %d (-%s, %s): must be in [0, %d]",
+				*conn.value, conn.flag, conn.env, math.MaxInt32)
+		}
+	}
+	options.MaxConns, options.MinConns = int32(maxConns), int32(minConns)
+
+	durations := map[string]*time.Duration{
+		"PG_MAX_CONN_LIFETIME":   &options.MaxConnLifetime,
+		"PG_MAX_CONN_IDLE_TIME":  &options.MaxConnIdleTime,
+		"PG_HEALTH_CHECK_PERIOD": &options.HealthCheckPeriod,
+		"PG_STATEMENT_TIMEOUT":   &options.StatementTimeout,
+	}
+	for name, value := range durations {
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		parsed, err := time.ParseDuration(env)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", name, err)
+		}
+		*value = parsed
+	}
+
+	if options.MaxConns > 0 && options.MinConns > options.MaxConns {
+		log.Fatalf("Invalid PostgreSQL pool size: min conns %d exceed max conns %d", options.MinConns, options.MaxConns)
+	}
+	return options
+}
+
+// resolveStorage возвращает URI хранилища и строку подключения PostgreSQL.
+// Явный URI со схемой postgres становится и строкой подключения, чтобы ею пользовались
+// проверка доступности базы и миграции. Без URI хранилище выбирается по старым настройкам:
+// PostgreSQL по DSN, затем Bitcask, затем файл, затем память.
+func resolveStorage(storageURI, filePath, databaseDSN, bitcaskDir string) (string, string) {
+	if storageURI != "" {
+		uri, err := storage.ParseURI(storageURI)
+		if err != nil {
+			log.Fatalf("Invalid storage URI: %v", err)
+		}
+		if uri.Scheme == storage.SchemePostgres || uri.Scheme == storage.SchemePostgreSQL {
+			databaseDSN = storageURI
+		}
+		return storageURI, databaseDSN
+	}
+
+	switch {
+	case databaseDSN != "":
+		// DSN может быть в формате ключ=значение, поэтому передаётся отдельно от URI.
+		return storage.SchemePostgres + "://", databaseDSN
+	case bitcaskDir != "":
+		return storage.PathURI(storage.SchemeBitcask, bitcaskDir), databaseDSN
+	case filePath != "":
+		return storage.PathURI(storage.SchemeFile, filePath), databaseDSN
+	default:
+		return storage.SchemeMemory + "://", databaseDSN
 	}
 }
@@ -0,0 +1,201 @@
+package deleter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"go.uber.org/zap"
+)
+
+// ErrClosed возвращается при постановке задачи в остановленный Deleter.
+var ErrClosed = errors.New("deleter is closed")
+
+const (
+	// DefaultBatchSize — число ссылок, при накоплении которого удаление выполняется сразу.
+	DefaultBatchSize = 100
+	// DefaultFlushInterval — максимальное время ожидания ссылки в буфере.
+	DefaultFlushInterval = time.Second
+
+	queueSize    = 1024
+	flushTimeout = 10 * time.Second
+	// maxPending ограничивает буфер ссылок, ждущих повторной попытки удаления.
+	maxPending = queueSize * DefaultBatchSize
+)
+
+// Store — часть хранилища, необходимая для удаления ссылок.
+type Store interface {
+	DeleteURLs(ctx context.Context, requests []models.DeleteRequest) error
+}
+
+// Deleter собирает задачи на удаление из многих HTTP-запросов в общий канал
+// и удаляет ссылки пакетами в одной фоновой горутине.
+//
+// Клиент получает ответ до удаления, поэтому пакет, который не удалось удалить, остаётся
+// в буфере и удаляется повторно по таймеру. Пока попытки не удаются, пакеты по размеру
+// не отправляются, а при переполнении буфера отбрасываются самые старые задачи.
+type Deleter struct {
+	store         Store
+	logger        logger.Logger
+	jobs          chan []models.DeleteRequest
+	flushRequests chan chan error
+	stop          chan struct{}
+	done          chan struct{}
+	closeOnce     *sync.Once
+	// closeErr — ошибка последней попытки удаления при остановке, читается после закрытия done.
+	closeErr      error
+	batchSize     int
+	flushInterval time.Duration
+}
+
+// New создаёт Deleter и запускает фоновую горутину удаления.
+func New(store Store, parentLogger logger.Logger, batchSize int, flushInterval time.Duration) *Deleter {
+	d := &Deleter{
+		store:         store,
+		logger:        parentLogger,
+		jobs:          make(chan []models.DeleteRequest, queueSize),
+		flushRequests: make(chan chan error),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+		closeOnce:     &sync.Once{},
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+	go d.run()
+	return d
+}
+
+// Enqueue ставит в очередь удаление ссылок пользователя и не ждёт его выполнения.
+func (d *Deleter) Enqueue(ctx context.Context, userID string, shortIDs []string) error {
+	requests := make([]models.DeleteRequest, 0, len(shortIDs))
+	for _, id := range shortIDs {
+		requests = append(requests, models.DeleteRequest{UserID: userID, ShortID: id})
+	}
+
+	select {
+	case <-d.stop:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case d.jobs <- requests:
+		return nil
+	case <-d.stop:
+		return ErrClosed
+	case <-ctx.Done():
+		return fmt.Errorf("enqueue deletion canceled: %w", ctx.Err())
+	}
+}
+
+// Flush дожидается удаления всех задач, поставленных в очередь до вызова.
+// При ошибке задачи остаются в буфере и удаляются при следующей попытке.
+func (d *Deleter) Flush(ctx context.Context) error {
+	ack := make(chan error, 1)
+	select {
+	case d.flushRequests <- ack:
+	case <-d.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return fmt.Errorf("flush canceled: %w", ctx.Err())
+	}
+
+	select {
+	case err := <-ack:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("flush canceled: %w", ctx.Err())
+	}
+}
+
+// Close прекращает приём задач, удаляет уже накопленные ссылки и останавливает горутину.
+// Если удалить их не удалось, задачи теряются, а ошибка возвращается.
+func (d *Deleter) Close(ctx context.Context) error {
+	d.closeOnce.Do(func() {
+		close(d.stop)
+	})
+
+	select {
+	case <-d.done:
+		return d.closeErr
+	case <-ctx.Done():
+		return fmt.Errorf("close deleter canceled: %w", ctx.Err())
+	}
+}
+
+func (d *Deleter) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.flushInterval)
+	defer ticker.Stop()
+
+	pending := make([]models.DeleteRequest, 0, d.batchSize)
+	// retrying — последняя попытка не удалась, и буфер ждёт повтора по таймеру.
+	var retrying bool
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := d.delete(pending); err != nil {
+			retrying = true
+			if dropped := len(pending) - maxPending; dropped > 0 {
+				d.logger.Error("Deletion backlog is full, dropping oldest requests", zap.Int("count", dropped))
+				pending = append(pending[:0], pending[dropped:]...)
+			}
+			return err
+		}
+		retrying = false
+		pending = make([]models.DeleteRequest, 0, d.batchSize)
+		return nil
+	}
+	logFailure := func(err error) {
+		if err != nil {
+			d.logger.Error("Failed to delete URLs, will retry", zap.Int("count", len(pending)), zap.Error(err))
+		}
+	}
+	// drain забирает из канала все задачи, которые уже успели в него попасть.
+	drain := func() {
+		for {
+			select {
+			case requests := <-d.jobs:
+				pending = append(pending, requests...)
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case requests := <-d.jobs:
+			pending = append(pending, requests...)
+			if len(pending) >= d.batchSize && !retrying {
+				logFailure(flush())
+			}
+		case <-ticker.C:
+			logFailure(flush())
+		case ack := <-d.flushRequests:
+			drain()
+			ack <- flush()
+		case <-d.stop:
+			drain()
+			d.closeErr = flush()
+			return
+		}
+	}
+}
+
+func (d *Deleter) delete(requests []models.DeleteRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	if err := d.store.DeleteURLs(ctx, requests); err != nil {
+		return fmt.Errorf("failed to delete %d URLs: %w", len(requests), err)
+	}
+	d.logger.Info("URLs deleted", zap.Int("count", len(requests)))
+	return nil
+}
@@ -0,0 +1,169 @@
+package deleter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// recordingStore запоминает пакеты удаления и возвращает ошибки из failures по очереди.
+type recordingStore struct {
+	mu       sync.Mutex
+	batches  [][]models.DeleteRequest
+	failures []error
+}
+
+func (s *recordingStore) DeleteURLs(_ context.Context, requests []models.DeleteRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.failures) > 0 {
+		err := s.failures[0]
+		s.failures = s.failures[1:]
+		if err != nil {
+			return err
+		}
+	}
+	s.batches = append(s.batches, append([]models.DeleteRequest(nil), requests...))
+	return nil
+}
+
+func (s *recordingStore) deleted() []models.DeleteRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var all []models.DeleteRequest
+	for _, batch := range s.batches {
+		all = append(all, batch...)
+	}
+	return all
+}
+
+func (s *recordingStore) batchSizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sizes := make([]int, len(s.batches))
+	for i, batch := range s.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func newTestDeleter(t *testing.T, store Store, batchSize int, flushInterval time.Duration) *Deleter {
+	t.Helper()
+
+	d := New(store, logger.NewZapLogger(zap.NewNop()), batchSize, flushInterval)
+	t.Cleanup(func() {
+		_ = d.Close(context.Background())
+	})
+	return d
+}
+
+func TestFanIn(t *testing.T) {
+	const (
+		users   = 8
+		perUser = 25
+	)
+	ctx := context.Background()
+	store := &recordingStore{}
+	d := newTestDeleter(t, store, DefaultBatchSize, time.Hour)
+
+	var wg sync.WaitGroup
+	for user := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perUser {
+				assert.NoError(t, d.Enqueue(ctx, fmt.Sprintf("user%d", user), []string{fmt.Sprintf("id%d-%d", user, i)}))
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, d.Flush(ctx))
+
+	deleted := store.deleted()
+	require.Len(t, deleted, users*perUser)
+	assert.Contains(t, deleted, models.DeleteRequest{UserID: "user3", ShortID: "id3-7"})
+}
+
+func TestBatching(t *testing.T) {
+	ctx := context.Background()
+	store := &recordingStore{}
+	// Таймер не срабатывает, поэтому пакет отправляется только по размеру.
+	d := newTestDeleter(t, store, 3, time.Hour)
+
+	require.NoError(t, d.Enqueue(ctx, "user", []string{"a", "b"}))
+	require.NoError(t, d.Enqueue(ctx, "user", []string{"c", "d"}))
+	require.Eventually(t, func() bool {
+		return len(store.batchSizes()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []int{4}, store.batchSizes())
+
+	require.NoError(t, d.Enqueue(ctx, "user", []string{"e"}))
+	require.NoError(t, d.Flush(ctx))
+	assert.Equal(t, []int{4, 1}, store.batchSizes())
+}
+
+func TestFlushInterval(t *testing.T) {
+	ctx := context.Background()
+	store := &recordingStore{}
+	d := newTestDeleter(t, store, DefaultBatchSize, 10*time.Millisecond)
+
+	require.NoError(t, d.Enqueue(ctx, "user", []string{"a"}))
+	assert.Eventually(t, func() bool {
+		return len(store.deleted()) == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestCloseDrainsQueue(t *testing.T) {
+	ctx := context.Background()
+	store := &recordingStore{}
+	d := New(store, logger.NewZapLogger(zap.NewNop()), DefaultBatchSize, time.Hour)
+
+	for i := range 10 {
+		require.NoError(t, d.Enqueue(ctx, "user", []string{fmt.Sprintf("id%d", i)}))
+	}
+	require.NoError(t, d.Close(ctx))
+	assert.Len(t, store.deleted(), 10)
+
+	require.ErrorIs(t, d.Enqueue(ctx, "user", []string{"late"}), ErrClosed)
+	require.ErrorIs(t, d.Flush(ctx), ErrClosed)
+	require.NoError(t, d.Close(ctx))
+}
+
+func TestFailedBatchIsRetried(t *testing.T) {
+	ctx := context.Background()
+	unavailable := errors.New("unavailable")
+	store := &recordingStore{failures: []error{unavailable}}
+	d := newTestDeleter(t, store, 2, time.Hour)
+
+	require.NoError(t, d.Enqueue(ctx, "user", []string{"a"}))
+	require.ErrorIs(t, d.Flush(ctx), unavailable)
+	assert.Empty(t, store.deleted())
+
+	// Задачи неудачного пакета удаляются вместе с новыми при следующей попытке.
+	require.NoError(t, d.Enqueue(ctx, "user", []string{"b"}))
+	require.NoError(t, d.Flush(ctx))
+	assert.Equal(t, []models.DeleteRequest{{UserID: "user", ShortID: "a"}, {UserID: "user", ShortID: "b"}},
+		store.deleted())
+}
+
+func TestCloseReportsFailedDrain(t *testing.T) {
+	ctx := context.Background()
+	unavailable := errors.New("unavailable")
+	store := &recordingStore{failures: []error{unavailable}}
+	d := New(store, logger.NewZapLogger(zap.NewNop()), DefaultBatchSize, time.Hour)
+
+	require.NoError(t, d.Enqueue(ctx, "user", []string{"a"}))
+	require.ErrorIs(t, d.Close(ctx), unavailable)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/transfer"
+	"go.uber.org/zap"
+)
+
+// ExportHandler потоково выгружает все ссылки, включая удалённые и просроченные,
+// в формате из параметра format: ndjson (по умолчанию) или csv. Счётчики переходов
+// включают ещё не записанные в хранилище.
+func (u *URLShortener) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	format, err := transfer.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="links.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+
+	var exported int
+	writer := transfer.NewWriter(w, format)
+	err = u.storage.Iterate(r.Context(), "", func(record models.URLRecord) error {
+		pending := u.clicks.Pending(record.ShortID)
+		record.Clicks += pending.Clicks
+		if pending.LastAccessedAt.After(record.LastAccessedAt) {
+			record.LastAccessedAt = pending.LastAccessedAt
+		}
+		exported++
+		return writer.Write(record)
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		u.logger.Error("Export failed", zap.Int("exported", exported), zap.Error(err))
+		// Статус уже отправлен, поэтому соединение обрывается: иначе клиент примет
+		// неполную выгрузку за целую.
+		panic(http.ErrAbortHandler)
+	}
+	u.logger.Info("Export completed", zap.String("format", string(format)), zap.Int("exported", exported))
+}
+
+// ImportHandler загружает выгрузку в формате из параметра format, сохраняя короткие ID.
+// Записи сохраняются порциями по мере чтения тела запроса, поэтому при ошибке уже
+// сохранённые порции остаются; повторная загрузка той же выгрузки безопасна.
+func (u *URLShortener) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	format, err := transfer.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reader, err := transfer.NewReader(r.Body, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report, err := transfer.Import(r.Context(), reader, u.storage, transfer.DefaultBatchSize)
+	if errors.Is(err, transfer.ErrMalformed) {
+		http.Error(w, fmt.Sprintf("%v. Imported before the error: %d", err, report.Created), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		u.logger.Error("Import failed", zap.Int("created", report.Created), zap.Error(err))
+		writeStorageError(w, err)
+		return
+	}
+	u.logger.Info("Import completed",
+		zap.String("format", string(format)),
+		zap.Int("read", report.Read),
+		zap.Int("created", report.Created),
+		zap.Int("conflicts", len(report.Conflicts)),
+	)
+
+	response := models.ImportResponse{
+		Read:           report.Read,
+		Created:        report.Created,
+		AlreadyPresent: report.AlreadyPresent,
+		Expired:        report.Expired,
+		Deleted:        report.Deleted,
+	}
+	for _, conflict := range report.Conflicts {
+		response.Conflicts = append(response.Conflicts, models.ImportConflict{
+			ShortID:     conflict.Record.ShortID,
+			OriginalURL: conflict.Record.OriginalURL,
+			Reason:      string(conflict.Kind),
+			ExistingID:  conflict.ExistingID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		u.logger.Error("error encoding response", zap.Error(err))
+	}
+}
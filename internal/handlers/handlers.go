@@ -14,20 +14,29 @@ import (
 	"strings"
 	"time"
 
+	"github.com/BrownBear56/contractor/internal/auth"
+	"github.com/BrownBear56/contractor/internal/clicks"
+	"github.com/BrownBear56/contractor/internal/deleter"
 	"github.com/BrownBear56/contractor/internal/logger"
 	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/reaper"
 	"github.com/BrownBear56/contractor/internal/storage"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 // URLShortener хранит базовый URL и объект Storage.
 type URLShortener struct {
-	storage    storage.Storage
-	logger     logger.Logger
-	dbConnPool *pgxpool.Pool
-	baseURL    string
+	storage storage.Storage
+	logger  logger.Logger
+	deleter *deleter.Deleter
+	reaper  *reaper.Reaper
+	clicks  *clicks.Tracker
+	baseURL string
+	// maxURLLength — наибольшая длина URL в байтах, 0 — без ограничения.
+	maxURLLength int
 }
 
 func generateID() (string, error) {
@@ -45,45 +54,110 @@ func (u *URLShortener) validateAndGetURL(body []byte) (string, error) {
 	if originalURL == "" {
 		return "", errors.New("empty URL")
 	}
+	if u.maxURLLength > 0 && len(originalURL) > u.maxURLLength {
+		return "", fmt.Errorf("URL is too long: %d bytes, limit %d", len(originalURL), u.maxURLLength)
+	}
 	if _, err := url.ParseRequestURI(originalURL); err != nil {
 		return "", errors.New("invalid URL format")
 	}
 	return originalURL, nil
 }
 
-func (u *URLShortener) getShortURL(originalURL string) (string, bool, error) {
-	// Проверяем, существует ли уже такой URL.
-	if existingID, ok := u.storage.GetIDByURL(originalURL); ok {
-		return existingID, true, nil
+// storageErrorStatus сопоставляет ошибку хранилища с HTTP-статусом ответа.
+func storageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errs.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errs.ErrDeleted), errors.Is(err, errs.ErrExpired):
+		return http.StatusGone
+	case errors.Is(err, errs.ErrIDConflict), errors.Is(err, errs.ErrURLConflict):
+		return http.StatusConflict
+	case errors.Is(err, errs.ErrURLTooLong), errors.Is(err, errs.ErrValueTooLong):
+		return http.StatusBadRequest
+	case errors.Is(err, errs.ErrUnavailable), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeStorageError отвечает клиенту статусом, соответствующим ошибке хранилища.
+func writeStorageError(w http.ResponseWriter, err error) {
+	status := storageErrorStatus(err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// expirationTime вычисляет момент истечения ссылки по полям expires_at и ttl запроса.
+// Нулевое время означает бессрочную ссылку.
+func expirationTime(expiresAt *time.Time, ttl int64, now time.Time) (time.Time, error) {
+	switch {
+	case expiresAt != nil && ttl != 0:
+		return time.Time{}, errors.New("expires_at and ttl are mutually exclusive")
+	case expiresAt != nil:
+		if !expiresAt.After(now) {
+			return time.Time{}, errors.New("expires_at must be in the future")
+		}
+		return *expiresAt, nil
+	case ttl < 0:
+		return time.Time{}, errors.New("ttl must be positive")
+	case ttl > 0:
+		return now.Add(time.Duration(ttl) * time.Second), nil
+	default:
+		return time.Time{}, nil
+	}
+}
+
+// saveBatch сохраняет записи пакета и возвращает итоговый результат для каждой из них.
+// Записи, чей сгенерированный ID оказался занят, получают новый ID и сохраняются повторно.
+func (u *URLShortener) saveBatch(ctx context.Context, records []models.URLRecord) ([]models.BatchResult, error) {
+	results := make([]models.BatchResult, len(records))
+	pending := make([]int, len(records))
+	for i := range records {
+		pending[i] = i
 	}
 
 	const maxRetries = 10
-	var id string
 	for range maxRetries {
-		generatedID, err := generateID()
+		batch := make([]models.URLRecord, len(pending))
+		for j, i := range pending {
+			batch[j] = records[i]
+		}
+
+		batchResults, err := u.storage.SaveBatch(ctx, batch)
 		if err != nil {
-			u.logger.Error("Error generating ID: %v\n", zap.Error(err))
-			continue
+			return nil, fmt.Errorf("failed to save batch: %w", err)
 		}
-		id = generatedID
-		break
-	}
 
-	if id == "" {
-		return "", false, errors.New("all attempts to generate a unique ID failed")
+		retry := pending[:0]
+		for j, result := range batchResults {
+			i := pending[j]
+			if result.Status == models.BatchFailed && errors.Is(result.Err, errs.ErrIDConflict) {
+				id, err := generateID()
+				if err != nil {
+					return nil, err
+				}
+				records[i].ShortID = id
+				retry = append(retry, i)
+				continue
+			}
+			results[i] = result
+		}
+
+		if len(retry) == 0 {
+			return results, nil
+		}
+		pending = retry
 	}
 
-	return id, false, nil
+	return nil, errors.New("all attempts to generate unique IDs for batch failed")
 }
 
-func (u *URLShortener) getOrCreateShortURL(originalURL string) (string, bool, error) {
-	// Проверяем, существует ли уже такой URL.
-	if existingID, ok := u.storage.GetIDByURL(originalURL); ok {
-		return fmt.Sprintf("%s/%s", u.baseURL, existingID), true, nil
-	}
+func (u *URLShortener) getOrCreateShortURL(
+	ctx context.Context, originalURL string, expiresAt time.Time,
+) (string, bool, error) {
+	userID, _ := auth.UserIDFromContext(ctx)
 
 	const maxRetries = 10
-	var id string
 	for range maxRetries {
 		generatedID, err := generateID()
 		if err != nil {
@@ -91,22 +165,27 @@ func (u *URLShortener) getOrCreateShortURL(originalURL string) (string, bool, er
 			continue
 		}
 
-		if err := u.storage.SaveID(generatedID, originalURL); err == nil {
-			id = generatedID
-			break
+		// Хранилище само решает, чей ID победил, поэтому гонки между запросами нет.
+		id, existed, err := u.storage.GetOrCreate(ctx, models.URLRecord{
+			ShortID:     generatedID,
+			OriginalURL: originalURL,
+			UserID:      userID,
+			ExpiresAt:   expiresAt,
+		})
+		if errors.Is(err, errs.ErrIDConflict) {
+			continue
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("failed to save URL: %w", err)
 		}
-	}
 
-	if id == "" {
-		return "", false, errors.New("all attempts to generate a unique ID failed")
+		return fmt.Sprintf("%s/%s", u.baseURL, id), existed, nil
 	}
 
-	return fmt.Sprintf("%s/%s", u.baseURL, id), false, nil
+	return "", false, errors.New("all attempts to generate a unique ID failed")
 }
 
-func NewURLShortener(baseURL string, fileStoragePath string,
-	dbDSN string, useFile bool, parentLogger logger.Logger,
-) *URLShortener {
+func NewURLShortener(baseURL string, storageConfig storage.Config, parentLogger logger.Logger) *URLShortener {
 	// Настройки для нового логгера.
 	customEncoderConfig := zapcore.EncoderConfig{
 		TimeKey:       "timestamp",
@@ -131,33 +210,67 @@ func NewURLShortener(baseURL string, fileStoragePath string,
 		log.Fatalf("Failed to reconfigure logger: %v", err)
 	}
 
-	var dbPool *pgxpool.Pool
-	if dbDSN != "" {
-		config, err := pgxpool.ParseConfig(dbDSN)
-		if err != nil {
-			handlerLogger.Fatal("Failed to parse database DSN", zap.Error(err))
-		}
+	store := storage.NewStorage(storageConfig, parentLogger)
 
-		dbPool, err = pgxpool.New(context.Background(), config.ConnString())
-		if err != nil {
-			handlerLogger.Fatal("Failed to create connection pool", zap.Error(err))
-		}
+	return &URLShortener{
+		baseURL:      baseURL,
+		maxURLLength: storageConfig.MaxURLLength,
+		storage:      store,
+		logger:       handlerLogger,
+		deleter: deleter.New(
+			store, handlerLogger.Named("Deleter"), deleter.DefaultBatchSize, deleter.DefaultFlushInterval),
+		reaper: reaper.New(store, handlerLogger.Named("Reaper"), reaper.DefaultInterval),
+		clicks: clicks.New(store, handlerLogger.Named("Clicks"), clicks.DefaultFlushInterval),
 	}
+}
 
-	return &URLShortener{
-		baseURL:    baseURL,
-		storage:    storage.NewStorage(fileStoragePath, useFile, dbDSN, parentLogger),
-		logger:     handlerLogger,
-		dbConnPool: dbPool,
+// Close по порядку останавливает фоновые задачи и закрывает хранилище: сначала записываются
+// накопленные переходы и удаления, затем хранилище сбрасывается на диск и закрывается.
+// Шаги выполняются и после ошибки предыдущего, чтобы по возможности освободить все ресурсы.
+func (u *URLShortener) Close(ctx context.Context) error {
+	steps := []struct {
+		run  func(context.Context) error
+		name string
+	}{
+		{name: "clicks", run: u.clicks.Close},
+		{name: "deleter", run: u.deleter.Close},
+		{name: "reaper", run: u.reaper.Close},
+		{name: "storage flush", run: u.storage.Flush},
+		{name: "storage close", run: u.storage.Close},
+	}
+
+	var failures []error
+	for _, step := range steps {
+		started := time.Now()
+		if err := step.run(ctx); err != nil {
+			u.logger.Error("Shutdown step failed", zap.String("step", step.name), zap.Error(err))
+			failures = append(failures, fmt.Errorf("%s: %w", step.name, err))
+			continue
+		}
+		u.logger.Info("Shutdown step completed",
+			zap.String("step", step.name), zap.Duration("duration", time.Since(started)))
 	}
+	return errors.Join(failures...)
+}
+
+// ClientMiddleware передаёт хранилищу ID пользователя, установленный auth.AuthMiddleware:
+// при чтении с реплик пользователь сразу видит собственные записи.
+func ClientMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID, _ := auth.UserIDFromContext(r.Context()); userID != "" {
+			r = r.WithContext(storage.WithClient(r.Context(), userID))
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
+// PingHandler проверяет доступность хранилища. Хранилища без базы данных всегда доступны.
 func (u *URLShortener) PingHandler(w http.ResponseWriter, r *http.Request) {
 	const dbPingTimeout = 2 * time.Second
-	ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+	ctx, cancel := context.WithTimeout(r.Context(), dbPingTimeout)
 	defer cancel()
 
-	if err := u.dbConnPool.Ping(ctx); err != nil {
+	if err := storage.Ping(ctx, u.storage); err != nil {
 		u.logger.Error("Database connection error", zap.Error(err))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -180,9 +293,8 @@ func (u *URLShortener) PostBatchHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Подготовка данных для сохранения
-	pairs := make(map[string]string)
-	batchResults := make([]models.BatchResponse, 0, len(requests))
-
+	userID, _ := auth.UserIDFromContext(r.Context())
+	records := make([]models.URLRecord, 0, len(requests))
 	for _, req := range requests {
 		originalURL, err := u.validateAndGetURL([]byte(req.OriginalURL))
 		if err != nil {
@@ -192,33 +304,116 @@ func (u *URLShortener) PostBatchHandler(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		id, _, err := u.getShortURL(originalURL)
+		expiresAt, err := expirationTime(req.ExpiresAt, req.TTL, time.Now())
 		if err != nil {
-			u.logger.Error("failed to process URL", zap.String("originalURL", originalURL), zap.Error(err))
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			http.Error(
+				w, fmt.Sprintf("Invalid expiration in batch. Correlation ID: %s. Error: %v", req.CorrelationID, err),
+				http.StatusBadRequest)
 			return
 		}
 
-		pairs[id] = originalURL
+		id, err := generateID()
+		if err != nil {
+			u.logger.Error("failed to generate ID", zap.String("originalURL", originalURL), zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
 
-		// Формируем результат
-		batchResults = append(batchResults, models.BatchResponse{
-			CorrelationID: req.CorrelationID,                   // Оригинальный correlationID
-			ShortURL:      fmt.Sprintf("%s/%s", u.baseURL, id), // Сформированный короткий URL
+		records = append(records, models.URLRecord{
+			ShortID:     id,
+			OriginalURL: originalURL,
+			UserID:      userID,
+			ExpiresAt:   expiresAt,
 		})
 	}
 
-	if err := u.storage.SaveBatch(pairs); err != nil {
+	results, err := u.saveBatch(r.Context(), records)
+	if err != nil {
 		u.logger.Error("Save batch error", zap.Error(err))
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeStorageError(w, err)
 		return
 	}
 
+	// Формируем результат: для уже сохранённых URL возвращаем существующий ID.
+	batchResults := make([]models.BatchResponse, 0, len(requests))
+	for i, result := range results {
+		if result.Status == models.BatchFailed {
+			u.logger.Error("Save batch item error",
+				zap.String("correlationID", requests[i].CorrelationID), zap.Error(result.Err))
+			writeStorageError(w, result.Err)
+			return
+		}
+
+		batchResults = append(batchResults, models.BatchResponse{
+			CorrelationID: requests[i].CorrelationID,                       // Оригинальный correlationID
+			ShortURL:      fmt.Sprintf("%s/%s", u.baseURL, result.ShortID), // Сохранённый короткий URL
+		})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	_ = json.NewEncoder(w).Encode(batchResults)
 }
 
+// GetUserURLsHandler возвращает ссылки, сокращённые текущим пользователем.
+func (u *URLShortener) GetUserURLsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	records, err := u.storage.GetUserURLs(r.Context(), userID)
+	if err != nil {
+		u.logger.Error("failed to get user URLs", zap.String("userID", userID), zap.Error(err))
+		writeStorageError(w, err)
+		return
+	}
+
+	if len(records) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response := make([]models.UserURLResponse, 0, len(records))
+	for _, record := range records {
+		response = append(response, models.UserURLResponse{
+			ShortURL:    fmt.Sprintf("%s/%s", u.baseURL, record.ShortID),
+			OriginalURL: record.OriginalURL,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		u.logger.Error("error encoding response", zap.String("userID", userID), zap.Error(err))
+	}
+}
+
+// DeleteUserURLsHandler ставит в очередь удаление ссылок текущего пользователя
+// и отвечает 202, не дожидаясь удаления.
+func (u *URLShortener) DeleteUserURLsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	var shortIDs []string
+	if err := json.NewDecoder(r.Body).Decode(&shortIDs); err != nil || len(shortIDs) == 0 {
+		http.Error(w, "Invalid or empty list of IDs", http.StatusBadRequest)
+		return
+	}
+
+	if err := u.deleter.Enqueue(r.Context(), userID, shortIDs); err != nil {
+		u.logger.Error("failed to enqueue deletion", zap.String("userID", userID), zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
 func (u *URLShortener) PostJSONHandler(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -238,10 +433,16 @@ func (u *URLShortener) PostJSONHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	shortURL, ok, err := u.getOrCreateShortURL(originalURL)
+	expiresAt, err := expirationTime(request.ExpiresAt, request.TTL, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	shortURL, ok, err := u.getOrCreateShortURL(r.Context(), originalURL, expiresAt)
 	if err != nil {
 		u.logger.Error("failed to process URL", zap.String("originalURL", originalURL), zap.Error(err))
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeStorageError(w, err)
 		return
 	}
 
@@ -272,10 +473,10 @@ func (u *URLShortener) PostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	shortURL, ok, err := u.getOrCreateShortURL(originalURL)
+	shortURL, ok, err := u.getOrCreateShortURL(r.Context(), originalURL, time.Time{})
 	if err != nil {
 		u.logger.Error("failed to process URL", zap.String("originalURL", originalURL), zap.Error(err))
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		writeStorageError(w, err)
 		return
 	}
 
@@ -295,12 +496,68 @@ func (u *URLShortener) GetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	originalURL, ok := u.storage.Get(id)
-	if !ok {
-		http.Error(w, "ID not found", http.StatusBadRequest)
+	originalURL, err := u.storage.Get(r.Context(), id)
+	if errors.Is(err, errs.ErrNotFound) {
+		http.Error(w, "ID not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errs.ErrDeleted) {
+		http.Error(w, "URL deleted", http.StatusGone)
+		return
+	}
+	if errors.Is(err, errs.ErrExpired) {
+		http.Error(w, "URL expired", http.StatusGone)
+		return
+	}
+	if err != nil {
+		u.logger.Error("failed to get URL", zap.String("id", id), zap.Error(err))
+		writeStorageError(w, err)
 		return
 	}
 
+	// Переход учитывается в памяти и записывается в хранилище фоново.
+	u.clicks.Record(id, time.Now())
+
 	w.Header().Set("Location", originalURL)
 	w.WriteHeader(http.StatusTemporaryRedirect)
 }
+
+// GetStatsHandler возвращает число переходов по ссылке и время последнего из них,
+// включая переходы, ещё не записанные в хранилище.
+func (u *URLShortener) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	stat, err := u.storage.GetStats(r.Context(), id)
+	if errors.Is(err, errs.ErrNotFound) {
+		http.Error(w, "ID not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		u.logger.Error("failed to get stats", zap.String("id", id), zap.Error(err))
+		writeStorageError(w, err)
+		return
+	}
+
+	pending := u.clicks.Pending(id)
+	response := models.StatsResponse{
+		ShortURL: fmt.Sprintf("%s/%s", u.baseURL, id),
+		Clicks:   stat.Clicks + pending.Clicks,
+	}
+	lastAccessedAt := stat.LastAccessedAt
+	if pending.LastAccessedAt.After(lastAccessedAt) {
+		lastAccessedAt = pending.LastAccessedAt
+	}
+	if !lastAccessedAt.IsZero() {
+		response.LastAccessedAt = &lastAccessedAt
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		u.logger.Error("error encoding response", zap.String("id", id), zap.Error(err))
+	}
+}
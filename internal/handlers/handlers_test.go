@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -9,13 +12,28 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/BrownBear56/contractor/internal/auth"
 	"github.com/BrownBear56/contractor/internal/logger"
 	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage"
+	"github.com/BrownBear56/contractor/internal/storage/bitcask"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
+// testStorageConfig выбирает PostgreSQL, если задана строка подключения, иначе файл filePath.
+func testStorageConfig(filePath, databaseDSN string) storage.Config {
+	if databaseDSN != "" {
+		return storage.Config{URI: databaseDSN, DatabaseDSN: databaseDSN}
+	}
+	return storage.Config{URI: storage.PathURI(storage.SchemeFile, filePath)}
+}
+
 func TestPostBatchHandler(t *testing.T) {
 	tests := []struct {
 		name              string
@@ -81,7 +99,8 @@ func TestPostBatchHandler(t *testing.T) {
 
 	testDBConnString := ""
 
-	urlShortener := NewURLShortener("http://localhost:8080", filePath, testDBConnString, true, testLogger)
+	urlShortener := NewURLShortener("http://localhost:8080",
+		testStorageConfig(filePath, testDBConnString), testLogger)
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -123,6 +142,67 @@ func TestPostBatchHandler(t *testing.T) {
 	}
 }
 
+func TestPostBatchHandlerExistingURLs(t *testing.T) {
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "storage_test.json")
+	zapLogger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Errorf("Failed to initialize logger: %v", err)
+		return
+	}
+	defer func() {
+		_ = zapLogger.Sync()
+	}()
+
+	testLogger := logger.NewZapLogger(zapLogger)
+
+	urlShortener := NewURLShortener("http://localhost:8080", testStorageConfig(filePath, ""), testLogger)
+
+	// Сокращаем URL заранее, чтобы пакет содержал уже сохранённую ссылку.
+	w := httptest.NewRecorder()
+	urlShortener.PostHandler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("http://example.com/old")))
+	existingResp := w.Result()
+	existingBody, err := io.ReadAll(existingResp.Body)
+	assert.NoError(t, err, "failed to read response body")
+	assert.NoError(t, existingResp.Body.Close())
+	existingShortURL := string(existingBody)
+
+	body := `[
+		{"correlation_id": "1", "original_url": "http://example.com/old"},
+		{"correlation_id": "2", "original_url": "http://example.com/new"},
+		{"correlation_id": "3", "original_url": "http://example.com/new"}
+	]`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	urlShortener.PostBatchHandler(w, req)
+
+	resp := w.Result()
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			t.Errorf("failed to close response body: %v", err)
+		}
+	}()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "unexpected status code")
+
+	var responses []models.BatchResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&responses), "failed to decode response JSON")
+	if !assert.Len(t, responses, 3, "unexpected response count") {
+		return
+	}
+
+	assert.Equal(t, existingShortURL, responses[0].ShortURL, "expected existing short URL for known URL")
+	assert.Equal(t, responses[1].ShortURL, responses[2].ShortURL, "expected duplicates to share short URL")
+	assert.NotEqual(t, existingShortURL, responses[1].ShortURL, "expected new short URL for new URL")
+
+	// Все возвращённые ссылки должны действительно вести на исходные URL.
+	for i, expectedURL := range []string{"http://example.com/old", "http://example.com/new"} {
+		id := strings.TrimPrefix(responses[i].ShortURL, "http://localhost:8080/")
+		originalURL, err := urlShortener.storage.Get(context.Background(), id)
+		assert.NoError(t, err, "expected returned short URL to exist")
+		assert.Equal(t, expectedURL, originalURL, "unexpected original URL")
+	}
+}
+
 func TestPostJSONHandler(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -160,6 +240,30 @@ func TestPostJSONHandler(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedPrefix: "",
 		},
+		{
+			name:           "Valid TTL",
+			body:           `{"url": "http://example.com/ttl", "ttl": 3600}`,
+			expectedStatus: http.StatusCreated,
+			expectedPrefix: "http://localhost:8080/",
+		},
+		{
+			name:           "Negative TTL",
+			body:           `{"url": "http://example.com/negative", "ttl": -1}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedPrefix: "",
+		},
+		{
+			name:           "Expiration in the past",
+			body:           `{"url": "http://example.com/past", "expires_at": "2000-01-01T00:00:00Z"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedPrefix: "",
+		},
+		{
+			name:           "Both expiration and TTL",
+			body:           `{"url": "http://example.com/both", "expires_at": "2999-01-01T00:00:00Z", "ttl": 60}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedPrefix: "",
+		},
 	}
 
 	// Создаём временную директорию для теста.
@@ -179,7 +283,8 @@ func TestPostJSONHandler(t *testing.T) {
 	testDBConnString := ""
 
 	// Устанавливаем базовый URL для тестов.
-	urlShortener := NewURLShortener("http://localhost:8080", filePath, testDBConnString, true, testLogger)
+	urlShortener := NewURLShortener("http://localhost:8080",
+		testStorageConfig(filePath, testDBConnString), testLogger)
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -275,7 +380,8 @@ func TestPostHandler(t *testing.T) {
 	testDBConnString := ""
 
 	// Устанавливаем базовый URL для тестов.
-	urlShortener := NewURLShortener("http://localhost:8080", filePath, testDBConnString, true, testLogger)
+	urlShortener := NewURLShortener("http://localhost:8080",
+		testStorageConfig(filePath, testDBConnString), testLogger)
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -328,8 +434,9 @@ func TestGetHandler(t *testing.T) {
 
 	testDBConnString := ""
 
-	urlShortener := NewURLShortener("http://localhost:8080", filePath, testDBConnString, true, testLogger)
-	if err := urlShortener.storage.SaveID(testID, testURL); err != nil {
+	urlShortener := NewURLShortener("http://localhost:8080",
+		testStorageConfig(filePath, testDBConnString), testLogger)
+	if err := urlShortener.storage.SaveID(context.Background(), models.URLRecord{ShortID: testID, OriginalURL: testURL}); err != nil {
 		t.Errorf("Failed to save url in memory: %v", err)
 		return
 	}
@@ -349,7 +456,7 @@ func TestGetHandler(t *testing.T) {
 		{
 			name:           "Invalid ID",
 			path:           "/invalidID",
-			expectedStatus: http.StatusBadRequest,
+			expectedStatus: http.StatusNotFound,
 			expectedHeader: "",
 		},
 		{
@@ -404,12 +511,18 @@ func TestConcurrentAccess(t *testing.T) {
 
 	testDBConnString := ""
 
-	urlShortener := NewURLShortener("http://localhost:8080", filePath, testDBConnString, true, testLogger)
+	urlShortener := NewURLShortener("http://localhost:8080",
+		testStorageConfig(filePath, testDBConnString), testLogger)
 
 	var wg sync.WaitGroup
 	const goroutines = 100
 	url := "http://example.com"
 
+	// Ответы всех запросов, чтобы проверить, что победил ровно один ID.
+	var mu sync.Mutex
+	created := 0
+	shortURLs := make(map[string]struct{})
+
 	wg.Add(goroutines)
 	goroutineIndices := make([]int, goroutines)
 	for range goroutineIndices {
@@ -428,14 +541,548 @@ func TestConcurrentAccess(t *testing.T) {
 				}
 			}()
 
-			// Статус может быть 201 или 200.
+			// Статус может быть 201 или 409.
 			assert.True(t, resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusConflict)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err, "failed to read response body")
+
+			mu.Lock()
+			defer mu.Unlock()
+			if resp.StatusCode == http.StatusCreated {
+				created++
+			}
+			shortURLs[string(body)] = struct{}{}
 		}()
 	}
 	wg.Wait()
 
-	// Проверяем, что URL был сохранен только один раз.
-	id, exists := urlShortener.storage.GetIDByURL(url)
-	assert.True(t, exists, "expected URL to be saved")
+	// Проверяем, что URL был сохранен только один раз и все клиенты получили один и тот же ID.
+	id, err := urlShortener.storage.GetIDByURL(context.Background(), url)
+	assert.NoError(t, err, "expected URL to be saved")
 	assert.NotEmpty(t, id, "expected non-empty ID")
+	assert.Equal(t, 1, created, "expected exactly one request to create the URL")
+	assert.Equal(t, map[string]struct{}{"http://localhost:8080/" + id: {}}, shortURLs,
+		"expected every request to receive the stored short URL")
+}
+
+func TestStorageErrorStatus(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+	}{
+		{name: "Not found", err: fmt.Errorf("ID x: %w", errs.ErrNotFound), expectedStatus: http.StatusNotFound},
+		{name: "Deleted", err: errs.ErrDeleted, expectedStatus: http.StatusGone},
+		{name: "Expired", err: errs.ErrExpired, expectedStatus: http.StatusGone},
+		{name: "ID conflict", err: errs.ErrIDConflict, expectedStatus: http.StatusConflict},
+		{name: "URL conflict", err: errs.ErrURLConflict, expectedStatus: http.StatusConflict},
+		{name: "Unavailable", err: errs.ErrUnavailable, expectedStatus: http.StatusServiceUnavailable},
+		{name: "Deadline", err: context.DeadlineExceeded, expectedStatus: http.StatusServiceUnavailable},
+		{name: "Unknown", err: errors.New("boom"), expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedStatus, storageErrorStatus(tt.err))
+		})
+	}
+}
+
+func TestGetUserURLsHandler(t *testing.T) {
+	zapLogger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Errorf("Failed to initialize logger: %v", err)
+		return
+	}
+	defer func() {
+		_ = zapLogger.Sync()
+	}()
+
+	testLogger := logger.NewZapLogger(zapLogger)
+
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "storage_test.json")
+
+	urlShortener := NewURLShortener("http://localhost:8080", testStorageConfig(filePath, ""), testLogger)
+
+	// Владелец ссылок сохраняет их с cookie пользователя.
+	ownerCtx := auth.WithUserID(context.Background(), "owner")
+	for _, body := range []string{"http://example.com/1", "http://example.com/2"} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)).WithContext(ownerCtx)
+		w := httptest.NewRecorder()
+		urlShortener.PostHandler(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code, "unexpected status code")
+	}
+
+	tests := []struct {
+		name             string
+		userID           string
+		expectedStatus   int
+		expectedOriginal []string
+	}{
+		{
+			name:             "Owner",
+			userID:           "owner",
+			expectedStatus:   http.StatusOK,
+			expectedOriginal: []string{"http://example.com/1", "http://example.com/2"},
+		},
+		{
+			name:           "User without URLs",
+			userID:         "stranger",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "No cookie",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls", http.NoBody)
+			if tt.userID != "" {
+				req = req.WithContext(auth.WithUserID(req.Context(), tt.userID))
+			}
+			w := httptest.NewRecorder()
+
+			urlShortener.GetUserURLsHandler(w, req)
+
+			resp := w.Result()
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					t.Errorf("failed to close response body: %v", err)
+				}
+			}()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode, "unexpected status code")
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response []models.UserURLResponse
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response), "failed to decode response JSON")
+
+			originalURLs := make([]string, 0, len(response))
+			for _, item := range response {
+				assert.True(t, strings.HasPrefix(item.ShortURL, "http://localhost:8080/"), "unexpected ShortURL format")
+				originalURLs = append(originalURLs, item.OriginalURL)
+			}
+			assert.Equal(t, tt.expectedOriginal, originalURLs, "unexpected user URLs")
+		})
+	}
+}
+
+func TestDeleteUserURLsHandler(t *testing.T) {
+	zapLogger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Errorf("Failed to initialize logger: %v", err)
+		return
+	}
+	defer func() {
+		_ = zapLogger.Sync()
+	}()
+
+	testLogger := logger.NewZapLogger(zapLogger)
+
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "storage_test.json")
+
+	urlShortener := NewURLShortener("http://localhost:8080", testStorageConfig(filePath, ""), testLogger)
+
+	records := []models.URLRecord{
+		{ShortID: "own", OriginalURL: "http://example.com/own", UserID: "owner"},
+		{ShortID: "foreign", OriginalURL: "http://example.com/foreign", UserID: "stranger"},
+	}
+	for _, record := range records {
+		if err := urlShortener.storage.SaveID(context.Background(), record); err != nil {
+			t.Errorf("Failed to save url: %v", err)
+			return
+		}
+	}
+
+	tests := []struct {
+		name           string
+		userID         string
+		body           string
+		expectedStatus int
+	}{
+		{name: "No cookie", body: `["own"]`, expectedStatus: http.StatusUnauthorized},
+		{name: "Invalid JSON", userID: "owner", body: `{invalid}`, expectedStatus: http.StatusBadRequest},
+		{name: "Empty list", userID: "owner", body: `[]`, expectedStatus: http.StatusBadRequest},
+		{name: "Valid request", userID: "owner", body: `["own", "foreign"]`, expectedStatus: http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(tt.body))
+			if tt.userID != "" {
+				req = req.WithContext(auth.WithUserID(req.Context(), tt.userID))
+			}
+			w := httptest.NewRecorder()
+
+			urlShortener.DeleteUserURLsHandler(w, req)
+
+			resp := w.Result()
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					t.Errorf("failed to close response body: %v", err)
+				}
+			}()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode, "unexpected status code")
+		})
+	}
+
+	// Дожидаемся фонового удаления: своя ссылка удалена, чужая продолжает работать.
+	assert.NoError(t, urlShortener.deleter.Flush(context.Background()))
+
+	for id, expectedStatus := range map[string]int{"own": http.StatusGone, "foreign": http.StatusTemporaryRedirect} {
+		w := httptest.NewRecorder()
+		urlShortener.GetHandler(w, httptest.NewRequest(http.MethodGet, "/"+id, http.NoBody))
+		assert.Equal(t, expectedStatus, w.Code, "unexpected status code for %s", id)
+	}
+
+	// Надгробие в файле должно пережить перезапуск.
+	restarted := NewURLShortener("http://localhost:8080", testStorageConfig(filePath, ""), testLogger)
+	_, err = restarted.storage.Get(context.Background(), "own")
+	assert.ErrorIs(t, err, errs.ErrDeleted, "expected deletion to be persisted")
+}
+
+func TestExpiredURLs(t *testing.T) {
+	zapLogger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Errorf("Failed to initialize logger: %v", err)
+		return
+	}
+	defer func() {
+		_ = zapLogger.Sync()
+	}()
+
+	testLogger := logger.NewZapLogger(zapLogger)
+
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "storage_test.json")
+
+	urlShortener := NewURLShortener("http://localhost:8080", testStorageConfig(filePath, ""), testLogger)
+
+	ctx := context.Background()
+	records := []models.URLRecord{
+		{ShortID: "expired", OriginalURL: "http://example.com/expired", ExpiresAt: time.Now().Add(-time.Minute)},
+		{ShortID: "alive", OriginalURL: "http://example.com/alive", ExpiresAt: time.Now().Add(time.Hour)},
+	}
+	for _, record := range records {
+		if err := urlShortener.storage.SaveID(ctx, record); err != nil {
+			t.Errorf("Failed to save url: %v", err)
+			return
+		}
+	}
+
+	for id, expectedStatus := range map[string]int{"expired": http.StatusGone, "alive": http.StatusTemporaryRedirect} {
+		w := httptest.NewRecorder()
+		urlShortener.GetHandler(w, httptest.NewRequest(http.MethodGet, "/"+id, http.NoBody))
+		assert.Equal(t, expectedStatus, w.Code, "unexpected status code for %s", id)
+	}
+
+	// Очистка удаляет просроченную ссылку и из памяти, и из файла.
+	count, err := urlShortener.storage.DeleteExpired(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count, "expected one expired URL to be deleted")
+
+	restarted := NewURLShortener("http://localhost:8080", testStorageConfig(filePath, ""), testLogger)
+	_, err = restarted.storage.Get(ctx, "expired")
+	assert.ErrorIs(t, err, errs.ErrNotFound, "expected expired URL to be purged from file")
+	originalURL, err := restarted.storage.Get(ctx, "alive")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/alive", originalURL)
+}
+
+func TestGetStatsHandler(t *testing.T) {
+	zapLogger, err := zap.NewDevelopment()
+	if err != nil {
+		t.Errorf("Failed to initialize logger: %v", err)
+		return
+	}
+	defer func() {
+		_ = zapLogger.Sync()
+	}()
+
+	testLogger := logger.NewZapLogger(zapLogger)
+
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "storage_test.json")
+
+	urlShortener := NewURLShortener("http://localhost:8080", testStorageConfig(filePath, ""), testLogger)
+
+	ctx := context.Background()
+	record := models.URLRecord{ShortID: "stats", OriginalURL: "http://example.com/stats"}
+	if err := urlShortener.storage.SaveID(ctx, record); err != nil {
+		t.Errorf("Failed to save url: %v", err)
+		return
+	}
+
+	const redirects = 3
+	for range redirects {
+		w := httptest.NewRecorder()
+		urlShortener.GetHandler(w, httptest.NewRequest(http.MethodGet, "/stats", http.NoBody))
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code, "unexpected status code")
+	}
+
+	getStats := func(id string) (int, models.StatsResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/stats/"+id, http.NoBody)
+		routeCtx := chi.NewRouteContext()
+		routeCtx.URLParams.Add("id", id)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx))
+		w := httptest.NewRecorder()
+
+		urlShortener.GetStatsHandler(w, req)
+
+		var response models.StatsResponse
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&response), "failed to decode response JSON")
+		}
+		return w.Code, response
+	}
+
+	// Переходы ещё в буфере, но уже видны в статистике.
+	status, response := getStats("stats")
+	assert.Equal(t, http.StatusOK, status, "unexpected status code")
+	assert.Equal(t, int64(redirects), response.Clicks, "unexpected clicks before flush")
+	assert.NotNil(t, response.LastAccessedAt, "expected last access time")
+
+	// После записи в хранилище счётчик не удваивается и переживает перезапуск.
+	assert.NoError(t, urlShortener.clicks.Flush(ctx))
+	status, response = getStats("stats")
+	assert.Equal(t, http.StatusOK, status, "unexpected status code")
+	assert.Equal(t, int64(redirects), response.Clicks, "unexpected clicks after flush")
+
+	restarted := NewURLShortener("http://localhost:8080", testStorageConfig(filePath, ""), testLogger)
+	stat, err := restarted.storage.GetStats(ctx, "stats")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(redirects), stat.Clicks, "expected clicks to be persisted")
+
+	status, _ = getStats("unknown")
+	assert.Equal(t, http.StatusNotFound, status, "unexpected status code for unknown ID")
+}
+
+func TestClose(t *testing.T) {
+	testLogger := logger.NewZapLogger(zap.NewNop())
+	filePath := filepath.Join(t.TempDir(), "storage_test.json")
+	urlShortener := NewURLShortener("http://localhost:8080", testStorageConfig(filePath, ""), testLogger)
+
+	ctx := context.Background()
+	for _, record := range []models.URLRecord{
+		{ShortID: "clicked", OriginalURL: "http://example.com/clicked"},
+		{ShortID: "deleted", OriginalURL: "http://example.com/deleted", UserID: "owner"},
+	} {
+		require.NoError(t, urlShortener.storage.SaveID(ctx, record))
+	}
+
+	// Переход и удаление ещё не записаны в хранилище: их должен записать Close.
+	urlShortener.clicks.Record("clicked", time.Now())
+	require.NoError(t, urlShortener.deleter.Enqueue(ctx, "owner", []string{"deleted"}))
+	require.NoError(t, urlShortener.Close(ctx))
+
+	restarted := NewURLShortener("http://localhost:8080", testStorageConfig(filePath, ""), testLogger)
+	defer func() {
+		assert.NoError(t, restarted.Close(ctx))
+	}()
+	stat, err := restarted.storage.GetStats(ctx, "clicked")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stat.Clicks)
+	_, err = restarted.storage.Get(ctx, "deleted")
+	assert.ErrorIs(t, err, errs.ErrDeleted)
+}
+
+// TestPingHandler проверяет, что хранилища без базы данных отвечают на /ping как доступные.
+func TestPingHandler(t *testing.T) {
+	testLogger := logger.NewZapLogger(zap.NewNop())
+	for name, storageConfig := range map[string]storage.Config{
+		"memory": {URI: storage.SchemeMemory + "://"},
+		"file":   testStorageConfig(filepath.Join(t.TempDir(), "storage_test.json"), ""),
+	} {
+		t.Run(name, func(t *testing.T) {
+			urlShortener := NewURLShortener("http://localhost:8080", storageConfig, testLogger)
+			defer func() {
+				assert.NoError(t, urlShortener.Close(context.Background()))
+			}()
+
+			w := httptest.NewRecorder()
+			urlShortener.PingHandler(w, httptest.NewRequest(http.MethodGet, "/ping", http.NoBody))
+			assert.Equal(t, http.StatusOK, w.Code)
+		})
+	}
+}
+
+func TestExportImportHandlers(t *testing.T) {
+	testLogger := logger.NewZapLogger(zap.NewNop())
+	ctx := context.Background()
+	source := NewURLShortener("http://localhost:8080",
+		testStorageConfig(filepath.Join(t.TempDir(), "source.json"), ""), testLogger)
+	defer func() {
+		assert.NoError(t, source.Close(ctx))
+	}()
+	for _, record := range []models.URLRecord{
+		{ShortID: "first", OriginalURL: "http://example.com/first", UserID: "owner"},
+		{ShortID: "second", OriginalURL: "http://example.com/second", UserID: "owner"},
+	} {
+		require.NoError(t, source.storage.SaveID(ctx, record))
+	}
+	require.NoError(t, source.storage.DeleteURLs(ctx, []models.DeleteRequest{{UserID: "owner", ShortID: "second"}}))
+	source.clicks.Record("first", time.Now())
+
+	for _, format := range []string{"ndjson", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			source.ExportHandler(w, httptest.NewRequest(http.MethodGet, "/api/admin/export?format="+format, http.NoBody))
+			require.Equal(t, http.StatusOK, w.Code)
+			dump := w.Body.String()
+
+			target := NewURLShortener("http://localhost:8080",
+				testStorageConfig(filepath.Join(t.TempDir(), "target.json"), ""), testLogger)
+			defer func() {
+				assert.NoError(t, target.Close(ctx))
+			}()
+
+			// Повторная загрузка той же выгрузки ничего не создаёт.
+			for _, expectedCreated := range []int{2, 0} {
+				w = httptest.NewRecorder()
+				target.ImportHandler(w, httptest.NewRequest(http.MethodPost, "/api/admin/import?format="+format,
+					strings.NewReader(dump)))
+				require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+				var response models.ImportResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+				assert.Equal(t, 2, response.Read)
+				assert.Equal(t, expectedCreated, response.Created)
+			}
+
+			originalURL, err := target.storage.Get(ctx, "first")
+			require.NoError(t, err)
+			assert.Equal(t, "http://example.com/first", originalURL)
+			stat, err := target.storage.GetStats(ctx, "first")
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), stat.Clicks)
+			_, err = target.storage.Get(ctx, "second")
+			assert.ErrorIs(t, err, errs.ErrDeleted)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	source.ImportHandler(w, httptest.NewRequest(http.MethodPost, "/api/admin/import",
+		strings.NewReader(`{"short_id":"bad","original_url":"not a url"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	source.ExportHandler(w, httptest.NewRequest(http.MethodGet, "/api/admin/export?format=xml", http.NoBody))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestImportDeletedSharingURL проверяет, что удалённая ссылка из выгрузки не занимает URL
+// живой ссылки, даже если загружается раньше неё.
+func TestImportDeletedSharingURL(t *testing.T) {
+	testLogger := logger.NewZapLogger(zap.NewNop())
+	ctx := context.Background()
+	storageConfig := testStorageConfig(filepath.Join(t.TempDir(), "target.json"), "")
+	target := NewURLShortener("http://localhost:8080", storageConfig, testLogger)
+
+	dump := `{"short_id":"aaa","original_url":"http://example.com/shared","user_id":"owner","is_deleted":true}` + "\n" +
+		`{"short_id":"bbb","original_url":"http://example.com/shared","user_id":"owner"}` + "\n"
+	w := httptest.NewRecorder()
+	target.ImportHandler(w, httptest.NewRequest(http.MethodPost, "/api/admin/import", strings.NewReader(dump)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response models.ImportResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, 2, response.Created)
+	assert.Equal(t, 1, response.Deleted)
+	assert.Empty(t, response.Conflicts)
+	require.NoError(t, target.Close(ctx))
+
+	// Обе записи переживают перезапуск.
+	restarted := NewURLShortener("http://localhost:8080", storageConfig, testLogger)
+	defer func() {
+		assert.NoError(t, restarted.Close(ctx))
+	}()
+	originalURL, err := restarted.storage.Get(ctx, "bbb")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/shared", originalURL)
+	_, err = restarted.storage.Get(ctx, "aaa")
+	assert.ErrorIs(t, err, errs.ErrDeleted)
+}
+
+// TestShortenAgain проверяет, что удалённая или просроченная ссылка не занимает URL:
+// его можно сократить заново, а старая ссылка продолжает отвечать 410.
+func TestShortenAgain(t *testing.T) {
+	testLogger := logger.NewZapLogger(zap.NewNop())
+	backends := map[string]func(dir string) storage.Config{
+		"memory": func(string) storage.Config {
+			return storage.Config{URI: storage.SchemeMemory + "://"}
+		},
+		"file": func(dir string) storage.Config {
+			return testStorageConfig(filepath.Join(dir, "storage_test.json"), "")
+		},
+		"bitcask": func(dir string) storage.Config {
+			return storage.Config{URI: storage.PathURI(storage.SchemeBitcask, dir), Bitcask: bitcask.DefaultOptions()}
+		},
+	}
+
+	for name, storageConfig := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := auth.WithUserID(context.Background(), "owner")
+			dir := t.TempDir()
+			urlShortener := NewURLShortener("http://localhost:8080", storageConfig(dir), testLogger)
+
+			shorten := func(originalURL string) string {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(originalURL)).WithContext(ctx)
+				urlShortener.PostHandler(w, req)
+				require.Equal(t, http.StatusCreated, w.Code, "unexpected status code for %s", originalURL)
+				return strings.TrimPrefix(w.Body.String(), "http://localhost:8080/")
+			}
+			redirect := func(id string) int {
+				w := httptest.NewRecorder()
+				urlShortener.GetHandler(w, httptest.NewRequest(http.MethodGet, "/"+id, http.NoBody))
+				return w.Code
+			}
+
+			deletedID := shorten("http://example.com/deleted")
+			require.NoError(t, urlShortener.deleter.Enqueue(ctx, "owner", []string{deletedID}))
+			require.NoError(t, urlShortener.deleter.Flush(ctx))
+
+			require.NoError(t, urlShortener.storage.SaveID(ctx, models.URLRecord{
+				ShortID:     "expired",
+				OriginalURL: "http://example.com/expired",
+				ExpiresAt:   time.Now().Add(-time.Minute),
+			}))
+
+			for oldID, originalURL := range map[string]string{
+				deletedID: "http://example.com/deleted",
+				"expired": "http://example.com/expired",
+			} {
+				newID := shorten(originalURL)
+				assert.NotEqual(t, oldID, newID)
+				assert.Equal(t, http.StatusTemporaryRedirect, redirect(newID))
+				assert.Equal(t, http.StatusGone, redirect(oldID))
+
+				// Повторное сокращение находит новую ссылку.
+				id, err := urlShortener.storage.GetIDByURL(ctx, originalURL)
+				require.NoError(t, err)
+				assert.Equal(t, newID, id)
+			}
+			newDeletedID, err := urlShortener.storage.GetIDByURL(ctx, "http://example.com/deleted")
+			require.NoError(t, err)
+			require.NoError(t, urlShortener.Close(ctx))
+			if name == "memory" {
+				return
+			}
+
+			// После перезапуска URL по-прежнему ведёт на новую ссылку.
+			restarted := NewURLShortener("http://localhost:8080", storageConfig(dir), testLogger)
+			defer func() {
+				assert.NoError(t, restarted.Close(ctx))
+			}()
+			id, err := restarted.storage.GetIDByURL(ctx, "http://example.com/deleted")
+			require.NoError(t, err)
+			assert.Equal(t, newDeletedID, id)
+		})
+	}
 }
@@ -11,6 +11,7 @@ import (
 // Logger — интерфейс для логирования.
 type Logger interface {
 	Info(msg string, fields ...zap.Field)
+	Warn(msg string, fields ...zap.Field)
 	Error(msg string, fields ...zap.Field)
 	Fatal(msg string, fields ...zap.Field)
 	Named(name string) Logger
@@ -31,6 +32,11 @@ func (l *ZapLogger) Info(msg string, fields ...zap.Field) {
 	l.logger.Info(msg, fields...)
 }
 
+// Warn логирует сообщение уровня WARN.
+func (l *ZapLogger) Warn(msg string, fields ...zap.Field) {
+	l.logger.Warn(msg, fields...)
+}
+
 // Error логирует сообщение уровня ERROR.
 func (l *ZapLogger) Error(msg string, fields ...zap.Field) {
 	l.logger.Error(msg, fields...)
@@ -1,7 +1,13 @@
 package models
 
+import "time"
+
+// Request — запрос на сокращение URL.
+// Срок жизни ссылки задаётся либо моментом ExpiresAt, либо TTL в секундах.
 type Request struct {
-	URL string `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	URL       string     `json:"url"`
+	TTL       int64      `json:"ttl,omitempty"`
 }
 
 type Response struct {
@@ -9,11 +15,107 @@ type Response struct {
 }
 
 type BatchRequest struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	CorrelationID string     `json:"correlation_id"`
+	OriginalURL   string     `json:"original_url"`
+	TTL           int64      `json:"ttl,omitempty"`
 }
 
 type BatchResponse struct {
 	CorrelationID string `json:"correlation_id"`
 	ShortURL      string `json:"short_url"`
 }
+
+// StatsResponse — статистика переходов по ссылке в ответе GET /api/stats/{id}.
+type StatsResponse struct {
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+	ShortURL       string     `json:"short_url"`
+	Clicks         int64      `json:"clicks"`
+}
+
+// UserURLResponse — ссылка пользователя в ответе GET /api/user/urls.
+type UserURLResponse struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+}
+
+// URLRecord — сохраняемая ссылка: короткий ID, оригинальный URL и владелец.
+// Пустой UserID означает анонимную ссылку, нулевой ExpiresAt — бессрочную.
+type URLRecord struct {
+	ExpiresAt      time.Time
+	LastAccessedAt time.Time
+	ShortID        string
+	OriginalURL    string
+	UserID         string
+	Clicks         int64
+	IsDeleted      bool
+}
+
+// Expired сообщает, истёк ли срок жизни ссылки к моменту now.
+func (r *URLRecord) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// ClickStat — число переходов по ссылке и время последнего из них.
+// В хранилище передаётся как приращение, накопленное с прошлой записи.
+type ClickStat struct {
+	LastAccessedAt time.Time
+	ShortID        string
+	Clicks         int64
+}
+
+// DeleteRequest — запрос пользователя на удаление его ссылки.
+type DeleteRequest struct {
+	UserID  string
+	ShortID string
+}
+
+// URLChange — изменение ссылок в хранилище, общем для нескольких экземпляров сервиса.
+type URLChange struct {
+	// ShortIDs — ID созданных, изменённых и удалённых ссылок.
+	ShortIDs []string
+	// Reset означает, что изменения неизвестны или могли быть пропущены,
+	// и состояние, закешированное по хранилищу, нужно сбросить целиком.
+	Reset bool
+	// Lost означает, что оповещения прервались: до следующего Reset изменения,
+	// сделанные другими экземплярами, не поступают.
+	Lost bool
+}
+
+// BatchStatus — итог сохранения одной записи пакета.
+type BatchStatus int
+
+const (
+	// BatchCreated — запись сохранена под переданным ID.
+	BatchCreated BatchStatus = iota
+	// BatchExisted — URL уже был сохранён, ShortID содержит существующий ID.
+	BatchExisted
+	// BatchFailed — запись не сохранена, причина в Err.
+	BatchFailed
+)
+
+// BatchResult — результат сохранения одной записи пакета.
+type BatchResult struct {
+	Err     error
+	ShortID string
+	Status  BatchStatus
+}
+
+// ImportConflict — запись выгрузки, не загруженная из-за конфликта, в ответе POST /api/admin/import.
+type ImportConflict struct {
+	ShortID     string `json:"short_id"`
+	OriginalURL string `json:"original_url"`
+	Reason      string `json:"reason"`
+	// ExistingID — ID, под которым URL уже сохранён, если причина в нём.
+	ExistingID string `json:"existing_id,omitempty"`
+}
+
+// ImportResponse — итог загрузки выгрузки в ответе POST /api/admin/import.
+type ImportResponse struct {
+	Conflicts      []ImportConflict `json:"conflicts,omitempty"`
+	Read           int              `json:"read"`
+	Created        int              `json:"created"`
+	AlreadyPresent int              `json:"already_present"`
+	Expired        int              `json:"expired"`
+	Deleted        int              `json:"deleted"`
+}
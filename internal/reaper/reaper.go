@@ -0,0 +1,91 @@
+package reaper
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultInterval — период удаления просроченных ссылок.
+	DefaultInterval = time.Minute
+
+	reapTimeout = 30 * time.Second
+)
+
+// Store — часть хранилища, необходимая для удаления просроченных ссылок.
+type Store interface {
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+// Reaper периодически удаляет из хранилища ссылки с истёкшим сроком жизни.
+type Reaper struct {
+	store     Store
+	logger    logger.Logger
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce *sync.Once
+	interval  time.Duration
+}
+
+// New создаёт Reaper и запускает фоновую горутину.
+func New(store Store, parentLogger logger.Logger, interval time.Duration) *Reaper {
+	r := &Reaper{
+		store:     store,
+		logger:    parentLogger,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
+		interval:  interval,
+	}
+	go r.run()
+	return r
+}
+
+// Close останавливает фоновую горутину и дожидается её завершения.
+func (r *Reaper) Close(ctx context.Context) error {
+	r.closeOnce.Do(func() {
+		close(r.stop)
+	})
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("close reaper canceled: %w", ctx.Err())
+	}
+}
+
+func (r *Reaper) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.reap()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *Reaper) reap() {
+	ctx, cancel := context.WithTimeout(context.Background(), reapTimeout)
+	defer cancel()
+
+	count, err := r.store.DeleteExpired(ctx, time.Now())
+	if err != nil {
+		r.logger.Error("Failed to delete expired URLs", zap.Error(err))
+		return
+	}
+	if count > 0 {
+		r.logger.Info("Expired URLs deleted", zap.Int("count", count))
+	}
+}
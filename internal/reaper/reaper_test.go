@@ -0,0 +1,68 @@
+package reaper
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/BrownBear56/contractor/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// countingStore считает вызовы DeleteExpired.
+type countingStore struct {
+	calls atomic.Int64
+}
+
+func (s *countingStore) DeleteExpired(context.Context, time.Time) (int, error) {
+	s.calls.Add(1)
+	return 0, nil
+}
+
+func TestReapOnTick(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewMemoryStore()
+	require.NoError(t, store.SaveID(ctx, models.URLRecord{
+		ShortID: "expired", OriginalURL: "https://example.com/expired", ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+	require.NoError(t, store.SaveID(ctx, models.URLRecord{
+		ShortID: "live", OriginalURL: "https://example.com/live", ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	r := New(store, logger.NewZapLogger(zap.NewNop()), 10*time.Millisecond)
+	defer func() {
+		assert.NoError(t, r.Close(ctx))
+	}()
+	require.Eventually(t, func() bool {
+		return store.Len() == 1
+	}, time.Second, time.Millisecond)
+
+	_, err := store.Get(ctx, "expired")
+	require.ErrorIs(t, err, errs.ErrNotFound)
+	_, err = store.Get(ctx, "live")
+	require.NoError(t, err)
+}
+
+func TestCloseStopsReaper(t *testing.T) {
+	const interval = 5 * time.Millisecond
+	ctx := context.Background()
+	store := &countingStore{}
+	r := New(store, logger.NewZapLogger(zap.NewNop()), interval)
+	require.Eventually(t, func() bool {
+		return store.calls.Load() > 0
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, r.Close(ctx))
+	calls := store.calls.Load()
+	time.Sleep(10 * interval)
+	assert.Equal(t, calls, store.calls.Load())
+
+	// Повторное закрытие не блокируется.
+	require.NoError(t, r.Close(ctx))
+}
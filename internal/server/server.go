@@ -1,24 +1,33 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/BrownBear56/contractor/internal/auth"
 	"github.com/BrownBear56/contractor/internal/config"
 	"github.com/BrownBear56/contractor/internal/gzip"
 	"github.com/BrownBear56/contractor/internal/handlers"
 	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/storage"
 )
 
+// readHeaderTimeout защищает от клиентов, бесконечно долго присылающих заголовки.
+const readHeaderTimeout = 10 * time.Second
+
 type Server struct {
-	router *chi.Mux
-	cfg    *config.Config
-	logger logger.Logger
+	router    *chi.Mux
+	cfg       *config.Config
+	logger    logger.Logger
+	shortener *handlers.URLShortener
 }
 
 func New(cfg *config.Config, parentLogger logger.Logger) *Server {
@@ -60,9 +69,17 @@ func New(cfg *config.Config, parentLogger logger.Logger) *Server {
 }
 
 func (s *Server) setupRoutes(parentLogger logger.Logger) {
-	const useFile = true
-	urlShortener := handlers.NewURLShortener(
-		s.cfg.BaseURL, s.cfg.FileStoragePath, s.cfg.DatabaseDSN, useFile, parentLogger)
+	s.shortener = handlers.NewURLShortener(s.cfg.BaseURL, storage.Config{
+		URI:          s.cfg.StorageURI,
+		DatabaseDSN:  s.cfg.DatabaseDSN,
+		File:         s.cfg.FileStorage,
+		Bitcask:      s.cfg.Bitcask,
+		Postgres:     s.cfg.Postgres,
+		Cache:        s.cfg.Cache,
+		Filter:       s.cfg.IDFilter,
+		MaxURLLength: s.cfg.MaxURLLength,
+	}, parentLogger)
+	urlShortener := s.shortener
 
 	// Подключаем middleware.
 	s.router.Use(func(next http.Handler) http.Handler {
@@ -71,9 +88,16 @@ func (s *Server) setupRoutes(parentLogger logger.Logger) {
 	s.router.Use(func(next http.Handler) http.Handler {
 		return gzip.GzipMiddleware(next, s.logger)
 	}) // Наше кастомное middleware-сжатие.
+	s.router.Use(func(next http.Handler) http.Handler {
+		return auth.AuthMiddleware(next, []byte(s.cfg.SecretKey), s.logger)
+	}) // Идентификация пользователя по подписанной cookie.
+	s.router.Use(handlers.ClientMiddleware) // Read-your-writes для пользователя при чтении с реплик.
 
 	s.router.Post("/api/shorten/batch", urlShortener.PostBatchHandler)
 	s.router.Post("/api/shorten", urlShortener.PostJSONHandler)
+	s.router.Get("/api/user/urls", urlShortener.GetUserURLsHandler)
+	s.router.Delete("/api/user/urls", urlShortener.DeleteUserURLsHandler)
+	s.router.Get("/api/stats/{id}", urlShortener.GetStatsHandler)
 	s.router.Post("/", urlShortener.PostHandler)
 	s.router.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
@@ -81,16 +105,57 @@ func (s *Server) setupRoutes(parentLogger logger.Logger) {
 		urlShortener.GetHandler(w, r)
 	})
 	s.router.Get("/ping", urlShortener.PingHandler)
-}
 
-func (s *Server) Start() error {
-	err := http.ListenAndServe(s.cfg.Address, s.router)
+	// Служебные эндпоинты доступны только при заданном токене.
+	if s.cfg.AdminToken != "" {
+		s.router.Route("/api/admin", func(r chi.Router) {
+			r.Use(func(next http.Handler) http.Handler {
+				return auth.AdminMiddleware(next, s.cfg.AdminToken, s.logger)
+			})
+			r.Get("/export", urlShortener.ExportHandler)
+			r.Post("/import", urlShortener.ImportHandler)
+		})
+	}
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to start server on %s: %w", s.cfg.Address, err)
+// Run обслуживает запросы до отмены ctx, после чего останавливает сервер: перестаёт
+// принимать соединения, дожидается активных запросов не дольше ShutdownTimeout,
+// а затем за отдельный ShutdownTimeout сбрасывает и закрывает хранилище.
+func (s *Server) Run(ctx context.Context) error {
+	httpServer := &http.Server{
+		Addr:              s.cfg.Address,
+		Handler:           s.router,
+		ReadHeaderTimeout: readHeaderTimeout,
 	}
 
-	s.logger.Info("Server is running", zap.String("address", s.cfg.Address))
+	serveErr := make(chan error, 1)
+	go func() {
+		s.logger.Info("Server is running", zap.String("address", s.cfg.Address))
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	var runErr error
+	select {
+	case err := <-serveErr:
+		// Сервер не запустился или упал: запросов для ожидания нет, но хранилище всё равно закрываем.
+		runErr = fmt.Errorf("failed to start server on %s: %w", s.cfg.Address, err)
+	case <-ctx.Done():
+		s.logger.Info("Shutting down server", zap.Duration("timeout", s.cfg.ShutdownTimeout))
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			s.logger.Error("Failed to drain in-flight requests", zap.Error(err))
+			runErr = fmt.Errorf("failed to shut down server: %w", err)
+		} else {
+			s.logger.Info("In-flight requests drained")
+		}
+	}
 
-	return nil
+	closeCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := s.shortener.Close(closeCtx); err != nil {
+		return errors.Join(runErr, fmt.Errorf("failed to close storage: %w", err))
+	}
+	s.logger.Info("Server stopped")
+	return runErr
 }
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/storage/bitcask"
+	"github.com/BrownBear56/contractor/internal/storage/file"
+	"github.com/BrownBear56/contractor/internal/storage/memory"
+	"github.com/BrownBear56/contractor/internal/storage/postgres"
+)
+
+// Схемы URI встроенных хранилищ.
+const (
+	SchemeMemory     = "memory"
+	SchemeFile       = "file"
+	SchemeBitcask    = "bitcask"
+	SchemePostgres   = "postgres"
+	SchemePostgreSQL = "postgresql"
+)
+
+// Встроенные хранилища регистрируются здесь, а не в своих пакетах: пакеты хранилищ
+// не зависят от storage, а storage зависит от их настроек в Config.
+func init() {
+	Register(SchemeMemory, openMemory)
+	Register(SchemeFile, openFile)
+	Register(SchemeBitcask, openBitcask)
+	Register(SchemePostgres, openPostgres)
+	Register(SchemePostgreSQL, openPostgres)
+}
+
+func openMemory(_ *url.URL, _ Config, _ logger.Logger) (Storage, error) {
+	return memory.NewMemoryStore(), nil
+}
+
+func openFile(uri *url.URL, cfg Config, storageLogger logger.Logger) (Storage, error) {
+	path := URIPath(uri)
+	if path == "" {
+		return nil, errors.New("file path is empty, use file:///absolute/path or file:relative/path")
+	}
+	fileStore, err := file.NewFileStore(path, cfg.File, storageLogger)
+	if err != nil {
+		return nil, err
+	}
+	return fileStore, nil
+}
+
+func openBitcask(uri *url.URL, cfg Config, storageLogger logger.Logger) (Storage, error) {
+	dir := URIPath(uri)
+	if dir == "" {
+		return nil, errors.New("directory is empty, use bitcask:///absolute/dir or bitcask:relative/dir")
+	}
+	bitcaskStore, err := bitcask.New(dir, cfg.Bitcask, storageLogger)
+	if err != nil {
+		return nil, err
+	}
+	return bitcaskStore, nil
+}
+
+// openPostgres подключается по cfg.DatabaseDSN, если он задан, иначе по самому URI.
+// Так DSN в формате ключ=значение из DATABASE_DSN продолжает работать.
+func openPostgres(uri *url.URL, cfg Config, storageLogger logger.Logger) (Storage, error) {
+	dsn := cfg.DatabaseDSN
+	if dsn == "" {
+		dsn = uri.String()
+	}
+	pgStore, err := postgres.NewPostgresStore(dsn, cfg.Postgres, storageLogger)
+	if err != nil {
+		return nil, err
+	}
+	return pgStore, nil
+}
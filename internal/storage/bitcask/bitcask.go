@@ -0,0 +1,832 @@
+// Package bitcask реализует хранилище ссылок в стиле Bitcask: значения дописываются
+// в сегменты данных на диске, а в памяти хранится только индекс смещений (keydir).
+package bitcask
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"go.uber.org/zap"
+)
+
+// maxValueSize ограничивает размер значения: большее значение в заголовке означает повреждение.
+const maxValueSize = 16 << 20
+
+// Options — настройки хранилища.
+type Options struct {
+	// MaxSegmentSize — размер активного сегмента, после которого он закрывается и начинается новый.
+	MaxSegmentSize int64
+	// MergeInterval — период проверки необходимости слияния. Нулевое значение отключает фоновое слияние.
+	MergeInterval time.Duration
+	// MergeMinDeadBytes — объём устаревших записей в закрытых сегментах, при котором выполняется слияние.
+	MergeMinDeadBytes int64
+	// Sync включает сброс активного сегмента на диск после каждой записи.
+	Sync bool
+}
+
+// DefaultOptions возвращает настройки хранилища по умолчанию.
+func DefaultOptions() Options {
+	const (
+		defaultMaxSegmentSize    = 64 << 20
+		defaultMergeInterval     = 10 * time.Minute
+		defaultMergeMinDeadBytes = 16 << 20
+	)
+	return Options{
+		MaxSegmentSize:    defaultMaxSegmentSize,
+		MergeInterval:     defaultMergeInterval,
+		MergeMinDeadBytes: defaultMergeMinDeadBytes,
+	}
+}
+
+// Store — хранилище ссылок в каталоге с сегментами данных и файлами подсказок.
+// Значения читаются с диска по смещению из keydir; для поиска по URL и владельцу
+// в памяти хранятся хеши URL и списки ID пользователей.
+type Store struct {
+	mu     *sync.Mutex
+	logger logger.Logger
+	// files — открытые сегменты по номерам, включая активный.
+	files  map[uint32]*os.File
+	active *os.File
+	keydir map[string]location
+	// urls — ID ссылок по хешу оригинального URL. Совпадение хеша проверяется чтением значения.
+	urls map[uint64][]string
+	// users — ID ссылок пользователя в порядке создания.
+	users map[string][]string
+	// deadBytes — объём устаревших записей по сегментам.
+	deadBytes map[uint32]int64
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce *sync.Once
+	dir       string
+	// activeHints — подсказки для записей активного сегмента, сохраняются при его закрытии.
+	activeHints []hint
+	options     Options
+	activeSize  int64
+	activeID    uint32
+}
+
+// New открывает хранилище в каталоге dir, создавая его при необходимости,
+// и восстанавливает индекс из файлов подсказок или сканированием сегментов.
+func New(dir string, options Options, parentLogger logger.Logger) (*Store, error) {
+	const dirPerm = 0o750
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	s := &Store{
+		mu:        &sync.Mutex{},
+		logger:    parentLogger,
+		files:     make(map[uint32]*os.File),
+		keydir:    make(map[string]location),
+		urls:      make(map[uint64][]string),
+		users:     make(map[string][]string),
+		deadBytes: make(map[uint32]int64),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
+		dir:       dir,
+		options:   options,
+	}
+	if err := s.load(); err != nil {
+		s.closeFiles()
+		return nil, err
+	}
+
+	if options.MergeInterval > 0 {
+		go s.run()
+	} else {
+		close(s.done)
+	}
+	return s, nil
+}
+
+// load восстанавливает индекс. Сегменты старше последнего слитого удаляются как устаревшие.
+// Оборванная запись в конце последнего сегмента отрезается, повреждение в других местах — ошибка.
+func (s *Store) load() error {
+	ids, err := listSegments(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for i := len(ids) - 1; i > 0; i-- {
+		merged, err := isMerged(segmentPath(s.dir, ids[i], dataExt))
+		if err != nil {
+			return err
+		}
+		if !merged {
+			continue
+		}
+		for _, id := range ids[:i] {
+			if err := removeSegment(s.dir, id); err != nil {
+				return err
+			}
+		}
+		ids = ids[i:]
+		break
+	}
+
+	for i, id := range ids {
+		last := i == len(ids)-1
+		size, fromHints, err := s.loadSegment(id, last)
+		if err != nil {
+			return err
+		}
+
+		flag := os.O_RDONLY
+		if last && !fromHints {
+			flag = os.O_RDWR | os.O_APPEND
+		}
+		f, err := os.OpenFile(segmentPath(s.dir, id, dataExt), flag, 0)
+		if err != nil {
+			return fmt.Errorf("failed to open segment %d: %w", id, err)
+		}
+		s.files[id] = f
+		if flag != os.O_RDONLY {
+			s.active, s.activeID, s.activeSize = f, id, size
+		}
+	}
+
+	// Последний сегмент с подсказками уже закрыт: продолжаем запись в новый.
+	if s.active == nil {
+		var next uint32 = 1
+		if len(ids) > 0 {
+			next = ids[len(ids)-1] + 1
+		}
+		if err := s.openActive(next); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Info("Bitcask store loaded",
+		zap.String("dir", s.dir), zap.Int("segments", len(s.files)), zap.Int("keys", len(s.keydir)))
+	return nil
+}
+
+// loadSegment добавляет в индекс записи сегмента из файла подсказок или сканированием данных.
+// Для сегмента без подсказок записи активного сегмента запоминаются в activeHints.
+func (s *Store) loadSegment(id uint32, last bool) (int64, bool, error) {
+	hints, err := readHints(segmentPath(s.dir, id, hintExt), id)
+	if err == nil {
+		for _, h := range hints {
+			s.index(h)
+		}
+		return 0, true, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		s.logger.Warn("Ignoring unreadable hint file", zap.Uint32("segment", id), zap.Error(err))
+	}
+
+	path := segmentPath(s.dir, id, dataExt)
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to open segment %d: %w", id, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var offset int64
+	reader := bufio.NewReader(f)
+	for {
+		e, size, err := readEntry(reader, maxValueSize)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, errCorruptEntry) && last {
+			// Запись в конце активного сегмента могла оборваться при сбое.
+			if err := os.Truncate(path, offset); err != nil {
+				return 0, false, fmt.Errorf("failed to truncate segment %d: %w", id, err)
+			}
+			s.logger.Warn("Truncated torn entry at the end of segment",
+				zap.Uint32("segment", id), zap.Int64("offset", offset), zap.Error(err))
+			break
+		}
+		if err != nil {
+			return 0, false, fmt.Errorf("segment %d is corrupted at offset %d: %w", id, offset, err)
+		}
+
+		h, err := hintForEntry(e, location{segment: id, offset: offset, size: uint32(size)})
+		if err != nil {
+			return 0, false, fmt.Errorf("segment %d is corrupted at offset %d: %w", id, offset, err)
+		}
+		offset += int64(size)
+		if e.flags&flagMergeMarker != 0 {
+			continue
+		}
+		s.index(h)
+		if last {
+			s.activeHints = append(s.activeHints, h)
+		}
+	}
+	return offset, false, nil
+}
+
+// hintForEntry собирает подсказку по записи сегмента.
+func hintForEntry(e entry, loc location) (hint, error) {
+	h := hint{key: e.key, location: loc, tombstone: e.flags&flagTombstone != 0}
+	if h.tombstone || e.flags&flagMergeMarker != 0 {
+		return h, nil
+	}
+	record, err := decodeRecord(e.key, e.value)
+	if err != nil {
+		return h, err
+	}
+	h.userID = record.UserID
+	h.urlHash = hashURL(record.OriginalURL)
+	h.expiresAt = unixNano(record.ExpiresAt)
+	h.deleted = record.IsDeleted
+	return h, nil
+}
+
+// index применяет запись к keydir и вторичным индексам. Вызывается под s.mu или при загрузке.
+func (s *Store) index(h hint) {
+	old, exists := s.keydir[h.key]
+	if exists {
+		s.deadBytes[old.segment] += int64(old.size)
+	}
+
+	if h.tombstone {
+		s.deadBytes[h.segment] += int64(h.size)
+		if exists {
+			delete(s.keydir, h.key)
+			s.unindex(h.key, old)
+		}
+		return
+	}
+
+	s.keydir[h.key] = h.location
+	if exists && old.urlHash == h.urlHash && old.userID == h.userID && old.deleted == h.deleted {
+		return
+	}
+	if exists {
+		s.unindex(h.key, old)
+	}
+	// URL удалённой ссылки свободен для повторного сокращения.
+	if !h.deleted {
+		s.urls[h.urlHash] = append(s.urls[h.urlHash], h.key)
+	}
+	if h.userID != "" {
+		s.users[h.userID] = append(s.users[h.userID], h.key)
+	}
+}
+
+// unindex удаляет ключ из вторичных индексов.
+func (s *Store) unindex(key string, loc location) {
+	isKey := func(id string) bool { return id == key }
+	if ids := slices.DeleteFunc(s.urls[loc.urlHash], isKey); len(ids) > 0 {
+		s.urls[loc.urlHash] = ids
+	} else {
+		delete(s.urls, loc.urlHash)
+	}
+	if loc.userID == "" {
+		return
+	}
+	if ids := slices.DeleteFunc(s.users[loc.userID], isKey); len(ids) > 0 {
+		s.users[loc.userID] = ids
+	} else {
+		delete(s.users, loc.userID)
+	}
+}
+
+// openActive создаёт новый активный сегмент. Вызывается под s.mu или при загрузке.
+func (s *Store) openActive(id uint32) error {
+	const filePerm = 0o600
+	f, err := os.OpenFile(segmentPath(s.dir, id, dataExt), os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_EXCL, filePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create segment %d: %w", id, err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	s.files[id] = f
+	s.active, s.activeID, s.activeSize = f, id, 0
+	s.activeHints = nil
+	return nil
+}
+
+// rotate закрывает активный сегмент для записи, сохраняет его подсказки и начинает новый.
+// Вызывается под s.mu.
+func (s *Store) rotate() error {
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync segment %d: %w", s.activeID, err)
+	}
+
+	var buf []byte
+	for _, h := range s.activeHints {
+		buf = appendHint(buf, h)
+	}
+	if err := writeFileAtomically(segmentPath(s.dir, s.activeID, hintExt), buf); err != nil {
+		return fmt.Errorf("failed to write hints for segment %d: %w", s.activeID, err)
+	}
+	return s.openActive(s.activeID + 1)
+}
+
+// write дописывает запись в активный сегмент и обновляет индекс.
+// При ошибке записи или сброса на диск запись отбрасывается, см. discard. Вызывается под s.mu.
+func (s *Store) write(e entry) error {
+	if len(e.key) > math.MaxUint16 {
+		return fmt.Errorf("key is too long: %d bytes", len(e.key))
+	}
+	if len(e.value) > maxValueSize {
+		return fmt.Errorf("value is too large: %d bytes", len(e.value))
+	}
+
+	buf := appendEntry(nil, e)
+	if s.activeSize > 0 && s.activeSize+int64(len(buf)) > s.options.MaxSegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if n, err := s.active.Write(buf); err != nil {
+		s.discard(n)
+		return fmt.Errorf("failed to write segment %d: %w", s.activeID, err)
+	}
+	if s.options.Sync {
+		if err := s.active.Sync(); err != nil {
+			s.discard(len(buf))
+			return fmt.Errorf("failed to sync segment %d: %w", s.activeID, err)
+		}
+	}
+
+	h, err := hintForEntry(e, location{segment: s.activeID, offset: s.activeSize, size: uint32(len(buf))})
+	if err != nil {
+		return err
+	}
+	s.activeSize += int64(len(buf))
+	s.activeHints = append(s.activeHints, h)
+	s.index(h)
+	return nil
+}
+
+// discard обрезает активный сегмент до прежнего размера, отбрасывая written байт записи,
+// которую не удалось записать или сбросить на диск. Сегмент открыт на дозапись, поэтому
+// если обрезать его не удалось, эти байты учитываются в размере сегмента, чтобы смещения
+// следующих записей остались верными. Вызывается под s.mu.
+func (s *Store) discard(written int) {
+	if err := s.active.Truncate(s.activeSize); err != nil {
+		s.logger.Error("Failed to truncate unwritten entry", zap.Error(err))
+		s.activeSize += int64(written)
+	}
+}
+
+// put сохраняет новую версию записи. Вызывается под s.mu.
+func (s *Store) put(record models.URLRecord) error {
+	if err := s.write(entry{key: record.ShortID, value: encodeRecord(record)}); err != nil {
+		return fmt.Errorf("%w: failed to save record: %w", errs.ErrUnavailable, err)
+	}
+	return nil
+}
+
+// read читает актуальную версию записи. Вызывается под s.mu.
+func (s *Store) read(id string) (models.URLRecord, bool, error) {
+	loc, ok := s.keydir[id]
+	if !ok {
+		return models.URLRecord{}, false, nil
+	}
+	e, err := readEntryAt(s.files[loc.segment], loc.offset, loc.size)
+	if err != nil {
+		return models.URLRecord{}, false, fmt.Errorf("%w: failed to read record %s: %w", errs.ErrUnavailable, id, err)
+	}
+	record, err := decodeRecord(id, e.value)
+	if err != nil {
+		return models.URLRecord{}, false, fmt.Errorf("%w: failed to decode record %s: %w", errs.ErrUnavailable, id, err)
+	}
+	return record, true, nil
+}
+
+// lookupURL возвращает ID живой ссылки, под которым сохранён URL. Удалённые и просроченные
+// ссылки URL не занимают: его можно сократить заново под новым ID. Вызывается под s.mu.
+func (s *Store) lookupURL(originalURL string) (string, bool, error) {
+	now := time.Now()
+	for _, id := range s.urls[hashURL(originalURL)] {
+		record, ok, err := s.read(id)
+		if err != nil {
+			return "", false, err
+		}
+		if ok && record.OriginalURL == originalURL && !record.IsDeleted && !record.Expired(now) {
+			return id, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (s *Store) SaveID(ctx context.Context, record models.URLRecord) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("save ID canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keydir[record.ShortID]; ok {
+		return fmt.Errorf("%w: %s", errs.ErrIDConflict, record.ShortID)
+	}
+	_, exists, err := s.lookupURL(record.OriginalURL)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%w: %s", errs.ErrURLConflict, record.OriginalURL)
+	}
+	return s.put(record)
+}
+
+func (s *Store) GetOrCreate(ctx context.Context, record models.URLRecord) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, fmt.Errorf("get or create canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existingID, exists, err := s.lookupURL(record.OriginalURL)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		return existingID, true, nil
+	}
+	if _, ok := s.keydir[record.ShortID]; ok {
+		return "", false, fmt.Errorf("%w: %s", errs.ErrIDConflict, record.ShortID)
+	}
+	if err := s.put(record); err != nil {
+		return "", false, err
+	}
+	return record.ShortID, false, nil
+}
+
+func (s *Store) Get(ctx context.Context, id string) (string, error) {
+	originalURL, _, err := s.GetWithExpiry(ctx, id)
+	return originalURL, err
+}
+
+// GetWithExpiry возвращает URL ссылки и момент истечения её срока, нулевой для бессрочной.
+func (s *Store) GetWithExpiry(ctx context.Context, id string) (string, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return "", time.Time{}, fmt.Errorf("get URL canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok, err := s.read(id)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("ID %s: %w", id, errs.ErrNotFound)
+	}
+	if record.IsDeleted {
+		return "", time.Time{}, fmt.Errorf("ID %s: %w", id, errs.ErrDeleted)
+	}
+	if record.Expired(time.Now()) {
+		return "", time.Time{}, fmt.Errorf("ID %s: %w", id, errs.ErrExpired)
+	}
+	return record.OriginalURL, record.ExpiresAt, nil
+}
+
+func (s *Store) GetIDByURL(ctx context.Context, originalURL string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("get ID canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok, err := s.lookupURL(originalURL)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("URL %s: %w", originalURL, errs.ErrNotFound)
+	}
+	return id, nil
+}
+
+// ForEachID вызывает fn для ID каждой хранимой записи, включая удалённые.
+// Хранилище блокируется только на время копирования ключей keydir.
+func (s *Store) ForEachID(ctx context.Context, fn func(id string) error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("iterate IDs canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.keydir))
+	for id := range s.keydir {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	for _, id := range ids {
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Iterate вызывает fn для каждой записи с ID больше afterID в порядке возрастания ID.
+// Хранилище блокируется на время копирования ключей и чтения каждой записи, но не на время fn.
+func (s *Store) Iterate(ctx context.Context, afterID string, fn func(models.URLRecord) error) error {
+	var ids []string
+	if err := s.ForEachID(ctx, func(id string) error {
+		if id > afterID {
+			ids = append(ids, id)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	slices.Sort(ids)
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("iterate records canceled: %w", err)
+		}
+
+		s.mu.Lock()
+		record, ok, err := s.read(id)
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		// Запись могла быть вычищена после копирования ключей.
+		if !ok {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUserURLs возвращает ссылки пользователя в порядке создания, пропуская удалённые и просроченные.
+func (s *Store) GetUserURLs(ctx context.Context, userID string) ([]models.URLRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("get user URLs canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	ids := s.users[userID]
+	records := make([]models.URLRecord, 0, len(ids))
+	for _, id := range ids {
+		record, ok, err := s.read(id)
+		if err != nil {
+			return nil, err
+		}
+		if ok && !record.IsDeleted && !record.Expired(now) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// DeleteURLs помечает удалёнными ссылки, принадлежащие авторам запросов.
+func (s *Store) DeleteURLs(ctx context.Context, requests []models.DeleteRequest) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("delete URLs canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, request := range requests {
+		loc, ok := s.keydir[request.ShortID]
+		if !ok || request.UserID == "" || loc.userID != request.UserID {
+			continue
+		}
+		record, ok, err := s.read(request.ShortID)
+		if err != nil {
+			return err
+		}
+		if !ok || record.IsDeleted {
+			continue
+		}
+		record.IsDeleted = true
+		if err := s.put(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteExpired записывает надгробия для ссылок, срок жизни которых истёк к моменту now.
+func (s *Store) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("delete expired canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []string
+	for id, loc := range s.keydir {
+		if loc.expiresAt != 0 && loc.expiresAt <= now.UnixNano() {
+			expired = append(expired, id)
+		}
+	}
+	for i, id := range expired {
+		if err := s.write(entry{key: id, flags: flagTombstone}); err != nil {
+			return i, fmt.Errorf("%w: failed to delete expired record: %w", errs.ErrUnavailable, err)
+		}
+	}
+	return len(expired), nil
+}
+
+// RecordClicks записывает новые версии ссылок с увеличенными счётчиками переходов.
+func (s *Store) RecordClicks(ctx context.Context, clicks []models.ClickStat) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("record clicks canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, click := range clicks {
+		record, ok, err := s.read(click.ShortID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		record.Clicks += click.Clicks
+		if click.LastAccessedAt.After(record.LastAccessedAt) {
+			record.LastAccessedAt = click.LastAccessedAt
+		}
+		if err := s.put(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) GetStats(ctx context.Context, id string) (models.ClickStat, error) {
+	if err := ctx.Err(); err != nil {
+		return models.ClickStat{}, fmt.Errorf("get stats canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok, err := s.read(id)
+	if err != nil {
+		return models.ClickStat{}, err
+	}
+	if !ok {
+		return models.ClickStat{}, fmt.Errorf("ID %s: %w", id, errs.ErrNotFound)
+	}
+	return models.ClickStat{ShortID: id, Clicks: record.Clicks, LastAccessedAt: record.LastAccessedAt}, nil
+}
+
+// SaveBatch сохраняет пакет записей. Повторы URL внутри пакета получают ID первого вхождения.
+func (s *Store) SaveBatch(ctx context.Context, records []models.URLRecord) ([]models.BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("save batch canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]models.BatchResult, len(records))
+	for i, record := range records {
+		result, err := s.saveBatchRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// saveBatchRecord сохраняет одну запись пакета. Удалённая запись, перенесённая из другого
+// хранилища, URL не занимает и конфликтует только по ID, а если под её ID уже сохранён
+// тот же URL, считается существующей. Вызывается под s.mu.
+func (s *Store) saveBatchRecord(record models.URLRecord) (models.BatchResult, error) {
+	if !record.IsDeleted {
+		existingID, exists, err := s.lookupURL(record.OriginalURL)
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		if exists {
+			return models.BatchResult{ShortID: existingID, Status: models.BatchExisted}, nil
+		}
+	}
+	existing, found, err := s.read(record.ShortID)
+	if err != nil {
+		return models.BatchResult{}, err
+	}
+	if found {
+		if record.IsDeleted && existing.OriginalURL == record.OriginalURL {
+			return models.BatchResult{ShortID: record.ShortID, Status: models.BatchExisted}, nil
+		}
+		return models.BatchResult{
+			Status: models.BatchFailed,
+			Err:    fmt.Errorf("%w: %s", errs.ErrIDConflict, record.ShortID),
+		}, nil
+	}
+
+	if err := s.put(record); err != nil {
+		return models.BatchResult{}, err
+	}
+	return models.BatchResult{ShortID: record.ShortID, Status: models.BatchCreated}, nil
+}
+
+// Flush сбрасывает активный сегмент на диск.
+func (s *Store) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("flush canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		return nil
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("%w: failed to sync segment %d: %w", errs.ErrUnavailable, s.activeID, err)
+	}
+	return nil
+}
+
+// Close останавливает фоновое слияние, сбрасывает активный сегмент на диск и закрывает файлы.
+func (s *Store) Close(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return fmt.Errorf("close bitcask store canceled: %w", ctx.Err())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.active != nil {
+		if syncErr := s.active.Sync(); syncErr != nil {
+			err = fmt.Errorf("failed to sync segment %d: %w", s.activeID, syncErr)
+		}
+	}
+	s.closeFiles()
+	return err
+}
+
+// closeFiles закрывает все открытые сегменты.
+func (s *Store) closeFiles() {
+	for id, f := range s.files {
+		if err := f.Close(); err != nil {
+			s.logger.Error("Failed to close segment", zap.Uint32("segment", id), zap.Error(err))
+		}
+	}
+	s.files = make(map[uint32]*os.File)
+	s.active = nil
+}
+
+func (s *Store) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.options.MergeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.maybeMerge(); err != nil {
+				s.logger.Error("Failed to merge segments", zap.Error(err))
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func removeSegment(dir string, id uint32) error {
+	for _, ext := range []string{hintExt, dataExt} {
+		if err := os.Remove(segmentPath(dir, id, ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove segment %d: %w", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package bitcask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestStore(t *testing.T, dir string, options Options) *Store {
+	t.Helper()
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+	store, err := New(dir, options, logger.NewZapLogger(zapLogger))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close(context.Background())
+	})
+	return store
+}
+
+func reopen(t *testing.T, store *Store) *Store {
+	t.Helper()
+
+	require.NoError(t, store.Close(context.Background()))
+	return newTestStore(t, store.dir, store.options)
+}
+
+func segmentFiles(t *testing.T, dir string) []uint32 {
+	t.Helper()
+
+	ids, err := listSegments(dir)
+	require.NoError(t, err)
+	return ids
+}
+
+// fillStore сохраняет count ссылок пользователя, удаляет первую, добавляет переходы ко второй
+// и делает последнюю просроченной.
+func fillStore(t *testing.T, store *Store, count int) {
+	t.Helper()
+
+	ctx := context.Background()
+	for i := range count {
+		record := models.URLRecord{
+			ShortID:     fmt.Sprintf("id%d", i),
+			OriginalURL: fmt.Sprintf("https://example.com/%d", i),
+			UserID:      "user",
+		}
+		if i == count-1 {
+			record.ExpiresAt = time.Now().Add(-time.Minute)
+		}
+		require.NoError(t, store.SaveID(ctx, record))
+	}
+	require.NoError(t, store.DeleteURLs(ctx, []models.DeleteRequest{{UserID: "user", ShortID: "id0"}}))
+	for range 3 {
+		require.NoError(t, store.RecordClicks(ctx, []models.ClickStat{{ShortID: "id1", Clicks: 1}}))
+	}
+	deleted, err := store.DeleteExpired(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+}
+
+func assertFilledState(t *testing.T, store *Store, count int) {
+	t.Helper()
+
+	ctx := context.Background()
+	_, err := store.Get(ctx, "id0")
+	assert.ErrorIs(t, err, errs.ErrDeleted)
+	_, err = store.Get(ctx, fmt.Sprintf("id%d", count-1))
+	assert.ErrorIs(t, err, errs.ErrNotFound)
+
+	stat, err := store.GetStats(ctx, "id1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stat.Clicks)
+
+	id, err := store.GetIDByURL(ctx, "https://example.com/2")
+	require.NoError(t, err)
+	assert.Equal(t, "id2", id)
+
+	records, err := store.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, records, count-2)
+}
+
+func TestStoreOperations(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, t.TempDir(), Options{MaxSegmentSize: 1 << 20})
+
+	record := models.URLRecord{ShortID: "abc", OriginalURL: "https://example.com", UserID: "user"}
+	require.NoError(t, store.SaveID(ctx, record))
+	assert.ErrorIs(t, store.SaveID(ctx, record), errs.ErrIDConflict)
+	assert.ErrorIs(t,
+		store.SaveID(ctx, models.URLRecord{ShortID: "other", OriginalURL: record.OriginalURL}), errs.ErrURLConflict)
+
+	id, existed, err := store.GetOrCreate(ctx, models.URLRecord{ShortID: "new", OriginalURL: record.OriginalURL})
+	require.NoError(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, "abc", id)
+
+	results, err := store.SaveBatch(ctx, []models.URLRecord{
+		{ShortID: "b1", OriginalURL: "https://example.com/b"},
+		{ShortID: "b2", OriginalURL: "https://example.com/b"},
+		{ShortID: "abc", OriginalURL: "https://example.com/c"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, models.BatchCreated, results[0].Status)
+	assert.Equal(t, models.BatchExisted, results[1].Status)
+	assert.Equal(t, "b1", results[1].ShortID)
+	assert.Equal(t, models.BatchFailed, results[2].Status)
+
+	// Удалить ссылку может только её владелец.
+	require.NoError(t, store.DeleteURLs(ctx, []models.DeleteRequest{{UserID: "other", ShortID: "abc"}}))
+	originalURL, err := store.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, record.OriginalURL, originalURL)
+}
+
+func TestSaveBatchDeletedRecord(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, t.TempDir(), Options{MaxSegmentSize: 1 << 20})
+
+	// Удалённая запись пакета не занимает URL, и повтор пакета находит обе записи на месте.
+	batch := []models.URLRecord{
+		{ShortID: "aaa", OriginalURL: "https://example.com/shared", UserID: "user", IsDeleted: true},
+		{ShortID: "bbb", OriginalURL: "https://example.com/shared", UserID: "user"},
+	}
+	results, err := store.SaveBatch(ctx, batch)
+	require.NoError(t, err)
+	assert.Equal(t, models.BatchCreated, results[0].Status)
+	assert.Equal(t, models.BatchCreated, results[1].Status)
+
+	results, err = store.SaveBatch(ctx, batch)
+	require.NoError(t, err)
+	assert.Equal(t, models.BatchResult{ShortID: "aaa", Status: models.BatchExisted}, results[0])
+	assert.Equal(t, models.BatchResult{ShortID: "bbb", Status: models.BatchExisted}, results[1])
+
+	restarted := reopen(t, store)
+	_, err = restarted.Get(ctx, "aaa")
+	require.ErrorIs(t, err, errs.ErrDeleted)
+	id, err := restarted.GetIDByURL(ctx, "https://example.com/shared")
+	require.NoError(t, err)
+	assert.Equal(t, "bbb", id)
+}
+
+func TestReloadFromSegmentsAndHints(t *testing.T) {
+	const count = 50
+	// Маленькие сегменты заставляют хранилище закрывать их и писать подсказки.
+	store := newTestStore(t, t.TempDir(), Options{MaxSegmentSize: 512})
+	fillStore(t, store, count)
+	assert.Greater(t, len(segmentFiles(t, store.dir)), 2)
+
+	_, err := os.Stat(segmentPath(store.dir, 1, hintExt))
+	require.NoError(t, err)
+
+	restarted := reopen(t, store)
+	assertFilledState(t, restarted, count)
+}
+
+func TestMerge(t *testing.T) {
+	const count = 50
+	ctx := context.Background()
+	store := newTestStore(t, t.TempDir(), Options{MaxSegmentSize: 512})
+	fillStore(t, store, count)
+
+	require.NoError(t, store.Merge(ctx))
+	ids := segmentFiles(t, store.dir)
+	require.Len(t, ids, 2, "merged segment and empty active segment expected")
+	assertFilledState(t, store, count)
+
+	// Запись после слияния попадает в новый активный сегмент и переживает перезапуск.
+	require.NoError(t, store.SaveID(ctx, models.URLRecord{ShortID: "after", OriginalURL: "https://example.com/after"}))
+	restarted := reopen(t, store)
+	assertFilledState(t, restarted, count)
+	_, err := restarted.Get(ctx, "after")
+	require.NoError(t, err)
+}
+
+func TestLoadRemovesSegmentsCoveredByMerge(t *testing.T) {
+	const count = 20
+	ctx := context.Background()
+	store := newTestStore(t, t.TempDir(), Options{MaxSegmentSize: 512})
+	fillStore(t, store, count)
+
+	// Копии старых сегментов имитируют сбой между переименованием слитого сегмента и удалением старых.
+	oldSegments := make(map[uint32][]byte)
+	for _, id := range segmentFiles(t, store.dir)[:2] {
+		data, err := os.ReadFile(segmentPath(store.dir, id, dataExt))
+		require.NoError(t, err)
+		oldSegments[id] = data
+	}
+	require.NoError(t, store.Merge(ctx))
+	require.NoError(t, store.Close(ctx))
+	for id, data := range oldSegments {
+		if _, err := os.Stat(segmentPath(store.dir, id, dataExt)); errors.Is(err, os.ErrNotExist) {
+			require.NoError(t, os.WriteFile(segmentPath(store.dir, id, dataExt), data, 0o600))
+		}
+	}
+
+	// Удалённая ссылка не воскресает из старых сегментов.
+	restarted := newTestStore(t, store.dir, store.options)
+	assertFilledState(t, restarted, count)
+	assert.Len(t, segmentFiles(t, store.dir), 2)
+}
+
+func TestTornEntryIsTruncated(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, t.TempDir(), Options{MaxSegmentSize: 1 << 20})
+	require.NoError(t, store.SaveID(ctx, models.URLRecord{ShortID: "id1", OriginalURL: "https://example.com/1"}))
+	require.NoError(t, store.Close(ctx))
+
+	path := segmentPath(store.dir, store.activeID, dataExt)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	value := encodeRecord(models.URLRecord{OriginalURL: "https://example.com/2"})
+	torn := appendEntry(nil, entry{key: "id2", value: value})
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write(torn[:len(torn)-2])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	restarted := newTestStore(t, store.dir, store.options)
+	_, err = restarted.Get(ctx, "id1")
+	require.NoError(t, err)
+	_, err = restarted.Get(ctx, "id2")
+	assert.ErrorIs(t, err, errs.ErrNotFound)
+
+	truncated, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), truncated.Size())
+}
@@ -0,0 +1,177 @@
+package bitcask
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"go.uber.org/zap"
+)
+
+// Merge переписывает актуальные записи всех закрытых сегментов в один сегмент и удаляет старые.
+// Перед слиянием активный сегмент закрывается, чтобы слияние охватило все существующие записи.
+func (s *Store) Merge(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("merge canceled: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.merge(); err != nil {
+		return fmt.Errorf("%w: failed to merge segments: %w", errs.ErrUnavailable, err)
+	}
+	return nil
+}
+
+// maybeMerge выполняет слияние, если устаревшие записи в закрытых сегментах превысили порог.
+func (s *Store) maybeMerge() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var dead int64
+	for id, size := range s.deadBytes {
+		if id != s.activeID {
+			dead += size
+		}
+	}
+	if dead == 0 || dead < s.options.MergeMinDeadBytes {
+		return nil
+	}
+	return s.merge()
+}
+
+// merge записывает актуальные записи закрытых сегментов в сегмент с наибольшим из их номеров.
+// Сегмент начинается с маркера слияния, поэтому после его переименования старые сегменты
+// считаются устаревшими, даже если сбой помешает их удалить. Вызывается под s.mu.
+func (s *Store) merge() error {
+	if s.activeSize > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	var (
+		sealed     []uint32
+		sizeBefore int64
+	)
+	for id, f := range s.files {
+		if id == s.activeID {
+			continue
+		}
+		sealed = append(sealed, id)
+		if info, err := f.Stat(); err == nil {
+			sizeBefore += info.Size()
+		}
+	}
+	if len(sealed) == 0 {
+		return nil
+	}
+	mergedID := slices.Max(sealed)
+
+	tmp, err := os.CreateTemp(s.dir, "merge-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create merge file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	// После успешного переименования временного файла уже нет, и удаление вернёт ошибку, которую можно игнорировать.
+	defer func() {
+		_ = os.Remove(tmpPath)
+	}()
+
+	writer := bufio.NewWriter(tmp)
+	marker := appendEntry(nil, entry{flags: flagMergeMarker})
+	offset := int64(len(marker))
+	if _, err := writer.Write(marker); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write merge file: %w", err)
+	}
+
+	moved := make(map[string]location)
+	var hints []byte
+	for key, loc := range s.keydir {
+		if loc.segment == s.activeID {
+			continue
+		}
+		e, err := readEntryAt(s.files[loc.segment], loc.offset, loc.size)
+		if err != nil {
+			_ = tmp.Close()
+			return fmt.Errorf("failed to read entry %s: %w", key, err)
+		}
+		data := appendEntry(nil, e)
+		if _, err := writer.Write(data); err != nil {
+			_ = tmp.Close()
+			return fmt.Errorf("failed to write merge file: %w", err)
+		}
+
+		newLoc := loc
+		newLoc.segment, newLoc.offset = mergedID, offset
+		moved[key] = newLoc
+		hints = appendHint(hints, hint{key: key, location: newLoc})
+		offset += int64(len(data))
+	}
+
+	if err := writer.Flush(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write merge file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to sync merge file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close merge file: %w", err)
+	}
+
+	// Подсказки заменяемого сегмента описывают старое расположение записей.
+	if err := os.Remove(segmentPath(s.dir, mergedID, hintExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove hint file: %w", err)
+	}
+	if err := os.Rename(tmpPath, segmentPath(s.dir, mergedID, dataExt)); err != nil {
+		return fmt.Errorf("failed to replace segment %d: %w", mergedID, err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+
+	merged, err := os.Open(segmentPath(s.dir, mergedID, dataExt))
+	if err != nil {
+		return fmt.Errorf("failed to open merged segment: %w", err)
+	}
+	for _, id := range sealed {
+		if err := s.files[id].Close(); err != nil {
+			s.logger.Error("Failed to close segment", zap.Uint32("segment", id), zap.Error(err))
+		}
+		delete(s.files, id)
+		delete(s.deadBytes, id)
+		if id == mergedID {
+			continue
+		}
+		// Оставшиеся после сбоя сегменты будут удалены при следующей загрузке.
+		if err := removeSegment(s.dir, id); err != nil {
+			s.logger.Error("Failed to remove merged segment", zap.Uint32("segment", id), zap.Error(err))
+		}
+	}
+	s.files[mergedID] = merged
+	for key, loc := range moved {
+		s.keydir[key] = loc
+	}
+
+	// Без подсказок слитый сегмент будет просканирован при загрузке, поэтому ошибка не фатальна.
+	if err := writeFileAtomically(segmentPath(s.dir, mergedID, hintExt), hints); err != nil {
+		s.logger.Error("Failed to write hints for merged segment", zap.Uint32("segment", mergedID), zap.Error(err))
+	}
+
+	s.logger.Info("Segments merged",
+		zap.Int("segments", len(sealed)),
+		zap.Int("keys", len(moved)),
+		zap.Int64("size_before", sizeBefore),
+		zap.Int64("size_after", offset),
+		zap.Int64("bytes_reclaimed", sizeBefore-offset),
+	)
+	return nil
+}
@@ -0,0 +1,407 @@
+package bitcask
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/models"
+)
+
+const (
+	dataExt = ".data"
+	hintExt = ".hint"
+
+	// entryHeaderSize — CRC32, длина значения, длина ключа и флаги записи сегмента.
+	entryHeaderSize = 11
+	// hintHeaderSize — CRC32, флаги, длины ключа и владельца, смещение, размер, срок жизни и хеш URL.
+	hintHeaderSize = 37
+)
+
+const (
+	// flagTombstone — запись удаляет ключ из индекса.
+	flagTombstone byte = 1 << iota
+	// flagMergeMarker — первая запись сегмента, полученного слиянием: все сегменты с меньшими номерами устарели.
+	flagMergeMarker
+	// flagDeleted — флаг подсказки: ссылка помечена удалённой, и её URL не попадает в индекс.
+	flagDeleted
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// errCorruptEntry — запись сегмента или подсказки повреждена или оборвана.
+	errCorruptEntry = errors.New("corrupted entry")
+)
+
+// entry — запись сегмента данных. Значение — закодированная models.URLRecord.
+type entry struct {
+	key   string
+	value []byte
+	flags byte
+}
+
+// location — положение актуальной версии ключа и данные для вторичных индексов.
+type location struct {
+	userID    string
+	offset    int64
+	expiresAt int64
+	urlHash   uint64
+	segment   uint32
+	size      uint32
+	deleted   bool
+}
+
+// hint — запись файла подсказок: всё, что нужно для индекса, без чтения значения.
+type hint struct {
+	key string
+	location
+	tombstone bool
+}
+
+func segmentPath(dir string, id uint32, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf("%09d%s", id, ext))
+}
+
+// listSegments возвращает номера сегментов данных в каталоге по возрастанию.
+func listSegments(dir string) ([]uint32, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+
+	var ids []uint32
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), dataExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		id, err := strconv.ParseUint(name, 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func hashURL(url string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(url))
+	return h.Sum64()
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// appendEntry дописывает к buf запись сегмента: CRC32-C, длину значения, длину ключа, флаги, ключ и значение.
+// Контрольная сумма покрывает всё, что следует за ней.
+func appendEntry(buf []byte, e entry) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, entryHeaderSize)...)
+	header := buf[start:]
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(e.value)))
+	binary.LittleEndian.PutUint16(header[8:], uint16(len(e.key)))
+	header[10] = e.flags
+
+	buf = append(buf, e.key...)
+	buf = append(buf, e.value...)
+	binary.LittleEndian.PutUint32(buf[start:], crc32.Checksum(buf[start+4:], crcTable))
+	return buf
+}
+
+// decodeEntry разбирает запись сегмента целиком и проверяет контрольную сумму.
+func decodeEntry(data []byte) (entry, error) {
+	if len(data) < entryHeaderSize {
+		return entry{}, fmt.Errorf("%w: short entry", errCorruptEntry)
+	}
+	valueLen := int(binary.LittleEndian.Uint32(data[4:]))
+	keyLen := int(binary.LittleEndian.Uint16(data[8:]))
+	if len(data) != entryHeaderSize+keyLen+valueLen {
+		return entry{}, fmt.Errorf("%w: entry size mismatch", errCorruptEntry)
+	}
+	if crc32.Checksum(data[4:], crcTable) != binary.LittleEndian.Uint32(data) {
+		return entry{}, fmt.Errorf("%w: checksum mismatch", errCorruptEntry)
+	}
+
+	return entry{
+		flags: data[10],
+		key:   string(data[entryHeaderSize : entryHeaderSize+keyLen]),
+		value: data[entryHeaderSize+keyLen:],
+	}, nil
+}
+
+// readEntry читает следующую запись сегмента и возвращает её размер.
+// В конце файла возвращает io.EOF, для оборванной или повреждённой записи — ошибку с errCorruptEntry.
+func readEntry(r *bufio.Reader, maxValueSize int) (entry, int, error) {
+	header, err := r.Peek(entryHeaderSize)
+	if len(header) == 0 && errors.Is(err, io.EOF) {
+		return entry{}, 0, io.EOF
+	}
+	if errors.Is(err, io.EOF) {
+		return entry{}, 0, fmt.Errorf("%w: incomplete entry header", errCorruptEntry)
+	} else if err != nil {
+		return entry{}, 0, fmt.Errorf("failed to read segment: %w", err)
+	}
+
+	valueLen := int(binary.LittleEndian.Uint32(header[4:]))
+	keyLen := int(binary.LittleEndian.Uint16(header[8:]))
+	if valueLen > maxValueSize {
+		return entry{}, 0, fmt.Errorf("%w: value length %d exceeds limit", errCorruptEntry, valueLen)
+	}
+
+	data := make([]byte, entryHeaderSize+keyLen+valueLen)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return entry{}, 0, fmt.Errorf("%w: incomplete entry", errCorruptEntry)
+		}
+		return entry{}, 0, fmt.Errorf("failed to read segment: %w", err)
+	}
+	e, err := decodeEntry(data)
+	return e, len(data), err
+}
+
+// readEntryAt читает запись сегмента по известному положению.
+func readEntryAt(f *os.File, offset int64, size uint32) (entry, error) {
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, offset); err != nil {
+		return entry{}, fmt.Errorf("failed to read entry at %d: %w", offset, err)
+	}
+	return decodeEntry(data)
+}
+
+// isMerged сообщает, что сегмент получен слиянием: его первая запись — маркер слияния.
+func isMerged(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open segment %s: %w", path, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	e, _, err := readEntry(bufio.NewReader(f), 0)
+	if err != nil {
+		// Пустой или оборванный сегмент не может быть результатом слияния.
+		return false, nil
+	}
+	return e.flags&flagMergeMarker != 0, nil
+}
+
+// appendHint дописывает к buf запись файла подсказок.
+func appendHint(buf []byte, h hint) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, hintHeaderSize)...)
+	header := buf[start:]
+	if h.tombstone {
+		header[4] |= flagTombstone
+	}
+	if h.deleted {
+		header[4] |= flagDeleted
+	}
+	binary.LittleEndian.PutUint16(header[5:], uint16(len(h.key)))
+	binary.LittleEndian.PutUint16(header[7:], uint16(len(h.userID)))
+	binary.LittleEndian.PutUint64(header[9:], uint64(h.offset))
+	binary.LittleEndian.PutUint32(header[17:], h.size)
+	binary.LittleEndian.PutUint64(header[21:], uint64(h.expiresAt))
+	binary.LittleEndian.PutUint64(header[29:], h.urlHash)
+
+	buf = append(buf, h.key...)
+	buf = append(buf, h.userID...)
+	binary.LittleEndian.PutUint32(buf[start:], crc32.Checksum(buf[start+4:], crcTable))
+	return buf
+}
+
+// readHints читает файл подсказок целиком. Любое повреждение делает файл непригодным.
+func readHints(path string, segment uint32) ([]hint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hint file %s: %w", path, err)
+	}
+
+	var hints []hint
+	for len(data) > 0 {
+		if len(data) < hintHeaderSize {
+			return nil, fmt.Errorf("%w: incomplete hint", errCorruptEntry)
+		}
+		keyLen := int(binary.LittleEndian.Uint16(data[5:]))
+		userLen := int(binary.LittleEndian.Uint16(data[7:]))
+		size := hintHeaderSize + keyLen + userLen
+		if len(data) < size {
+			return nil, fmt.Errorf("%w: incomplete hint", errCorruptEntry)
+		}
+		if crc32.Checksum(data[4:size], crcTable) != binary.LittleEndian.Uint32(data) {
+			return nil, fmt.Errorf("%w: hint checksum mismatch", errCorruptEntry)
+		}
+
+		hints = append(hints, hint{
+			key:       string(data[hintHeaderSize : hintHeaderSize+keyLen]),
+			tombstone: data[4]&flagTombstone != 0,
+			location: location{
+				segment:   segment,
+				userID:    string(data[hintHeaderSize+keyLen : size]),
+				offset:    int64(binary.LittleEndian.Uint64(data[9:])),
+				size:      binary.LittleEndian.Uint32(data[17:]),
+				expiresAt: int64(binary.LittleEndian.Uint64(data[21:])),
+				urlHash:   binary.LittleEndian.Uint64(data[29:]),
+				deleted:   data[4]&flagDeleted != 0,
+			},
+		})
+		data = data[size:]
+	}
+	return hints, nil
+}
+
+// writeFileAtomically записывает данные во временный файл, сбрасывает его на диск
+// и переименовывает в path.
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	// После успешного переименования временного файла уже нет, и удаление вернёт ошибку, которую можно игнорировать.
+	defer func() {
+		_ = os.Remove(tmpPath)
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
+
+// syncDir сбрасывает на диск каталог, чтобы создание и переименование файлов пережили сбой.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %s: %w", dir, err)
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+const (
+	recordFlagDeleted byte = 1 << iota
+	recordFlagExpiresAt
+	recordFlagLastAccessedAt
+)
+
+// encodeRecord кодирует значение записи: флаги, URL и владельца с префиксом длины,
+// счётчик переходов и необязательные метки времени. ID хранится в ключе.
+func encodeRecord(record models.URLRecord) []byte {
+	var flags byte
+	if record.IsDeleted {
+		flags |= recordFlagDeleted
+	}
+	if !record.ExpiresAt.IsZero() {
+		flags |= recordFlagExpiresAt
+	}
+	if !record.LastAccessedAt.IsZero() {
+		flags |= recordFlagLastAccessedAt
+	}
+
+	buf := []byte{flags}
+	buf = binary.AppendUvarint(buf, uint64(len(record.OriginalURL)))
+	buf = append(buf, record.OriginalURL...)
+	buf = binary.AppendUvarint(buf, uint64(len(record.UserID)))
+	buf = append(buf, record.UserID...)
+	buf = binary.AppendVarint(buf, record.Clicks)
+	if flags&recordFlagExpiresAt != 0 {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(record.ExpiresAt.UnixNano()))
+	}
+	if flags&recordFlagLastAccessedAt != 0 {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(record.LastAccessedAt.UnixNano()))
+	}
+	return buf
+}
+
+func decodeRecord(id string, value []byte) (models.URLRecord, error) {
+	errTruncated := fmt.Errorf("%w: truncated record value", errCorruptEntry)
+	if len(value) == 0 {
+		return models.URLRecord{}, errTruncated
+	}
+	flags := value[0]
+	value = value[1:]
+
+	readString := func() (string, bool) {
+		length, n := binary.Uvarint(value)
+		if n <= 0 || length > math.MaxInt32 || uint64(len(value)-n) < length {
+			return "", false
+		}
+		s := string(value[n : n+int(length)])
+		value = value[n+int(length):]
+		return s, true
+	}
+	readTime := func() (time.Time, bool) {
+		const timeSize = 8
+		if len(value) < timeSize {
+			return time.Time{}, false
+		}
+		t := time.Unix(0, int64(binary.LittleEndian.Uint64(value))).UTC()
+		value = value[timeSize:]
+		return t, true
+	}
+
+	record := models.URLRecord{ShortID: id, IsDeleted: flags&recordFlagDeleted != 0}
+	var ok bool
+	if record.OriginalURL, ok = readString(); !ok {
+		return record, errTruncated
+	}
+	if record.UserID, ok = readString(); !ok {
+		return record, errTruncated
+	}
+	clicks, n := binary.Varint(value)
+	if n <= 0 {
+		return record, errTruncated
+	}
+	record.Clicks = clicks
+	value = value[n:]
+	if flags&recordFlagExpiresAt != 0 {
+		if record.ExpiresAt, ok = readTime(); !ok {
+			return record, errTruncated
+		}
+	}
+	if flags&recordFlagLastAccessedAt != 0 {
+		if record.LastAccessedAt, ok = readTime(); !ok {
+			return record, errTruncated
+		}
+	}
+	if len(value) != 0 {
+		return record, fmt.Errorf("%w: unexpected trailing bytes in record value", errCorruptEntry)
+	}
+	return record, nil
+}
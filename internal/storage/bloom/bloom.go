@@ -0,0 +1,130 @@
+// Package bloom реализует фильтр Блума для быстрой проверки отсутствия ключа.
+package bloom
+
+import (
+	"hash/maphash"
+	"math"
+	"sync/atomic"
+)
+
+// Options — настройки фильтра.
+type Options struct {
+	// FalsePositiveRate — целевая доля ложных срабатываний. Нулевое значение отключает фильтр.
+	FalsePositiveRate float64
+	// MaxBytes — наибольший объём памяти под биты фильтра.
+	MaxBytes int64
+	// MinCapacity — наименьшее число ключей, на которое рассчитывается фильтр.
+	MinCapacity int
+}
+
+// DefaultOptions возвращает настройки по умолчанию: фильтр отключён, при включении
+// рассчитывается минимум на миллион ключей и занимает не более 64 МиБ.
+func DefaultOptions() Options {
+	const (
+		defaultMaxBytes    = 64 << 20
+		defaultMinCapacity = 1_000_000
+	)
+	return Options{MaxBytes: defaultMaxBytes, MinCapacity: defaultMinCapacity}
+}
+
+// Filter — потокобезопасный фильтр Блума. Добавление и проверка не берут блокировок.
+// Отрицательный ответ MayContain точен, положительный может быть ложным.
+type Filter struct {
+	seeds    [2]maphash.Seed
+	bits     []atomic.Uint64
+	count    *atomic.Int64
+	size     uint64
+	hashes   int
+	capacity int
+}
+
+// New создаёт фильтр на capacity ключей с долей ложных срабатываний rate.
+// Если такой фильтр не помещается в maxBytes, он урезается, и доля ложных срабатываний растёт.
+func New(capacity int, rate float64, maxBytes int64) *Filter {
+	const (
+		bitsPerWord  = 64
+		bytesPerWord = 8
+	)
+	capacity = max(capacity, 1)
+	ln2 := math.Ln2
+	bitCount := math.Ceil(-float64(capacity) * math.Log(rate) / (ln2 * ln2))
+	words := int64(math.Ceil(bitCount / bitsPerWord))
+	words = max(min(words, maxBytes/bytesPerWord), 1)
+
+	size := uint64(words) * bitsPerWord
+	hashes := int(math.Round(float64(size) / float64(capacity) * ln2))
+	return &Filter{
+		seeds:    [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+		bits:     make([]atomic.Uint64, words),
+		count:    &atomic.Int64{},
+		size:     size,
+		hashes:   max(hashes, 1),
+		capacity: capacity,
+	}
+}
+
+// Add добавляет ключ в фильтр.
+func (f *Filter) Add(key string) {
+	h1, h2 := f.hash(key)
+	for i := range f.hashes {
+		word, mask := f.position(h1, h2, i)
+		for {
+			old := f.bits[word].Load()
+			if old&mask != 0 || f.bits[word].CompareAndSwap(old, old|mask) {
+				break
+			}
+		}
+	}
+	f.count.Add(1)
+}
+
+// Full сообщает, что в фильтр добавлено больше ключей, чем он рассчитан: дальше доля
+// ложных срабатываний превышает заданную.
+func (f *Filter) Full() bool {
+	return f.count.Load() > int64(f.capacity)
+}
+
+// MayContain сообщает, мог ли ключ быть добавлен в фильтр.
+func (f *Filter) MayContain(key string) bool {
+	h1, h2 := f.hash(key)
+	for i := range f.hashes {
+		word, mask := f.position(h1, h2, i)
+		if f.bits[word].Load()&mask == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Stats — параметры и заполненность фильтра.
+type Stats struct {
+	Bits   uint64
+	Hashes int
+	// Capacity — число ключей, на которое рассчитан фильтр.
+	Capacity int
+	// Added — число вызовов Add, включая повторы одного ключа.
+	Added int64
+	// EstimatedFalsePositiveRate — ожидаемая доля ложных срабатываний при текущем заполнении.
+	EstimatedFalsePositiveRate float64
+}
+
+// Stats возвращает параметры фильтра и оценку доли ложных срабатываний.
+func (f *Filter) Stats() Stats {
+	added := f.count.Load()
+	rate := math.Pow(1-math.Exp(-float64(f.hashes)*float64(added)/float64(f.size)), float64(f.hashes))
+	return Stats{
+		Bits: f.size, Hashes: f.hashes, Capacity: f.capacity, Added: added, EstimatedFalsePositiveRate: rate,
+	}
+}
+
+// hash возвращает два независимых хеша ключа для двойного хеширования.
+func (f *Filter) hash(key string) (uint64, uint64) {
+	return maphash.String(f.seeds[0], key), maphash.String(f.seeds[1], key) | 1
+}
+
+// position возвращает слово и маску бита для i-й хеш-функции.
+func (f *Filter) position(h1, h2 uint64, i int) (int, uint64) {
+	const bitsPerWord = 64
+	bit := (h1 + uint64(i)*h2) % f.size
+	return int(bit / bitsPerWord), 1 << (bit % bitsPerWord)
+}
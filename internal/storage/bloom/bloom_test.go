@@ -0,0 +1,43 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	const (
+		count = 10000
+		rate  = 0.01
+	)
+	filter := New(count, rate, 1<<20)
+	for i := range count {
+		filter.Add(fmt.Sprintf("id%d", i))
+	}
+	assert.False(t, filter.Full())
+	filter.Add("extra")
+	assert.True(t, filter.Full())
+
+	for i := range count {
+		assert.True(t, filter.MayContain(fmt.Sprintf("id%d", i)))
+	}
+
+	var falsePositives int
+	for i := range count {
+		if filter.MayContain(fmt.Sprintf("missing%d", i)) {
+			falsePositives++
+		}
+	}
+	// Запас в три раза от целевой доли исключает случайные падения.
+	assert.Less(t, float64(falsePositives)/count, 3*rate)
+	assert.InDelta(t, rate, filter.Stats().EstimatedFalsePositiveRate, rate)
+}
+
+func TestFilterMemoryBudget(t *testing.T) {
+	const maxBytes = 1024
+	filter := New(1_000_000, 0.001, maxBytes)
+	assert.Equal(t, uint64(maxBytes*8), filter.Stats().Bits)
+	assert.GreaterOrEqual(t, filter.Stats().Hashes, 1)
+}
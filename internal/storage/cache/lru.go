@@ -0,0 +1,166 @@
+// Package cache реализует ограниченный LRU-кеш со сроком жизни записей.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Options — настройки кеша.
+type Options struct {
+	// Size — наибольшее число записей. Нулевое значение отключает кеш.
+	Size int
+	// TTL — срок жизни записи. Нулевое значение означает бессрочные записи.
+	TTL time.Duration
+}
+
+// DefaultOptions возвращает настройки по умолчанию: кеш отключён, записи живут минуту.
+func DefaultOptions() Options {
+	const defaultTTL = time.Minute
+	return Options{TTL: defaultTTL}
+}
+
+type item[K comparable, V any] struct {
+	expiresAt time.Time
+	key       K
+	value     V
+}
+
+// LRU — потокобезопасный кеш, вытесняющий давно не использованные записи.
+// Каждое удаление увеличивает поколение кеша, что позволяет не сохранять значения,
+// прочитанные из источника до удаления: см. Generation и AddIfGeneration.
+type LRU[K comparable, V any] struct {
+	mu         *sync.Mutex
+	order      *list.List
+	items      map[K]*list.Element
+	now        func() time.Time
+	size       int
+	ttl        time.Duration
+	generation uint64
+}
+
+// NewLRU создаёт кеш на size записей со сроком жизни ttl.
+func NewLRU[K comparable, V any](size int, ttl time.Duration) *LRU[K, V] {
+	return &LRU[K, V]{
+		mu:    &sync.Mutex{},
+		order: list.New(),
+		items: make(map[K]*list.Element, size),
+		now:   time.Now,
+		size:  size,
+		ttl:   ttl,
+	}
+}
+
+// Get возвращает значение по ключу, если оно есть и не устарело.
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	element, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	it := element.Value.(*item[K, V])
+	if !it.expiresAt.IsZero() && !c.now().Before(it.expiresAt) {
+		c.removeElement(element)
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return it.value, true
+}
+
+// Add сохраняет значение.
+func (c *LRU[K, V]) Add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.add(key, value)
+}
+
+// Generation возвращает текущее поколение кеша.
+func (c *LRU[K, V]) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// AddIfGeneration сохраняет значение, только если с момента получения generation ничего не удалялось.
+// Ненулевой deadline сокращает срок жизни записи, если наступает раньше TTL кеша.
+func (c *LRU[K, V]) AddIfGeneration(key K, value V, generation uint64, deadline time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return false
+	}
+	c.addUntil(key, value, deadline)
+	return true
+}
+
+// Remove удаляет записи по ключам.
+func (c *LRU[K, V]) Remove(keys ...K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, key := range keys {
+		if element, ok := c.items[key]; ok {
+			c.removeElement(element)
+		}
+	}
+}
+
+// Purge удаляет все записи.
+func (c *LRU[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.order.Init()
+	clear(c.items)
+}
+
+// Len возвращает число записей, включая ещё не удалённые устаревшие.
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// add сохраняет значение и вытесняет самую старую запись при переполнении. Вызывается под c.mu.
+func (c *LRU[K, V]) add(key K, value V) {
+	c.addUntil(key, value, time.Time{})
+}
+
+// addUntil сохраняет значение до наступления TTL кеша или deadline, смотря что раньше.
+// Вызывается под c.mu.
+func (c *LRU[K, V]) addUntil(key K, value V, deadline time.Time) {
+	expiresAt := deadline
+	if c.ttl > 0 {
+		if ttlExpiresAt := c.now().Add(c.ttl); expiresAt.IsZero() || ttlExpiresAt.Before(expiresAt) {
+			expiresAt = ttlExpiresAt
+		}
+	}
+
+	if element, ok := c.items[key]; ok {
+		it := element.Value.(*item[K, V])
+		it.value, it.expiresAt = value, expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&item[K, V]{key: key, value: value, expiresAt: expiresAt})
+	if c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// removeElement удаляет запись из списка и индекса. Вызывается под c.mu.
+func (c *LRU[K, V]) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*item[K, V]).key)
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewLRU[string, int](2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Add("a", 1)
+	c.Add("b", 2)
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	// Переполнение вытесняет давно не использованную запись.
+	c.Add("c", 3)
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	// Запись, прочитанная до удаления, не сохраняется.
+	generation := c.Generation()
+	c.Remove("a")
+	assert.False(t, c.AddIfGeneration("a", 10, generation, time.Time{}))
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.True(t, c.AddIfGeneration("a", 10, c.Generation(), time.Time{}))
+
+	// Устаревшие записи не возвращаются.
+	now = now.Add(time.Minute)
+	_, ok = c.Get("c")
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+
+	// Срок записи, наступающий раньше TTL, сокращает её жизнь.
+	assert.True(t, c.AddIfGeneration("d", 4, c.Generation(), now.Add(time.Second)))
+	_, ok = c.Get("d")
+	assert.True(t, ok)
+	now = now.Add(time.Second)
+	_, ok = c.Get("d")
+	assert.False(t, ok)
+}
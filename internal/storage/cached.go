@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/cache"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"golang.org/x/sync/singleflight"
+)
+
+// cacheKind различает ключи Get и GetIDByURL в общем кеше.
+type cacheKind byte
+
+const (
+	cacheByID cacheKind = iota
+	cacheByURL
+)
+
+type cacheKey struct {
+	key  string
+	kind cacheKind
+}
+
+// CacheStats — счётчики кеша.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Size   int
+}
+
+// CachedStorage — декоратор хранилища, кеширующий результаты Get и GetIDByURL.
+// Одновременные промахи по одному ключу объединяются в один вызов хранилища,
+// а изменения и удаления сбрасывают затронутые записи кеша.
+//
+// Ошибки не кешируются. Результаты хранятся не дольше срока жизни ссылки, если хранилище
+// сообщает его через ExpiryGetter; иначе ссылка может отдаваться из кеша до TTL кеша после
+// истечения срока. Результат GetIDByURL кешируется вместе с записью по ID найденной ссылки
+// и действителен, только пока она есть в кеше: удаление ссылки известно лишь по её ID.
+type CachedStorage struct {
+	Storage
+	cache  *cache.LRU[cacheKey, string]
+	group  *singleflight.Group
+	hits   *atomic.Uint64
+	misses *atomic.Uint64
+}
+
+// NewCachedStorage оборачивает хранилище кешем с настройками options.
+func NewCachedStorage(backend Storage, options cache.Options) *CachedStorage {
+	return &CachedStorage{
+		Storage: backend,
+		cache:   cache.NewLRU[cacheKey, string](options.Size, options.TTL),
+		group:   &singleflight.Group{},
+		hits:    &atomic.Uint64{},
+		misses:  &atomic.Uint64{},
+	}
+}
+
+func (s *CachedStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, s.Storage)
+}
+
+// Stats возвращает число попаданий и промахов с момента создания и текущий размер кеша.
+func (s *CachedStorage) Stats() CacheStats {
+	return CacheStats{Hits: s.hits.Load(), Misses: s.misses.Load(), Size: s.cache.Len()}
+}
+
+func (s *CachedStorage) Get(ctx context.Context, id string) (string, error) {
+	return s.load(ctx, cacheKey{kind: cacheByID, key: id}, s.getWithExpiry)
+}
+
+func (s *CachedStorage) GetIDByURL(ctx context.Context, originalURL string) (string, error) {
+	return s.load(ctx, cacheKey{kind: cacheByURL, key: originalURL}, s.getIDWithExpiry)
+}
+
+// getIDWithExpiry находит ID живой ссылки на URL и срок её жизни. Ссылка, удалённая
+// или просроченная между двумя запросами к хранилищу, считается ненайденной.
+func (s *CachedStorage) getIDWithExpiry(ctx context.Context, originalURL string) (string, time.Time, error) {
+	id, err := s.Storage.GetIDByURL(ctx, originalURL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	linkedURL, expiresAt, err := s.getWithExpiry(ctx, id)
+	switch {
+	case errors.Is(err, errs.ErrNotFound), errors.Is(err, errs.ErrDeleted), errors.Is(err, errs.ErrExpired),
+		err == nil && linkedURL != originalURL:
+		return "", time.Time{}, fmt.Errorf("URL %s: %w", originalURL, errs.ErrNotFound)
+	case err != nil:
+		return "", time.Time{}, err
+	}
+	return id, expiresAt, nil
+}
+
+// cached возвращает значение из кеша. Запись по URL действительна, только если в кеше есть
+// запись по ID её ссылки с тем же URL: через неё удаление ссылки сбрасывает и запись по URL.
+func (s *CachedStorage) cached(key cacheKey) (string, bool) {
+	value, ok := s.cache.Get(key)
+	if !ok || key.kind != cacheByURL {
+		return value, ok
+	}
+	if originalURL, ok := s.cache.Get(cacheKey{kind: cacheByID, key: value}); ok && originalURL == key.key {
+		return value, true
+	}
+	return "", false
+}
+
+// store сохраняет загруженное значение, если с начала загрузки кеш не сбрасывался. Вместе
+// с записью по URL сохраняется запись по ID найденной ссылки, позже и потому вытесняемая позже.
+func (s *CachedStorage) store(key cacheKey, value string, generation uint64, expiresAt time.Time) {
+	s.cache.AddIfGeneration(key, value, generation, expiresAt)
+	if key.kind == cacheByURL {
+		s.cache.AddIfGeneration(cacheKey{kind: cacheByID, key: value}, key.key, generation, expiresAt)
+	}
+}
+
+// getWithExpiry читает ссылку вместе со сроком её жизни, если хранилище его сообщает.
+func (s *CachedStorage) getWithExpiry(ctx context.Context, id string) (string, time.Time, error) {
+	if getter, ok := s.Storage.(ExpiryGetter); ok {
+		return getter.GetWithExpiry(ctx, id)
+	}
+	originalURL, err := s.Storage.Get(ctx, id)
+	return originalURL, time.Time{}, err
+}
+
+// load возвращает значение из кеша или загружает его из хранилища одним вызовом на все
+// одновременные промахи. Загрузка не прерывается отменой контекста первого из ожидающих,
+// но каждый вызывающий перестаёт ждать при отмене своего контекста. Значение, прочитанное
+// до сброса кеша, не сохраняется, чтобы не вернуть в кеш удалённую ссылку. Ненулевой срок,
+// возвращённый fetch, ограничивает жизнь записи кеша.
+func (s *CachedStorage) load(
+	ctx context.Context, key cacheKey, fetch func(context.Context, string) (string, time.Time, error),
+) (string, error) {
+	if value, ok := s.cached(key); ok {
+		s.hits.Add(1)
+		return value, nil
+	}
+	s.misses.Add(1)
+
+	flightKey := fmt.Sprintf("%d:%s", key.kind, key.key)
+	results := s.group.DoChan(flightKey, func() (any, error) {
+		generation := s.cache.Generation()
+		value, expiresAt, err := fetch(context.WithoutCancel(ctx), key.key)
+		if err != nil {
+			return "", err
+		}
+		s.store(key, value, generation, expiresAt)
+		return value, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", fmt.Errorf("cache load canceled: %w", ctx.Err())
+	case result := <-results:
+		if result.Err != nil {
+			return "", result.Err
+		}
+		value, _ := result.Val.(string)
+		return value, nil
+	}
+}
+
+// forget сбрасывает записи кеша, связанные со ссылкой.
+func (s *CachedStorage) forget(record models.URLRecord) {
+	s.cache.Remove(cacheKey{kind: cacheByID, key: record.ShortID}, cacheKey{kind: cacheByURL, key: record.OriginalURL})
+}
+
+func (s *CachedStorage) SaveID(ctx context.Context, record models.URLRecord) error {
+	defer s.forget(record)
+	return s.Storage.SaveID(ctx, record)
+}
+
+func (s *CachedStorage) GetOrCreate(ctx context.Context, record models.URLRecord) (string, bool, error) {
+	defer s.forget(record)
+	return s.Storage.GetOrCreate(ctx, record)
+}
+
+func (s *CachedStorage) SaveBatch(ctx context.Context, records []models.URLRecord) ([]models.BatchResult, error) {
+	defer func() {
+		for _, record := range records {
+			s.forget(record)
+		}
+	}()
+	return s.Storage.SaveBatch(ctx, records)
+}
+
+// forgetIDs сбрасывает записи кеша, связанные со ссылками по их ID. URL ссылки известен
+// только по записи кеша по ID; без неё запись по URL и так недействительна, см. cached.
+func (s *CachedStorage) forgetIDs(ids []string) {
+	keys := make([]cacheKey, 0, 2*len(ids))
+	for _, id := range ids {
+		key := cacheKey{kind: cacheByID, key: id}
+		if originalURL, ok := s.cache.Get(key); ok {
+			keys = append(keys, cacheKey{kind: cacheByURL, key: originalURL})
+		}
+		keys = append(keys, key)
+	}
+	s.cache.Remove(keys...)
+}
+
+// DeleteURLs помечает ссылки удалёнными и сбрасывает их из кеша.
+func (s *CachedStorage) DeleteURLs(ctx context.Context, requests []models.DeleteRequest) error {
+	defer func() {
+		ids := make([]string, len(requests))
+		for i, request := range requests {
+			ids[i] = request.ShortID
+		}
+		s.forgetIDs(ids)
+	}()
+	return s.Storage.DeleteURLs(ctx, requests)
+}
+
+// DeleteExpired удаляет просроченные ссылки. Какие именно ссылки удалены, неизвестно,
+// поэтому при любом удалении кеш сбрасывается целиком.
+func (s *CachedStorage) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	deleted, err := s.Storage.DeleteExpired(ctx, now)
+	if deleted > 0 {
+		s.cache.Purge()
+	}
+	return deleted, err
+}
+
+// Invalidate сбрасывает записи кеша, затронутые изменением хранилища, в том числе сделанным
+// другим экземпляром сервиса. При сбросе изменения кеш очищается целиком.
+func (s *CachedStorage) Invalidate(change models.URLChange) {
+	if change.Reset {
+		s.cache.Purge()
+		return
+	}
+	s.forgetIDs(change.ShortIDs)
+}
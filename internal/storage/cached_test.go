@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/cache"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/BrownBear56/contractor/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage считает вызовы Get и задерживает их до закрытия release.
+type countingStorage struct {
+	Storage
+	release chan struct{}
+	gets    atomic.Int64
+}
+
+func (s *countingStorage) Get(ctx context.Context, id string) (string, error) {
+	s.gets.Add(1)
+	<-s.release
+	return s.Storage.Get(ctx, id)
+}
+
+func TestCachedStorageCollapsesMisses(t *testing.T) {
+	const readers = 16
+	ctx := context.Background()
+	backend := &countingStorage{Storage: memory.NewMemoryStore(), release: make(chan struct{})}
+	require.NoError(t, backend.SaveID(ctx, models.URLRecord{ShortID: "id", OriginalURL: "https://example.com"}))
+	store := NewCachedStorage(backend, cache.Options{Size: 10, TTL: time.Minute})
+
+	var wg sync.WaitGroup
+	for range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			originalURL, err := store.Get(ctx, "id")
+			assert.NoError(t, err)
+			assert.Equal(t, "https://example.com", originalURL)
+		}()
+	}
+	// Даём читателям дойти до ожидания общего вызова.
+	require.Eventually(t, func() bool {
+		return store.Stats().Misses == readers
+	}, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+
+	_, err := store.Get(ctx, "id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), backend.gets.Load())
+	assert.Equal(t, CacheStats{Hits: 1, Misses: readers, Size: 1}, store.Stats())
+}
+
+func TestCachedStorageInvalidation(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStorage{Storage: memory.NewMemoryStore(), release: make(chan struct{})}
+	close(backend.release)
+	store := NewCachedStorage(backend, cache.Options{Size: 10, TTL: time.Minute})
+
+	record := models.URLRecord{
+		ShortID: "id", OriginalURL: "https://example.com", UserID: "user", ExpiresAt: time.Now().Add(time.Hour),
+	}
+	require.NoError(t, store.SaveID(ctx, record))
+	_, err := store.Get(ctx, "id")
+	require.NoError(t, err)
+	id, err := store.GetIDByURL(ctx, record.OriginalURL)
+	require.NoError(t, err)
+	assert.Equal(t, "id", id)
+
+	// Удаление сбрасывает запись, и следующий Get видит её удалённой.
+	require.NoError(t, store.DeleteURLs(ctx, []models.DeleteRequest{{UserID: "user", ShortID: "id"}}))
+	_, err = store.Get(ctx, "id")
+	require.ErrorIs(t, err, errs.ErrDeleted)
+	// GetIDByURL читает ссылку ещё раз, чтобы узнать её срок жизни.
+	assert.Equal(t, int64(3), backend.gets.Load())
+	_, err = store.GetIDByURL(ctx, record.OriginalURL)
+	require.ErrorIs(t, err, errs.ErrNotFound)
+
+	// Удаление просроченных ссылок сбрасывает кеш целиком.
+	require.NoError(t, store.SaveID(ctx, models.URLRecord{ShortID: "other", OriginalURL: "https://example.com/other"}))
+	_, err = store.Get(ctx, "other")
+	require.NoError(t, err)
+	deleted, err := store.DeleteExpired(ctx, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, 0, store.Stats().Size)
+}
+
+func TestCachedStorageInvalidate(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.NewMemoryStore()
+	store := NewCachedStorage(backend, cache.Options{Size: 10})
+
+	for _, id := range []string{"a", "b"} {
+		record := models.URLRecord{ShortID: id, OriginalURL: "https://example.com/" + id, UserID: "user"}
+		require.NoError(t, store.SaveID(ctx, record))
+		_, err := store.Get(ctx, id)
+		require.NoError(t, err)
+		_, err = store.GetIDByURL(ctx, record.OriginalURL)
+		require.NoError(t, err)
+	}
+
+	// Удаление в обход декоратора, как с другого экземпляра, видно только после оповещения.
+	require.NoError(t, backend.DeleteURLs(ctx, []models.DeleteRequest{{UserID: "user", ShortID: "a"}}))
+	_, err := store.Get(ctx, "a")
+	require.NoError(t, err)
+
+	store.Invalidate(models.URLChange{ShortIDs: []string{"a"}})
+	_, err = store.Get(ctx, "a")
+	require.ErrorIs(t, err, errs.ErrDeleted)
+	assert.Equal(t, 2, store.Stats().Size)
+
+	store.Invalidate(models.URLChange{Reset: true})
+	assert.Equal(t, 0, store.Stats().Size)
+}
+
+func TestCachedStorageExpiry(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.NewMemoryStore()
+	require.NoError(t, backend.SaveID(ctx, models.URLRecord{
+		ShortID: "id", OriginalURL: "https://example.com", ExpiresAt: time.Now().Add(50 * time.Millisecond),
+	}))
+	store := NewCachedStorage(backend, cache.Options{Size: 10, TTL: time.Minute})
+
+	_, err := store.Get(ctx, "id")
+	require.NoError(t, err)
+	// Запись кеша живёт не дольше ссылки, и истёкшая ссылка отвечает ErrExpired.
+	assert.Eventually(t, func() bool {
+		_, err := store.Get(ctx, "id")
+		return errors.Is(err, errs.ErrExpired)
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestCachedStorageURLEntry проверяет, что запись по URL не переживает удаление ссылки,
+// даже если запись по её ID уже вытеснена, и живёт не дольше самой ссылки.
+func TestCachedStorageURLEntry(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.NewMemoryStore()
+	store := NewCachedStorage(backend, cache.Options{Size: 10, TTL: time.Minute})
+
+	for _, id := range []string{"a", "b"} {
+		record := models.URLRecord{ShortID: id, OriginalURL: "https://example.com/" + id, UserID: "user"}
+		require.NoError(t, store.SaveID(ctx, record))
+		_, err := store.GetIDByURL(ctx, record.OriginalURL)
+		require.NoError(t, err)
+		// Вытеснение оставляет в кеше только запись по URL.
+		store.cache.Remove(cacheKey{kind: cacheByID, key: id})
+	}
+
+	// Удаление через декоратор.
+	require.NoError(t, store.DeleteURLs(ctx, []models.DeleteRequest{{UserID: "user", ShortID: "a"}}))
+	_, err := store.GetIDByURL(ctx, "https://example.com/a")
+	require.ErrorIs(t, err, errs.ErrNotFound)
+
+	// Удаление в обход декоратора с оповещением, как с другого экземпляра.
+	require.NoError(t, backend.DeleteURLs(ctx, []models.DeleteRequest{{UserID: "user", ShortID: "b"}}))
+	store.Invalidate(models.URLChange{ShortIDs: []string{"b"}})
+	_, err = store.GetIDByURL(ctx, "https://example.com/b")
+	require.ErrorIs(t, err, errs.ErrNotFound)
+
+	require.NoError(t, backend.SaveID(ctx, models.URLRecord{
+		ShortID: "c", OriginalURL: "https://example.com/c", ExpiresAt: time.Now().Add(50 * time.Millisecond),
+	}))
+	id, err := store.GetIDByURL(ctx, "https://example.com/c")
+	require.NoError(t, err)
+	assert.Equal(t, "c", id)
+	assert.Eventually(t, func() bool {
+		_, err := store.GetIDByURL(ctx, "https://example.com/c")
+		return errors.Is(err, errs.ErrNotFound)
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,24 @@
+// Package errs содержит ошибки, общие для всех реализаций хранилища.
+// Вынесены в отдельный пакет, чтобы их могли импортировать и бэкенды, и пакет storage.
+package errs
+
+import "errors"
+
+var (
+	// ErrNotFound — запись с указанным ключом отсутствует.
+	ErrNotFound = errors.New("not found")
+	// ErrDeleted — ссылка удалена владельцем.
+	ErrDeleted = errors.New("deleted")
+	// ErrExpired — истёк срок жизни ссылки.
+	ErrExpired = errors.New("expired")
+	// ErrIDConflict — короткий ID уже занят другой ссылкой.
+	ErrIDConflict = errors.New("short ID already exists")
+	// ErrURLConflict — для оригинального URL уже существует короткая ссылка.
+	ErrURLConflict = errors.New("URL already shortened")
+	// ErrURLTooLong — оригинальный URL длиннее допустимого.
+	ErrURLTooLong = errors.New("URL is too long")
+	// ErrValueTooLong — короткий ID или ID пользователя длиннее, чем допускает хранилище.
+	ErrValueTooLong = errors.New("value is too long")
+	// ErrUnavailable — бэкенд хранилища временно недоступен.
+	ErrUnavailable = errors.New("storage unavailable")
+)
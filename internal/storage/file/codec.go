@@ -0,0 +1,356 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Format — формат файла хранилища.
+type Format string
+
+const (
+	// FormatJSON — строки JSON, по одной записи на строку.
+	FormatJSON Format = "json"
+	// FormatBinary — заголовок с версией и записи с префиксом длины и контрольной суммой CRC32.
+	FormatBinary Format = "binary"
+)
+
+// ParseFormat разбирает название формата файла хранилища.
+func ParseFormat(value string) (Format, error) {
+	switch format := Format(value); format {
+	case FormatJSON, FormatBinary:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown storage file format %q, expected json or binary", value)
+	}
+}
+
+var (
+	// errCorruptRecord — запись повреждена, но её границы известны и чтение можно продолжить.
+	errCorruptRecord = errors.New("corrupted record")
+	// errUnreadable — границы записи потеряны, и дальнейшее содержимое файла прочитать нельзя.
+	errUnreadable = errors.New("unreadable record")
+)
+
+// position — расположение записи в файле.
+// Номер — номер строки для JSON и порядковый номер записи для двоичного формата.
+type position struct {
+	offset int64
+	size   int64
+	number int
+}
+
+// codec кодирует записи журнала в одном из форматов.
+type codec interface {
+	// header возвращает заголовок, с которого начинается непустой файл.
+	header() []byte
+	// appendRecord дописывает закодированную запись к buf.
+	appendRecord(buf []byte, record fileRecord) ([]byte, error)
+	// newDecoder создаёт декодер записей, начинающихся в файле со смещения offset.
+	newDecoder(r *bufio.Reader, offset int64) recordDecoder
+}
+
+// recordDecoder последовательно читает записи журнала.
+type recordDecoder interface {
+	// next возвращает следующую запись и её расположение, io.EOF в конце файла,
+	// ошибку с errCorruptRecord для повреждённой записи и с errUnreadable, если продолжить чтение нельзя.
+	next() (fileRecord, position, error)
+}
+
+func newCodec(format Format) codec {
+	if format == FormatBinary {
+		return binaryCodec{}
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) header() []byte {
+	return nil
+}
+
+func (jsonCodec) appendRecord(buf []byte, record fileRecord) ([]byte, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return buf, fmt.Errorf("failed to encode data: %w", err)
+	}
+	buf = append(buf, data...)
+	return append(buf, '\n'), nil
+}
+
+func (jsonCodec) newDecoder(r *bufio.Reader, offset int64) recordDecoder {
+	return &jsonDecoder{r: r, offset: offset}
+}
+
+type jsonDecoder struct {
+	r      *bufio.Reader
+	offset int64
+	line   int
+}
+
+func (d *jsonDecoder) next() (fileRecord, position, error) {
+	for {
+		line, err := d.r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fileRecord{}, position{}, fmt.Errorf("error reading file: %w", err)
+		}
+		if len(line) == 0 {
+			return fileRecord{}, position{}, io.EOF
+		}
+
+		d.line++
+		pos := position{offset: d.offset, size: int64(len(line)), number: d.line}
+		d.offset += pos.size
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var record fileRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return record, pos, fmt.Errorf("%w: error decoding JSON: %w", errCorruptRecord, err)
+		}
+		if record.ShortURL == "" {
+			return record, pos, fmt.Errorf("%w: record has no short URL", errCorruptRecord)
+		}
+		return record, pos, nil
+	}
+}
+
+const (
+	binaryMagic   = "SURL"
+	binaryVersion = 1
+	// binaryHeaderSize — магическая строка и версия формата.
+	binaryHeaderSize = 8
+	// recordHeaderSize — длина и контрольная сумма записи.
+	recordHeaderSize = 8
+	// maxRecordSize ограничивает длину записи: большее значение означает повреждённый префикс.
+	maxRecordSize = 1 << 20
+)
+
+const (
+	flagDeleted byte = 1 << iota
+	flagExpiresAt
+	flagLastAccessedAt
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// binaryCodec кодирует запись как uint32 длины, uint32 CRC32-C полезной нагрузки и саму нагрузку:
+// байт флагов, строки с префиксом длины, счётчик переходов и необязательные метки времени.
+type binaryCodec struct{}
+
+func (binaryCodec) header() []byte {
+	header := make([]byte, 0, binaryHeaderSize)
+	header = append(header, binaryMagic...)
+	return binary.LittleEndian.AppendUint32(header, binaryVersion)
+}
+
+func (binaryCodec) appendRecord(buf []byte, record fileRecord) ([]byte, error) {
+	start := len(buf)
+	buf = append(buf, make([]byte, recordHeaderSize)...)
+
+	var flags byte
+	if record.IsDeleted {
+		flags |= flagDeleted
+	}
+	if record.ExpiresAt != nil {
+		flags |= flagExpiresAt
+	}
+	if record.LastAccessedAt != nil {
+		flags |= flagLastAccessedAt
+	}
+	buf = append(buf, flags)
+	buf = appendString(buf, record.ShortURL)
+	buf = appendString(buf, record.OriginalURL)
+	buf = appendString(buf, record.UserID)
+	buf = binary.AppendVarint(buf, record.Clicks)
+	if record.ExpiresAt != nil {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(record.ExpiresAt.UnixNano()))
+	}
+	if record.LastAccessedAt != nil {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(record.LastAccessedAt.UnixNano()))
+	}
+
+	payload := buf[start+recordHeaderSize:]
+	if len(payload) > maxRecordSize {
+		return buf[:start], fmt.Errorf("record %s is too large: %d bytes", record.ShortURL, len(payload))
+	}
+	binary.LittleEndian.PutUint32(buf[start:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[start+4:], crc32.Checksum(payload, crcTable))
+	return buf, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func (binaryCodec) newDecoder(r *bufio.Reader, offset int64) recordDecoder {
+	return &binaryDecoder{r: r, offset: offset}
+}
+
+type binaryDecoder struct {
+	r      *bufio.Reader
+	offset int64
+	number int
+}
+
+func (d *binaryDecoder) next() (fileRecord, position, error) {
+	var header [recordHeaderSize]byte
+	n, err := io.ReadFull(d.r, header[:])
+	if n == 0 && errors.Is(err, io.EOF) {
+		return fileRecord{}, position{}, io.EOF
+	}
+
+	d.number++
+	pos := position{offset: d.offset, size: int64(n), number: d.number}
+	d.offset += int64(n)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return fileRecord{}, pos, fmt.Errorf("%w: incomplete record header", errCorruptRecord)
+	} else if err != nil {
+		return fileRecord{}, pos, fmt.Errorf("error reading file: %w", err)
+	}
+
+	length := binary.LittleEndian.Uint32(header[:4])
+	if length > maxRecordSize {
+		return fileRecord{}, pos, fmt.Errorf("%w: record length %d exceeds limit", errUnreadable, length)
+	}
+
+	payload := make([]byte, length)
+	n, err = io.ReadFull(d.r, payload)
+	pos.size += int64(n)
+	d.offset += int64(n)
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return fileRecord{}, pos, fmt.Errorf("%w: incomplete record payload", errCorruptRecord)
+	} else if err != nil {
+		return fileRecord{}, pos, fmt.Errorf("error reading file: %w", err)
+	}
+
+	if sum := binary.LittleEndian.Uint32(header[4:]); crc32.Checksum(payload, crcTable) != sum {
+		return fileRecord{}, pos, fmt.Errorf("%w: checksum mismatch", errCorruptRecord)
+	}
+	record, err := decodeBinaryPayload(payload)
+	if err != nil {
+		return record, pos, fmt.Errorf("%w: %w", errCorruptRecord, err)
+	}
+	return record, pos, nil
+}
+
+// payloadReader читает поля полезной нагрузки и запоминает первую ошибку.
+type payloadReader struct {
+	err  error
+	data []byte
+}
+
+func (r *payloadReader) readByte() byte {
+	if r.err != nil || len(r.data) == 0 {
+		r.fail()
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *payloadReader) readString() string {
+	length, n := binary.Uvarint(r.data)
+	if r.err != nil || n <= 0 || uint64(len(r.data)-n) < length {
+		r.fail()
+		return ""
+	}
+	s := string(r.data[n : n+int(length)])
+	r.data = r.data[n+int(length):]
+	return s
+}
+
+func (r *payloadReader) readVarint() int64 {
+	value, n := binary.Varint(r.data)
+	if r.err != nil || n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *payloadReader) readTime() *time.Time {
+	const timeSize = 8
+	if r.err != nil || len(r.data) < timeSize {
+		r.fail()
+		return nil
+	}
+	t := time.Unix(0, int64(binary.LittleEndian.Uint64(r.data))).UTC()
+	r.data = r.data[timeSize:]
+	return &t
+}
+
+func (r *payloadReader) fail() {
+	if r.err == nil {
+		r.err = errors.New("truncated record payload")
+	}
+}
+
+func decodeBinaryPayload(payload []byte) (fileRecord, error) {
+	r := &payloadReader{data: payload}
+	flags := r.readByte()
+	record := fileRecord{
+		ShortURL:    r.readString(),
+		OriginalURL: r.readString(),
+		UserID:      r.readString(),
+		Clicks:      r.readVarint(),
+		IsDeleted:   flags&flagDeleted != 0,
+	}
+	if flags&flagExpiresAt != 0 {
+		record.ExpiresAt = r.readTime()
+	}
+	if flags&flagLastAccessedAt != 0 {
+		record.LastAccessedAt = r.readTime()
+	}
+
+	if r.err != nil {
+		return record, r.err
+	}
+	if len(r.data) != 0 {
+		return record, fmt.Errorf("unexpected %d trailing bytes in record payload", len(r.data))
+	}
+	if record.ShortURL == "" {
+		return record, errors.New("record has no short URL")
+	}
+	return record, nil
+}
+
+// detectFormat определяет формат файла по первым байтам и пропускает заголовок двоичного формата.
+// Для пустого файла возвращает пустой формат. tornHeader сообщает, что файл содержит
+// только начало заголовка, оборванное при сбое.
+func detectFormat(r *bufio.Reader) (format Format, tornHeader bool, err error) {
+	head, err := r.Peek(binaryHeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", false, fmt.Errorf("error reading file: %w", err)
+	}
+	if len(head) == 0 {
+		return "", false, nil
+	}
+
+	if len(head) < binaryHeaderSize && bytes.HasPrefix(binaryCodec{}.header(), head) {
+		return FormatBinary, true, nil
+	}
+	if !bytes.HasPrefix(head, []byte(binaryMagic)) {
+		return FormatJSON, false, nil
+	}
+
+	if version := binary.LittleEndian.Uint32(head[len(binaryMagic):]); version != binaryVersion {
+		return "", false, fmt.Errorf("unsupported storage file version %d", version)
+	}
+	if _, err := r.Discard(binaryHeaderSize); err != nil {
+		return "", false, fmt.Errorf("error reading file: %w", err)
+	}
+	return FormatBinary, false, nil
+}
@@ -0,0 +1,158 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 6, time.UTC)
+	lastAccessedAt := time.Date(2024, 6, 7, 8, 9, 10, 11, time.UTC)
+	records := []fileRecord{
+		{ShortURL: "id1", OriginalURL: "https://example.com/1", UserID: "user"},
+		{ShortURL: "id2", OriginalURL: "https://example.com/2", ExpiresAt: &expiresAt},
+		{ShortURL: "id1", UserID: "user", IsDeleted: true},
+		{ShortURL: "id2", Clicks: 3, LastAccessedAt: &lastAccessedAt},
+	}
+
+	for _, format := range []Format{FormatJSON, FormatBinary} {
+		t.Run(string(format), func(t *testing.T) {
+			c := newCodec(format)
+			buf := c.header()
+			var err error
+			for _, record := range records {
+				buf, err = c.appendRecord(buf, record)
+				require.NoError(t, err)
+			}
+
+			reader := bufio.NewReader(bytes.NewReader(buf))
+			detected, torn, err := detectFormat(reader)
+			require.NoError(t, err)
+			assert.False(t, torn)
+			assert.Equal(t, format, detected)
+
+			decoder := c.newDecoder(reader, int64(len(c.header())))
+			var decoded []fileRecord
+			for {
+				record, _, err := decoder.next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				require.NoError(t, err)
+				decoded = append(decoded, record)
+			}
+
+			require.Len(t, decoded, len(records))
+			for i, record := range records {
+				assert.Equal(t, record.ShortURL, decoded[i].ShortURL)
+				assert.Equal(t, record.OriginalURL, decoded[i].OriginalURL)
+				assert.Equal(t, record.UserID, decoded[i].UserID)
+				assert.Equal(t, record.Clicks, decoded[i].Clicks)
+				assert.Equal(t, record.IsDeleted, decoded[i].IsDeleted)
+				assert.True(t, timeValue(record.ExpiresAt).Equal(timeValue(decoded[i].ExpiresAt)))
+				assert.True(t, timeValue(record.LastAccessedAt).Equal(timeValue(decoded[i].LastAccessedAt)))
+			}
+		})
+	}
+}
+
+func TestBinaryLoadCorruptedFile(t *testing.T) {
+	c := binaryCodec{}
+	encode := func(record fileRecord) []byte {
+		buf, err := c.appendRecord(nil, record)
+		require.NoError(t, err)
+		return buf
+	}
+	first := encode(fileRecord{ShortURL: "id1", OriginalURL: "https://example.com/1"})
+	second := encode(fileRecord{ShortURL: "id2", OriginalURL: "https://example.com/2"})
+	third := encode(fileRecord{ShortURL: "id3", OriginalURL: "https://example.com/3"})
+
+	flipped := bytes.Clone(second)
+	flipped[len(flipped)-1] ^= 0xff
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(append([][]byte{c.header()}, parts...), nil)
+	}
+
+	tests := []struct {
+		name         string
+		content      []byte
+		expectedFile []byte
+		corruptLines []int
+	}{
+		{
+			name:         "Torn trailing record is truncated",
+			content:      join(first, second, third[:len(third)-3]),
+			expectedFile: join(first, second),
+		},
+		{
+			name:         "Checksum mismatch in the middle refuses to start",
+			content:      join(first, flipped, third),
+			corruptLines: []int{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "storage.bin")
+			require.NoError(t, os.WriteFile(filePath, tt.content, 0o600))
+
+			_, err := NewFileStore(filePath, Options{Format: FormatBinary}, newTestLogger(t))
+			if tt.corruptLines != nil {
+				var corruptionErr *CorruptionError
+				require.ErrorAs(t, err, &corruptionErr)
+				assert.Equal(t, tt.corruptLines, corruptionErr.Lines)
+				return
+			}
+			require.NoError(t, err)
+
+			content, err := os.ReadFile(filePath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedFile, content)
+		})
+	}
+}
+
+func TestConvertFormat(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "storage.json")
+	content := `{"short_url":"id1","original_url":"https://example.com/1","user_id":"user"}` + "\n" +
+		`{"short_url":"id1","clicks":2}` + "\n"
+	require.NoError(t, os.WriteFile(filePath, []byte(content), 0o600))
+
+	store := newTestStore(t, filePath, Options{Format: FormatBinary})
+	require.NoError(t, store.SaveID(ctx, models.URLRecord{ShortID: "id2", OriginalURL: "https://example.com/2"}))
+	require.NoError(t, store.Close(ctx))
+
+	converted, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(converted, binaryCodec{}.header()))
+
+	// Повторный запуск читает двоичный файл без преобразования, а обратное преобразование возвращает JSON.
+	for _, format := range []Format{FormatBinary, FormatJSON} {
+		restarted := newTestStore(t, filePath, Options{Format: format})
+		for _, id := range []string{"id1", "id2"} {
+			_, err := restarted.Get(ctx, id)
+			require.NoError(t, err)
+		}
+		stat, err := restarted.GetStats(ctx, "id1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), stat.Clicks)
+		require.NoError(t, restarted.Close(ctx))
+	}
+
+	converted, err = os.ReadFile(filePath)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(converted, []byte("{")))
+}
@@ -1,160 +1,668 @@
 package file
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
 	"github.com/BrownBear56/contractor/internal/storage/memory"
 	"go.uber.org/zap"
 )
 
+// fileRecord — строка файла хранилища в формате JSON.
+// Удаление записывается отдельной строкой-надгробием с IsDeleted и без оригинального URL,
+// приращение счётчика переходов — строкой с Clicks и без оригинального URL.
+type fileRecord struct {
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+	ShortURL       string     `json:"short_url"`
+	OriginalURL    string     `json:"original_url,omitempty"`
+	UserID         string     `json:"user_id,omitempty"`
+	Clicks         int64      `json:"clicks,omitempty"`
+	IsDeleted      bool       `json:"is_deleted,omitempty"`
+}
+
+func newFileRecord(record models.URLRecord) fileRecord {
+	return fileRecord{
+		ShortURL:       record.ShortID,
+		OriginalURL:    record.OriginalURL,
+		UserID:         record.UserID,
+		IsDeleted:      record.IsDeleted,
+		Clicks:         record.Clicks,
+		ExpiresAt:      timePtr(record.ExpiresAt),
+		LastAccessedAt: timePtr(record.LastAccessedAt),
+	}
+}
+
+func newClickRecord(click models.ClickStat) fileRecord {
+	return fileRecord{
+		ShortURL:       click.ShortID,
+		Clicks:         click.Clicks,
+		LastAccessedAt: timePtr(click.LastAccessedAt),
+	}
+}
+
+func (r fileRecord) toURLRecord() models.URLRecord {
+	return models.URLRecord{
+		ShortID:        r.ShortURL,
+		OriginalURL:    r.OriginalURL,
+		UserID:         r.UserID,
+		IsDeleted:      r.IsDeleted,
+		Clicks:         r.Clicks,
+		ExpiresAt:      timeValue(r.ExpiresAt),
+		LastAccessedAt: timeValue(r.LastAccessedAt),
+	}
+}
+
+// isClickDelta сообщает, что строка хранит только приращение счётчика переходов.
+func (r fileRecord) isClickDelta() bool {
+	return r.OriginalURL == "" && !r.IsDeleted && r.Clicks > 0
+}
+
+// timePtr превращает нулевое время в nil, чтобы не записывать его в файл.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func timeValue(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
 type FileStore struct {
 	mu          *sync.Mutex
-	memoryStore memory.MemoryStore
+	memoryStore *memory.MemoryStore
 	logger      logger.Logger
-	filePath    string
+	// file — журнал, открытый на дозапись. Открывается при первой записи и закрывается после сжатия.
+	file      *os.File
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce *sync.Once
+	codec     codec
+	filePath  string
+	options   Options
+	// size — размер журнала, до которого он обрезается, если запись не удалась.
+	size int64
+	// logRecords — число строк в журнале. Строки сверх числа записей в памяти устарели.
+	logRecords int
+	// dirty — в журнале есть строки, ещё не сброшенные на диск.
+	dirty bool
 }
 
-func NewFileStore(filePath string, parentLogger logger.Logger) *FileStore {
+// NewFileStore загружает журнал и запускает фоновые сжатие и сброс на диск.
+// Оборванная последняя строка отбрасывается, а повреждение в середине журнала
+// без Options.Repair возвращает *CorruptionError.
+func NewFileStore(filePath string, options Options, parentLogger logger.Logger) (*FileStore, error) {
 	fs := &FileStore{
 		mu:          &sync.Mutex{},
-		memoryStore: *memory.NewMemoryStore(),
+		memoryStore: memory.NewMemoryStore(),
 		filePath:    filePath,
 		logger:      parentLogger,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		closeOnce:   &sync.Once{},
+		options:     options,
+		codec:       newCodec(options.format()),
 	}
-	_ = fs.loadFromFile()
-	return fs
+	if err := fs.loadFromFile(); err != nil {
+		return nil, err
+	}
+
+	// Сжимаем журнал сразу, чтобы следующий запуск не перечитывал накопленный мусор.
+	if err := fs.maybeCompact(); err != nil {
+		fs.logger.Error("Failed to compact storage file", zap.Error(err))
+	}
+
+	if options.Compaction.Interval > 0 || (options.SyncPolicy == SyncInterval && options.SyncInterval > 0) {
+		go fs.run()
+	} else {
+		close(fs.done)
+	}
+	return fs, nil
 }
 
-func (fs *FileStore) SaveID(id, originalURL string) error {
-	if err := fs.memoryStore.SaveID(id, originalURL); err != nil {
+// SaveID сохраняет запись в памяти и в журнале. Если запись в журнал не удалась, запись удаляется
+// из памяти; оба шага выполняются под fs.mu, чтобы параллельное сохранение того же URL не успело
+// получить ID удаляемой записи.
+func (fs *FileStore) SaveID(ctx context.Context, record models.URLRecord) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.memoryStore.SaveID(ctx, record); err != nil {
 		return fmt.Errorf("failed to save ID in memory store: %w", err)
 	}
-	if err := fs.appendToFile(id, originalURL); err != nil {
-		return fmt.Errorf("failed to save data to file: %w", err)
+	if err := fs.appendToFile(record); err != nil {
+		return fmt.Errorf("%w: failed to save data to file: %w", errs.ErrUnavailable, err)
 	}
 
 	return nil
 }
 
-func (fs *FileStore) Get(id string) (string, bool) {
-	return fs.memoryStore.Get(id)
+// GetOrCreate сохраняет запись так же, как SaveID, или возвращает ID, под которым URL уже сохранён.
+func (fs *FileStore) GetOrCreate(ctx context.Context, record models.URLRecord) (string, bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	actualID, existed, err := fs.memoryStore.GetOrCreate(ctx, record)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get or create ID in memory store: %w", err)
+	}
+	// В файл пишет только тот вызов, который действительно создал запись.
+	if existed {
+		return actualID, true, nil
+	}
+	if err := fs.appendToFile(record); err != nil {
+		return "", false, fmt.Errorf("%w: failed to save data to file: %w", errs.ErrUnavailable, err)
+	}
+
+	return actualID, false, nil
+}
+
+func (fs *FileStore) Get(ctx context.Context, id string) (string, error) {
+	originalURL, err := fs.memoryStore.Get(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to get URL from memory store: %w", err)
+	}
+	return originalURL, nil
+}
+
+// GetWithExpiry возвращает URL ссылки и момент истечения её срока, нулевой для бессрочной.
+func (fs *FileStore) GetWithExpiry(ctx context.Context, id string) (string, time.Time, error) {
+	originalURL, expiresAt, err := fs.memoryStore.GetWithExpiry(ctx, id)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to get URL from memory store: %w", err)
+	}
+	return originalURL, expiresAt, nil
 }
 
-func (fs *FileStore) GetIDByURL(originalURL string) (string, bool) {
+func (fs *FileStore) GetIDByURL(ctx context.Context, originalURL string) (string, error) {
 	// Извлекаем ID по оригинальному URL из памяти
-	return fs.memoryStore.GetIDByURL(originalURL)
+	id, err := fs.memoryStore.GetIDByURL(ctx, originalURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to get ID from memory store: %w", err)
+	}
+	return id, nil
 }
 
-func (fs *FileStore) SaveBatch(pairs map[string]string) error {
+// ForEachID вызывает fn для ID каждой хранимой записи, включая удалённые.
+func (fs *FileStore) ForEachID(ctx context.Context, fn func(id string) error) error {
+	return fs.memoryStore.ForEachID(ctx, fn)
+}
+
+// Iterate вызывает fn для каждой записи с ID больше afterID в порядке возрастания ID.
+func (fs *FileStore) Iterate(ctx context.Context, afterID string, fn func(models.URLRecord) error) error {
+	return fs.memoryStore.Iterate(ctx, afterID, fn)
+}
+
+func (fs *FileStore) GetUserURLs(ctx context.Context, userID string) ([]models.URLRecord, error) {
+	records, err := fs.memoryStore.GetUserURLs(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user URLs from memory store: %w", err)
+	}
+	return records, nil
+}
+
+// DeleteURLs помечает ссылки удалёнными и дописывает в файл надгробия для удалённых записей.
+// Если дописать надгробия не удалось, пометки снимаются, чтобы память не разошлась с файлом.
+func (fs *FileStore) DeleteURLs(ctx context.Context, requests []models.DeleteRequest) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("delete URLs canceled: %w", err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	applied := fs.memoryStore.MarkDeleted(requests)
+	tombstones := make([]models.URLRecord, 0, len(applied))
+	for _, request := range applied {
+		tombstones = append(tombstones, models.URLRecord{
+			ShortID:   request.ShortID,
+			UserID:    request.UserID,
+			IsDeleted: true,
+		})
+	}
+
+	if err := fs.appendBatchToFile(tombstones); err != nil {
+		fs.memoryStore.UnmarkDeleted(applied)
+		return fmt.Errorf("%w: failed to save tombstones to file: %w", errs.ErrUnavailable, err)
+	}
+	return nil
+}
+
+// RecordClicks прибавляет переходы к счётчикам и дописывает приращения в файл.
+// Если дописать приращения не удалось, счётчики возвращаются к прежним значениям.
+func (fs *FileStore) RecordClicks(ctx context.Context, clicks []models.ClickStat) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("record clicks canceled: %w", err)
+	}
+
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	for id, originalURL := range pairs {
-		if err := fs.memoryStore.SaveID(id, originalURL); err != nil {
-			return fmt.Errorf("failed to save ID in memory store: %w", err)
+	previous := make([]models.ClickStat, 0, len(clicks))
+	for _, click := range clicks {
+		stat, err := fs.memoryStore.GetStats(ctx, click.ShortID)
+		switch {
+		case errors.Is(err, errs.ErrNotFound):
+			continue
+		case err != nil:
+			return fmt.Errorf("failed to get stats from memory store: %w", err)
 		}
+		previous = append(previous, stat)
 	}
 
-	if err := fs.appendBatchToFile(pairs); err != nil {
-		return fmt.Errorf("failed to save batch to file: %w", err)
+	applied := fs.memoryStore.ApplyClicks(clicks)
+	deltas := make([]fileRecord, 0, len(applied))
+	for _, click := range applied {
+		deltas = append(deltas, newClickRecord(click))
 	}
 
+	if err := fs.appendRecordsToFile(deltas); err != nil {
+		fs.memoryStore.SetClicks(previous)
+		return fmt.Errorf("%w: failed to save clicks to file: %w", errs.ErrUnavailable, err)
+	}
 	return nil
 }
 
-func (fs *FileStore) appendToFile(id, originalURL string) error {
+func (fs *FileStore) GetStats(ctx context.Context, id string) (models.ClickStat, error) {
+	stat, err := fs.memoryStore.GetStats(ctx, id)
+	if err != nil {
+		return models.ClickStat{}, fmt.Errorf("failed to get stats from memory store: %w", err)
+	}
+	return stat, nil
+}
+
+// DeleteExpired удаляет просроченные ссылки из памяти и переписывает файл без их строк.
+func (fs *FileStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("delete expired canceled: %w", err)
+	}
+
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	// Открываем файл в режиме добавления, если его нет, создаем.
+	purged := fs.memoryStore.PurgeExpired(now)
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	// Удалённые из памяти записи не попадут в снимок, поэтому их строки исчезнут из журнала.
+	if _, err := fs.compact(); err != nil {
+		return 0, fmt.Errorf("%w: failed to compact file: %w", errs.ErrUnavailable, err)
+	}
+
+	return len(purged), nil
+}
+
+func (fs *FileStore) SaveBatch(ctx context.Context, records []models.URLRecord) ([]models.BatchResult, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	results, err := fs.memoryStore.SaveBatch(ctx, records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save batch in memory store: %w", err)
+	}
+
+	// В файл попадают только действительно созданные записи.
+	created := make([]models.URLRecord, 0, len(records))
+	for i, result := range results {
+		if result.Status == models.BatchCreated {
+			created = append(created, records[i])
+		}
+	}
+
+	if err := fs.appendBatchToFile(created); err != nil {
+		for _, record := range created {
+			fs.memoryStore.Remove(record)
+		}
+		return nil, fmt.Errorf("%w: failed to save batch to file: %w", errs.ErrUnavailable, err)
+	}
+
+	return results, nil
+}
+
+// appendToFile дописывает в файл запись, только что сохранённую в памяти, а при ошибке удаляет
+// её из памяти. Контекст проверяется раньше, в хранилище в памяти: после сохранения в памяти
+// запись в файл уже нельзя прерывать, иначе они разойдутся. Вызывается под fs.mu.
+func (fs *FileStore) appendToFile(record models.URLRecord) error {
+	if err := fs.appendRecordsToFile([]fileRecord{newFileRecord(record)}); err != nil {
+		fs.memoryStore.Remove(record)
+		return err
+	}
+	return nil
+}
+
+func (fs *FileStore) appendBatchToFile(records []models.URLRecord) error {
+	data := make([]fileRecord, 0, len(records))
+	for _, record := range records {
+		data = append(data, newFileRecord(record))
+	}
+	return fs.appendRecordsToFile(data)
+}
+
+// appendRecordsToFile дописывает строки в журнал одной записью и сбрасывает их на диск
+// согласно политике. При ошибке журнал обрезается до прежнего размера,
+// чтобы частично записанные строки не оказались в середине журнала. Вызывается под fs.mu.
+func (fs *FileStore) appendRecordsToFile(records []fileRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var (
+		buf []byte
+		err error
+	)
+	for _, record := range records {
+		if buf, err = fs.codec.appendRecord(buf, record); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.openFile(); err != nil {
+		return err
+	}
+	if _, err := fs.file.Write(buf); err != nil {
+		fs.truncate()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	switch fs.options.SyncPolicy {
+	case SyncAlways:
+		// Несброшенные строки тоже отбрасываются: вызывающий считает запись несохранённой.
+		if err := fs.file.Sync(); err != nil {
+			fs.truncate()
+			return fmt.Errorf("failed to sync file: %w", err)
+		}
+	case SyncInterval:
+		fs.dirty = true
+	case SyncNever:
+	}
+	fs.size += int64(len(buf))
+	fs.logRecords += len(records)
+	return nil
+}
+
+// truncate обрезает журнал до размера перед неудавшейся записью. Вызывается под fs.mu.
+func (fs *FileStore) truncate() {
+	if err := fs.file.Truncate(fs.size); err != nil {
+		fs.logger.Error("Failed to truncate partially written records", zap.Error(err))
+	}
+}
+
+// openFile открывает журнал на дозапись, если он ещё не открыт. Вызывается под fs.mu.
+func (fs *FileStore) openFile() error {
+	if fs.file != nil {
+		return nil
+	}
+
 	const permLvl = 0o600
 	file, err := os.OpenFile(fs.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, permLvl)
 	if err != nil {
-		fs.logger.Error("Error opening file: %v\n", zap.Error(err))
 		return fmt.Errorf("failed to open file %s: %w", fs.filePath, err)
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			fs.logger.Error("Error closing file: %v\n", zap.Error(err))
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat file %s: %w", fs.filePath, err)
+	}
+
+	// Новый файл начинается с заголовка формата.
+	size := info.Size()
+	if header := fs.codec.header(); size == 0 && len(header) > 0 {
+		if _, err := file.Write(header); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("failed to write file header: %w", err)
 		}
-	}()
+		size = int64(len(header))
+	}
 
-	// Подготавливаем данные для записи.
-	data := map[string]string{
-		"short_url":    id,
-		"original_url": originalURL,
+	fs.file = file
+	fs.size = size
+	return nil
+}
+
+// closeFile сбрасывает журнал на диск и закрывает его. Вызывается под fs.mu.
+func (fs *FileStore) closeFile() error {
+	if fs.file == nil {
+		return nil
 	}
 
-	// Кодируем данные в JSON и записываем их в файл.
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(data); err != nil {
-		return fmt.Errorf("error encoding JSON: %w", err)
+	file := fs.file
+	fs.file = nil
+	if fs.options.SyncPolicy != SyncNever && fs.options.SyncPolicy != "" {
+		if err := file.Sync(); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("failed to sync file: %w", err)
+		}
+	}
+	fs.dirty = false
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	return nil
+}
+
+// syncFile сбрасывает на диск строки, дописанные после предыдущего сброса.
+func (fs *FileStore) syncFile() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if !fs.dirty || fs.file == nil {
+		return nil
+	}
+	if err := fs.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	fs.dirty = false
+	return nil
+}
+
+// Compact переписывает журнал снимком живых записей независимо от порогов сжатия.
+func (fs *FileStore) Compact(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("compact canceled: %w", err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, err := fs.compact(); err != nil {
+		return fmt.Errorf("%w: failed to compact file: %w", errs.ErrUnavailable, err)
+	}
+	return nil
+}
+
+// Flush сбрасывает на диск строки журнала, ещё не сброшенные по политике fsync.
+func (fs *FileStore) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("flush canceled: %w", err)
 	}
 
+	if err := fs.syncFile(); err != nil {
+		return fmt.Errorf("%w: %w", errs.ErrUnavailable, err)
+	}
 	return nil
 }
 
-func (fs *FileStore) loadFromFile() error {
+// Close останавливает фоновые задачи, сбрасывает журнал на диск и закрывает его.
+func (fs *FileStore) Close(ctx context.Context) error {
+	fs.closeOnce.Do(func() {
+		close(fs.stop)
+	})
+
+	select {
+	case <-fs.done:
+	case <-ctx.Done():
+		return fmt.Errorf("close file store canceled: %w", ctx.Err())
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.closeFile()
+}
+
+func (fs *FileStore) run() {
+	defer close(fs.done)
+
+	// Канал отключённой задачи остаётся nil и никогда не срабатывает.
+	var compactTicks, syncTicks <-chan time.Time
+	if fs.options.Compaction.Interval > 0 {
+		ticker := time.NewTicker(fs.options.Compaction.Interval)
+		defer ticker.Stop()
+		compactTicks = ticker.C
+	}
+	if fs.options.SyncPolicy == SyncInterval && fs.options.SyncInterval > 0 {
+		ticker := time.NewTicker(fs.options.SyncInterval)
+		defer ticker.Stop()
+		syncTicks = ticker.C
+	}
+
+	for {
+		select {
+		case <-compactTicks:
+			if err := fs.maybeCompact(); err != nil {
+				fs.logger.Error("Failed to compact storage file", zap.Error(err))
+			}
+		case <-syncTicks:
+			if err := fs.syncFile(); err != nil {
+				fs.logger.Error("Failed to sync storage file", zap.Error(err))
+			}
+		case <-fs.stop:
+			return
+		}
+	}
+}
+
+// maybeCompact сжимает журнал, если накопленные устаревшие строки превысили пороги.
+func (fs *FileStore) maybeCompact() error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	file, err := os.Open(fs.filePath)
+	stale := fs.logRecords - fs.memoryStore.Len()
+	if stale < fs.options.Compaction.MinStaleRecords || stale == 0 {
+		return nil
+	}
+	info, err := os.Stat(fs.filePath)
 	if errors.Is(err, os.ErrNotExist) {
 		return nil
 	} else if err != nil {
-		return fmt.Errorf("error loading from file: %w", err)
+		return fmt.Errorf("failed to stat file %s: %w", fs.filePath, err)
+	}
+	if info.Size() < fs.options.Compaction.MinSize {
+		return nil
 	}
 
+	_, err = fs.compact()
+	return err
+}
+
+// compact записывает снимок записей из памяти во временный файл рядом с журналом
+// и атомарно заменяет им журнал. Возвращает число освобождённых байт. Вызывается под fs.mu.
+func (fs *FileStore) compact() (int64, error) {
+	var sizeBefore int64
+	info, err := os.Stat(fs.filePath)
+	if err == nil {
+		sizeBefore = info.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("failed to stat file %s: %w", fs.filePath, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fs.filePath), filepath.Base(fs.filePath)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	// После успешного переименования временного файла уже нет, и удаление вернёт ошибку, которую можно игнорировать.
 	defer func() {
-		if err := file.Close(); err != nil {
-			fs.logger.Error("Error closing file: %v\n", zap.Error(err))
-		}
+		_ = os.Remove(tmpPath)
 	}()
 
-	decoder := json.NewDecoder(file)
-	for {
-		var data map[string]string
-		if err := decoder.Decode(&data); err != nil {
-			if errors.Is(err, io.EOF) {
-				break
+	// Снимок записывается частями, чтобы не держать в памяти весь закодированный файл.
+	const chunkSize = 64 << 10
+	snapshot := fs.memoryStore.Snapshot()
+	buf := fs.codec.header()
+	for _, record := range snapshot {
+		if buf, err = fs.codec.appendRecord(buf, newFileRecord(record)); err != nil {
+			_ = tmp.Close()
+			return 0, err
+		}
+		if len(buf) >= chunkSize {
+			if _, err := tmp.Write(buf); err != nil {
+				_ = tmp.Close()
+				return 0, fmt.Errorf("failed to write temp file: %w", err)
 			}
-			return fmt.Errorf("error decoding JSON: %w", err)
+			buf = buf[:0]
 		}
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		_ = tmp.Close()
+		return 0, fmt.Errorf("failed to write temp file: %w", err)
+	}
 
-		if err := fs.memoryStore.SaveID(data["short_url"], data["original_url"]); err != nil {
-			return fmt.Errorf("failed to save ID in memory store: %w", err)
-		}
+	info, err = tmp.Stat()
+	if err != nil {
+		_ = tmp.Close()
+		return 0, fmt.Errorf("failed to stat temp file: %w", err)
 	}
-	return nil
+	sizeAfter := info.Size()
+
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return 0, fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close temp file: %w", err)
+	}
+	// Дескриптор старого журнала больше не нужен: следующая запись откроет новый файл.
+	if err := fs.closeFile(); err != nil {
+		fs.logger.Error("Failed to close storage file before compaction", zap.Error(err))
+	}
+	if err := os.Rename(tmpPath, fs.filePath); err != nil {
+		return 0, fmt.Errorf("failed to replace file: %w", err)
+	}
+	if err := syncDir(filepath.Dir(fs.filePath)); err != nil {
+		return 0, err
+	}
+
+	stale := fs.logRecords - len(snapshot)
+	fs.logRecords = len(snapshot)
+	reclaimed := sizeBefore - sizeAfter
+	fs.logger.Info("Storage file compacted",
+		zap.String("path", fs.filePath),
+		zap.Int("records", len(snapshot)),
+		zap.Int("stale_records", stale),
+		zap.Int64("size_before", sizeBefore),
+		zap.Int64("size_after", sizeAfter),
+		zap.Int64("bytes_reclaimed", reclaimed),
+	)
+	return reclaimed, nil
 }
 
-func (fs *FileStore) appendBatchToFile(pairs map[string]string) error {
-	const permLvl = 0o600
-	file, err := os.OpenFile(fs.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, permLvl)
+// syncDir сбрасывает на диск каталог, чтобы переименование файла пережило сбой.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		return fmt.Errorf("failed to open directory %s: %w", dir, err)
 	}
 	defer func() {
-		if err := file.Close(); err != nil {
-			fs.logger.Error("Error closing file: %v\n", zap.Error(err))
-		}
+		_ = d.Close()
 	}()
 
-	encoder := json.NewEncoder(file)
-	for id, originalURL := range pairs {
-		data := map[string]string{
-			"short_url":    id,
-			"original_url": originalURL,
-		}
-		if err := encoder.Encode(data); err != nil {
-			return fmt.Errorf("failed to encode data: %w", err)
-		}
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
 	}
 	return nil
 }
@@ -0,0 +1,291 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestLogger(t *testing.T) logger.Logger {
+	t.Helper()
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+	return logger.NewZapLogger(zapLogger)
+}
+
+func newTestStore(t *testing.T, filePath string, options Options) *FileStore {
+	t.Helper()
+
+	store, err := NewFileStore(filePath, options, newTestLogger(t))
+	require.NoError(t, err)
+	return store
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	return info.Size()
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "storage.json")
+	store := newTestStore(t, filePath, Options{})
+	const total = 5
+	for i := range total {
+		require.NoError(t, store.SaveID(ctx, models.URLRecord{
+			ShortID:     fmt.Sprintf("id%d", i),
+			OriginalURL: fmt.Sprintf("https://example.com/%d", i),
+			UserID:      "user",
+		}))
+	}
+	require.NoError(t, store.DeleteURLs(ctx, []models.DeleteRequest{{UserID: "user", ShortID: "id1"}}))
+	lastAccess := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for range 3 {
+		require.NoError(t, store.RecordClicks(ctx, []models.ClickStat{
+			{ShortID: "id0", Clicks: 1, LastAccessedAt: lastAccess},
+		}))
+	}
+
+	sizeBefore := fileSize(t, filePath)
+	require.NoError(t, store.Compact(ctx))
+	assert.Less(t, fileSize(t, filePath), sizeBefore)
+	assert.Equal(t, total, store.logRecords)
+
+	// После перезапуска состояние восстанавливается из снимка.
+	restarted := newTestStore(t, filePath, Options{})
+
+	_, err := restarted.Get(ctx, "id1")
+	assert.ErrorIs(t, err, errs.ErrDeleted)
+
+	stat, err := restarted.GetStats(ctx, "id0")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stat.Clicks)
+	assert.True(t, lastAccess.Equal(stat.LastAccessedAt))
+
+	records, err := restarted.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.ShortID)
+	}
+	assert.Equal(t, []string{"id0", "id2", "id3", "id4"}, ids)
+}
+
+func TestMaybeCompact(t *testing.T) {
+	tests := []struct {
+		name            string
+		compaction      CompactionConfig
+		expectCompacted bool
+	}{
+		{
+			name:            "Below stale records threshold",
+			compaction:      CompactionConfig{MinStaleRecords: 10},
+			expectCompacted: false,
+		},
+		{
+			name:            "Below size threshold",
+			compaction:      CompactionConfig{MinStaleRecords: 1, MinSize: 1 << 20},
+			expectCompacted: false,
+		},
+		{
+			name:            "Thresholds reached",
+			compaction:      CompactionConfig{MinStaleRecords: 2, MinSize: 1},
+			expectCompacted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			filePath := filepath.Join(t.TempDir(), "storage.json")
+			store := newTestStore(t, filePath, Options{Compaction: tt.compaction})
+
+			require.NoError(t, store.SaveID(ctx, models.URLRecord{ShortID: "id", OriginalURL: "https://example.com"}))
+			for range 2 {
+				require.NoError(t, store.RecordClicks(ctx, []models.ClickStat{{ShortID: "id", Clicks: 1}}))
+			}
+
+			sizeBefore := fileSize(t, filePath)
+			require.NoError(t, store.maybeCompact())
+			if tt.expectCompacted {
+				assert.Less(t, fileSize(t, filePath), sizeBefore)
+				assert.Equal(t, 1, store.logRecords)
+			} else {
+				assert.Equal(t, sizeBefore, fileSize(t, filePath))
+				assert.Equal(t, 3, store.logRecords)
+			}
+		})
+	}
+}
+
+func TestLoadCorruptedFile(t *testing.T) {
+	const (
+		first  = `{"short_url":"id1","original_url":"https://example.com/1"}` + "\n"
+		second = `{"short_url":"id2","original_url":"https://example.com/2"}` + "\n"
+		broken = `{"short_url":"id3","orig` + "\n"
+		torn   = `{"short_url":"id4","original_u`
+	)
+
+	tests := []struct {
+		name         string
+		content      string
+		expectedFile string
+		expectedIDs  []string
+		corruptLines []int
+		repair       bool
+	}{
+		{
+			name:         "Torn trailing record is truncated",
+			content:      first + second + torn,
+			expectedFile: first + second,
+			expectedIDs:  []string{"id1", "id2"},
+		},
+		{
+			name:         "Mid-file corruption refuses to start",
+			content:      first + broken + second + torn,
+			corruptLines: []int{2},
+		},
+		{
+			name:         "Mid-file corruption is skipped with repair",
+			content:      first + broken + second + torn,
+			expectedFile: first + second,
+			expectedIDs:  []string{"id1", "id2"},
+			repair:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "storage.json")
+			require.NoError(t, os.WriteFile(filePath, []byte(tt.content), 0o600))
+
+			store, err := NewFileStore(filePath, Options{Repair: tt.repair}, newTestLogger(t))
+			if tt.corruptLines != nil {
+				var corruptionErr *CorruptionError
+				require.ErrorAs(t, err, &corruptionErr)
+				assert.Equal(t, tt.corruptLines, corruptionErr.Lines)
+
+				// Файл при отказе запуска не меняется.
+				content, err := os.ReadFile(filePath)
+				require.NoError(t, err)
+				assert.Equal(t, tt.content, string(content))
+				return
+			}
+			require.NoError(t, err)
+
+			// При восстановлении журнал переписывается снимком, порядок строк в котором не определён.
+			content, err := os.ReadFile(filePath)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, strings.SplitAfter(tt.expectedFile, "\n"), strings.SplitAfter(string(content), "\n"))
+			for _, id := range tt.expectedIDs {
+				_, err := store.Get(context.Background(), id)
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAppendAfterTornRecord(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "storage.json")
+	require.NoError(t, os.WriteFile(filePath, []byte(`{"short_url":"id1","orig`), 0o600))
+
+	store := newTestStore(t, filePath, Options{SyncPolicy: SyncAlways})
+	require.NoError(t, store.SaveID(ctx, models.URLRecord{ShortID: "id2", OriginalURL: "https://example.com/2"}))
+	require.NoError(t, store.Close(ctx))
+
+	// Новая запись не склеилась с оборванной строкой и читается после перезапуска.
+	restarted := newTestStore(t, filePath, Options{})
+	originalURL, err := restarted.Get(ctx, "id2")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/2", originalURL)
+}
+
+func TestFailedAppendRollsBack(t *testing.T) {
+	ctx := context.Background()
+	// Каталога журнала ещё нет, поэтому журнал не открывается на запись.
+	dir := filepath.Join(t.TempDir(), "missing")
+	filePath := filepath.Join(dir, "storage.json")
+	store := newTestStore(t, filePath, Options{})
+
+	record := models.URLRecord{ShortID: "id", OriginalURL: "https://example.com"}
+	require.ErrorIs(t, store.SaveID(ctx, record), errs.ErrUnavailable)
+	_, _, err := store.GetOrCreate(ctx, models.URLRecord{ShortID: "id2", OriginalURL: "https://example.com/2"})
+	require.ErrorIs(t, err, errs.ErrUnavailable)
+	results, err := store.SaveBatch(ctx, []models.URLRecord{{ShortID: "id3", OriginalURL: "https://example.com/3"}})
+	require.ErrorIs(t, err, errs.ErrUnavailable)
+	assert.Nil(t, results)
+	for _, id := range []string{"id", "id2", "id3"} {
+		_, err = store.Get(ctx, id)
+		require.ErrorIs(t, err, errs.ErrNotFound, id)
+	}
+	_, err = store.GetIDByURL(ctx, record.OriginalURL)
+	require.ErrorIs(t, err, errs.ErrNotFound)
+
+	// Повтор после восстановления создаёт ссылку заново, а не сообщает о конфликте.
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	id, existed, err := store.GetOrCreate(ctx, record)
+	require.NoError(t, err)
+	assert.False(t, existed)
+	assert.Equal(t, "id", id)
+	require.NoError(t, store.Close(ctx))
+
+	reopened := newTestStore(t, filePath, Options{})
+	defer func() { require.NoError(t, reopened.Close(ctx)) }()
+	originalURL, err := reopened.Get(ctx, "id")
+	require.NoError(t, err)
+	assert.Equal(t, record.OriginalURL, originalURL)
+}
+
+func TestFailedDeleteRollsBack(t *testing.T) {
+	ctx := context.Background()
+	// Ссылка восстановлена только в памяти, а журнал не открывается на запись.
+	store := newTestStore(t, filepath.Join(t.TempDir(), "missing", "storage.json"), Options{})
+	defer func() { require.NoError(t, store.Close(ctx)) }()
+	record := models.URLRecord{ShortID: "id", OriginalURL: "https://example.com", UserID: "user"}
+	store.memoryStore.Restore(record)
+
+	err := store.DeleteURLs(ctx, []models.DeleteRequest{{UserID: "user", ShortID: "id"}})
+	require.ErrorIs(t, err, errs.ErrUnavailable)
+	originalURL, err := store.Get(ctx, "id")
+	require.NoError(t, err)
+	assert.Equal(t, record.OriginalURL, originalURL)
+	id, err := store.GetIDByURL(ctx, record.OriginalURL)
+	require.NoError(t, err)
+	assert.Equal(t, "id", id)
+}
+
+func TestFailedClicksRollBack(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, filepath.Join(t.TempDir(), "missing", "storage.json"), Options{})
+	defer func() { require.NoError(t, store.Close(ctx)) }()
+	accessedAt := time.Now().Add(-time.Hour).UTC()
+	store.memoryStore.Restore(models.URLRecord{
+		ShortID: "id", OriginalURL: "https://example.com", Clicks: 2, LastAccessedAt: accessedAt,
+	})
+
+	err := store.RecordClicks(ctx, []models.ClickStat{
+		{ShortID: "id", Clicks: 1, LastAccessedAt: time.Now()},
+		{ShortID: "id", Clicks: 3, LastAccessedAt: time.Now()},
+		{ShortID: "unknown", Clicks: 1},
+	})
+	require.ErrorIs(t, err, errs.ErrUnavailable)
+	stat, err := store.GetStats(ctx, "id")
+	require.NoError(t, err)
+	assert.Equal(t, models.ClickStat{ShortID: "id", Clicks: 2, LastAccessedAt: accessedAt}, stat)
+}
@@ -0,0 +1,198 @@
+package file
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"go.uber.org/zap"
+)
+
+// CorruptionError сообщает о повреждённых записях в середине журнала.
+// Такие записи не могут быть следствием оборванной записи, поэтому хранилище
+// не запускается, пока повреждение не будет исправлено или разрешено флагом восстановления.
+// Lines — номера строк для JSON и порядковые номера записей для двоичного формата.
+type CorruptionError struct {
+	Path  string
+	Lines []int
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("storage file %s is corrupted at records %v, enable repair to skip them", e.Path, e.Lines)
+}
+
+// corruptLine — запись журнала, которую не удалось разобрать.
+type corruptLine struct {
+	err error
+	position
+}
+
+// loadFromFile восстанавливает записи из журнала.
+// Повреждённая последняя запись считается оборванной при сбое и отрезается.
+// Повреждённые записи в середине журнала пропускаются только с Options.Repair,
+// после чего журнал переписывается без них, иначе возвращается *CorruptionError.
+// Журнал в формате, отличном от Options.Format, один раз переписывается в нужном формате.
+func (fs *FileStore) loadFromFile() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	file, err := os.Open(fs.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error loading from file: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			fs.logger.Error("Error closing file: %v\n", zap.Error(err))
+		}
+	}()
+
+	reader := bufio.NewReader(file)
+	format, tornHeader, err := detectFormat(reader)
+	if err != nil {
+		return err
+	}
+	if tornHeader {
+		if err := os.Truncate(fs.filePath, 0); err != nil {
+			return fmt.Errorf("failed to truncate torn header: %w", err)
+		}
+		fs.logger.Warn("Truncated torn header of storage file", zap.String("path", fs.filePath))
+		return nil
+	}
+	if format == "" {
+		return nil
+	}
+
+	var headerSize int64
+	if format == FormatBinary {
+		headerSize = binaryHeaderSize
+	}
+	decoder := newCodec(format).newDecoder(reader, headerSize)
+
+	var (
+		corrupted  []corruptLine
+		unreadable *corruptLine
+		last       int
+	)
+	for {
+		data, pos, err := decoder.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, errUnreadable) {
+			unreadable = &corruptLine{position: pos, err: err}
+			break
+		}
+		if errors.Is(err, errCorruptRecord) {
+			corrupted = append(corrupted, corruptLine{position: pos, err: err})
+			last = pos.number
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		last = pos.number
+		fs.logRecords++
+		fs.applyRecord(data)
+	}
+
+	// Место, с которого отрезается хвост файла, или -1, если отрезать нечего.
+	truncateAt := int64(-1)
+	switch {
+	case unreadable != nil:
+		if !fs.options.Repair {
+			return fs.corruptionError(append(corrupted, *unreadable))
+		}
+		truncateAt = unreadable.offset
+		fs.logger.Warn("Dropped unreadable tail of storage file",
+			zap.String("path", fs.filePath),
+			zap.Int("record", unreadable.number),
+			zap.Int64("offset", unreadable.offset),
+			zap.Error(unreadable.err),
+		)
+	case len(corrupted) > 0 && corrupted[len(corrupted)-1].number == last:
+		// Последняя запись могла оборваться при сбое во время записи: отрезаем её.
+		torn := corrupted[len(corrupted)-1]
+		corrupted = corrupted[:len(corrupted)-1]
+		truncateAt = torn.offset
+		fs.logger.Warn("Truncated torn record at the end of storage file",
+			zap.String("path", fs.filePath),
+			zap.Int("record", torn.number),
+			zap.Int64("bytes", torn.size),
+			zap.NamedError("reason", torn.err),
+		)
+	}
+
+	if len(corrupted) > 0 && !fs.options.Repair {
+		return fs.corruptionError(corrupted)
+	}
+	for _, line := range corrupted {
+		fs.logger.Warn("Skipped corrupted storage file record",
+			zap.String("path", fs.filePath),
+			zap.Int("record", line.number),
+			zap.Error(line.err),
+		)
+	}
+
+	if truncateAt >= 0 {
+		if err := os.Truncate(fs.filePath, truncateAt); err != nil {
+			return fmt.Errorf("failed to truncate storage file: %w", err)
+		}
+	}
+
+	// Повреждённые записи и записи в другом формате убираются переписыванием снимка.
+	if format != fs.options.format() {
+		if _, err := fs.compact(); err != nil {
+			return fmt.Errorf("failed to convert storage file: %w", err)
+		}
+		fs.logger.Info("Storage file converted",
+			zap.String("path", fs.filePath), zap.String("from", string(format)),
+			zap.String("to", string(fs.options.format())))
+		return nil
+	}
+	if len(corrupted) > 0 || unreadable != nil {
+		if _, err := fs.compact(); err != nil {
+			return fmt.Errorf("failed to rewrite repaired file: %w", err)
+		}
+	}
+	return nil
+}
+
+func (fs *FileStore) corruptionError(corrupted []corruptLine) error {
+	lines := make([]int, 0, len(corrupted))
+	for _, line := range corrupted {
+		fs.logger.Error("Corrupted storage file record",
+			zap.String("path", fs.filePath),
+			zap.Int("record", line.number),
+			zap.Error(line.err),
+		)
+		lines = append(lines, line.number)
+	}
+	return &CorruptionError{Path: fs.filePath, Lines: lines}
+}
+
+// applyRecord применяет строку журнала к хранилищу в памяти.
+func (fs *FileStore) applyRecord(data fileRecord) {
+	// Надгробие не содержит URL, а удалённая запись из снимка хранит его вместе с флагом.
+	if data.IsDeleted && data.OriginalURL == "" {
+		fs.memoryStore.MarkDeleted([]models.DeleteRequest{{UserID: data.UserID, ShortID: data.ShortURL}})
+		return
+	}
+	if data.isClickDelta() {
+		fs.memoryStore.ApplyClicks([]models.ClickStat{{
+			ShortID:        data.ShortURL,
+			Clicks:         data.Clicks,
+			LastAccessedAt: timeValue(data.LastAccessedAt),
+		}})
+		return
+	}
+
+	// Файл мог быть записан пакетами с повторяющимися URL, поэтому
+	// восстанавливаем записи без проверки уникальности оригинального URL.
+	fs.memoryStore.Restore(data.toURLRecord())
+}
@@ -0,0 +1,83 @@
+package file
+
+import (
+	"fmt"
+	"time"
+)
+
+// SyncPolicy определяет, когда дописанные в журнал строки сбрасываются на диск.
+type SyncPolicy string
+
+const (
+	// SyncAlways сбрасывает журнал на диск после каждой записи.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval сбрасывает журнал на диск периодически: при сбое теряются записи за последний интервал.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever оставляет сброс на диск операционной системе.
+	SyncNever SyncPolicy = "never"
+)
+
+// ParseSyncPolicy разбирает название политики сброса на диск.
+func ParseSyncPolicy(value string) (SyncPolicy, error) {
+	switch policy := SyncPolicy(value); policy {
+	case SyncAlways, SyncInterval, SyncNever:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown fsync policy %q, expected always, interval or never", value)
+	}
+}
+
+// CompactionConfig задаёт, когда журнал переписывается снимком живых записей.
+// Сжатие выполняется, если в журнале накопилось не меньше MinStaleRecords устаревших строк
+// и файл занимает не меньше MinSize байт. Нулевой Interval отключает периодическую проверку.
+type CompactionConfig struct {
+	Interval        time.Duration
+	MinSize         int64
+	MinStaleRecords int
+}
+
+// DefaultCompactionConfig возвращает настройки сжатия по умолчанию.
+func DefaultCompactionConfig() CompactionConfig {
+	const (
+		defaultInterval        = 10 * time.Minute
+		defaultMinSize         = 1 << 20
+		defaultMinStaleRecords = 1000
+	)
+	return CompactionConfig{
+		Interval:        defaultInterval,
+		MinSize:         defaultMinSize,
+		MinStaleRecords: defaultMinStaleRecords,
+	}
+}
+
+// Options — настройки FileStore. Нулевое значение отключает фоновые задачи и fsync.
+type Options struct {
+	// Format — формат файла. Пустое значение равносильно FormatJSON.
+	Format Format
+	// SyncPolicy — политика сброса журнала на диск. Пустое значение равносильно SyncNever.
+	SyncPolicy SyncPolicy
+	Compaction CompactionConfig
+	// SyncInterval — период сброса на диск для SyncInterval.
+	SyncInterval time.Duration
+	// Repair разрешает запуск с повреждёнными строками в середине журнала:
+	// они пропускаются, а журнал переписывается без них.
+	Repair bool
+}
+
+// DefaultOptions возвращает настройки FileStore по умолчанию.
+func DefaultOptions() Options {
+	const defaultSyncInterval = time.Second
+	return Options{
+		Format:       FormatJSON,
+		SyncPolicy:   SyncInterval,
+		SyncInterval: defaultSyncInterval,
+		Compaction:   DefaultCompactionConfig(),
+	}
+}
+
+func (o Options) format() Format {
+	if o.Format == "" {
+		return FormatJSON
+	}
+	return o.Format
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/bloom"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"go.uber.org/zap"
+)
+
+// IDLister — необязательный интерфейс хранилища, перечисляющего ID всех сохранённых ссылок.
+// По нему строится фильтр известных ID.
+type IDLister interface {
+	ForEachID(ctx context.Context, fn func(id string) error) error
+}
+
+// FilteredStorage — декоратор хранилища, отвечающий ErrNotFound на Get неизвестных ID
+// без обращения к хранилищу. Известные ID хранятся в фильтре Блума, который строится
+// при создании по всем ID хранилища и пополняется при каждом сохранении. Когда сохранено
+// больше ID, чем рассчитан фильтр, он перестраивается в фоне под новое число ID.
+// Удалённые и просроченные ссылки остаются в фильтре, и для них Get доходит до хранилища.
+//
+// Пока фильтр строится, если построить его не удалось или если прервались оповещения
+// об изменениях общего хранилища, Get доходит до хранилища всегда.
+type FilteredStorage struct {
+	Storage
+	lister    IDLister
+	logger    logger.Logger
+	filter    *atomic.Pointer[bloom.Filter]
+	rejected  *atomic.Uint64
+	mu        *sync.Mutex
+	rebuildMu *sync.Mutex
+	// feedLost — оповещения об изменениях прервались, и фильтр не знает ID других экземпляров.
+	feedLost *atomic.Bool
+	// growing — фильтр переполнен и перестраивается в фоне.
+	growing *atomic.Bool
+	growth  *sync.WaitGroup
+	// growthCtx отменяется при закрытии и прерывает фоновое перестроение.
+	growthCtx  context.Context
+	stopGrowth context.CancelFunc
+	// pending не равен nil, пока фильтр строится, и копит ID, сохранённые за это время.
+	pending []string
+	options bloom.Options
+}
+
+// NewFilteredStorage оборачивает store фильтром, построенным по ID из lister. Обычно lister —
+// исходное хранилище, а store — оно же или его обёртка. Фильтр рассчитывается на удвоенное
+// текущее число ссылок, но не меньше options.MinCapacity, чтобы выдержать рост.
+func NewFilteredStorage(
+	ctx context.Context, store Storage, lister IDLister, options bloom.Options, storageLogger logger.Logger,
+) (*FilteredStorage, error) {
+	s := newFilteredStorage(store, lister, options, storageLogger)
+	if err := s.rebuild(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newFilteredStorage оборачивает store ещё не построенным фильтром: ID, сохранённые
+// до окончания rebuild, попадут в фильтр.
+func newFilteredStorage(
+	store Storage, lister IDLister, options bloom.Options, storageLogger logger.Logger,
+) *FilteredStorage {
+	growthCtx, stopGrowth := context.WithCancel(context.Background())
+	return &FilteredStorage{
+		Storage:    store,
+		lister:     lister,
+		logger:     storageLogger,
+		filter:     &atomic.Pointer[bloom.Filter]{},
+		rejected:   &atomic.Uint64{},
+		mu:         &sync.Mutex{},
+		rebuildMu:  &sync.Mutex{},
+		feedLost:   &atomic.Bool{},
+		growing:    &atomic.Bool{},
+		growth:     &sync.WaitGroup{},
+		growthCtx:  growthCtx,
+		stopGrowth: stopGrowth,
+		pending:    []string{},
+		options:    options,
+	}
+}
+
+// rebuild строит фильтр заново по ID из lister. Если построить фильтр не удалось,
+// Get не фильтруется до следующего успешного перестроения.
+func (s *FilteredStorage) rebuild(ctx context.Context) error {
+	s.rebuildMu.Lock()
+	defer s.rebuildMu.Unlock()
+
+	s.mu.Lock()
+	s.filter.Store(nil)
+	if s.pending == nil {
+		s.pending = []string{}
+	}
+	s.mu.Unlock()
+
+	filter, err := s.build(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		for _, id := range s.pending {
+			filter.Add(id)
+		}
+		s.filter.Store(filter)
+	}
+	s.pending = nil
+	return err
+}
+
+func (s *FilteredStorage) build(ctx context.Context) (*bloom.Filter, error) {
+	var count int
+	if err := s.lister.ForEachID(ctx, func(string) error {
+		count++
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to count IDs: %w", err)
+	}
+
+	const growthFactor = 2
+	filter := bloom.New(max(growthFactor*count, s.options.MinCapacity), s.options.FalsePositiveRate,
+		s.options.MaxBytes)
+	if err := s.lister.ForEachID(ctx, func(id string) error {
+		filter.Add(id)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to fill ID filter: %w", err)
+	}
+
+	stats := filter.Stats()
+	s.logger.Info("ID filter built",
+		zap.Int64("ids", stats.Added),
+		zap.Uint64("bits", stats.Bits),
+		zap.Int("hashes", stats.Hashes),
+		zap.Float64("estimated_false_positive_rate", stats.EstimatedFalsePositiveRate),
+	)
+	return filter, nil
+}
+
+// add добавляет ID в фильтр, а во время построения — и в новый фильтр.
+// Переполненный фильтр перестраивается в фоне.
+func (s *FilteredStorage) add(id string) {
+	s.mu.Lock()
+	if s.pending != nil {
+		s.pending = append(s.pending, id)
+	}
+	s.mu.Unlock()
+
+	if filter := s.filter.Load(); filter != nil {
+		filter.Add(id)
+		if filter.Full() && s.growing.CompareAndSwap(false, true) {
+			s.growth.Add(1)
+			go s.grow()
+		}
+	}
+}
+
+// grow перестраивает переполненный фильтр с запасом под текущее число ID.
+func (s *FilteredStorage) grow() {
+	defer s.growth.Done()
+	defer s.growing.Store(false)
+
+	s.logger.Info("ID filter is full, rebuilding")
+	if err := s.rebuild(s.growthCtx); err != nil && s.growthCtx.Err() == nil {
+		s.logger.Error("Failed to rebuild ID filter, filtering disabled", zap.Error(err))
+	}
+}
+
+// FilterStats — параметры фильтра и число Get, отклонённых без обращения к хранилищу.
+type FilterStats struct {
+	bloom.Stats
+	Rejected uint64
+}
+
+// Stats возвращает параметры фильтра и число отклонённых Get.
+func (s *FilteredStorage) Stats() FilterStats {
+	stats := FilterStats{Rejected: s.rejected.Load()}
+	if filter := s.filter.Load(); filter != nil {
+		stats.Stats = filter.Stats()
+	}
+	return stats
+}
+
+func (s *FilteredStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, s.Storage)
+}
+
+func (s *FilteredStorage) Get(ctx context.Context, id string) (string, error) {
+	if filter := s.filter.Load(); filter != nil && !s.feedLost.Load() && !filter.MayContain(id) {
+		s.rejected.Add(1)
+		return "", fmt.Errorf("ID %s: %w", id, errs.ErrNotFound)
+	}
+	return s.Storage.Get(ctx, id)
+}
+
+// SaveID добавляет ID в фильтр до сохранения, чтобы Get, начатый после сохранения, уже видел его.
+// Если сохранение не удалось, лишний ID лишь даёт ложное срабатывание. GetOrCreate и SaveBatch
+// поступают так же.
+func (s *FilteredStorage) SaveID(ctx context.Context, record models.URLRecord) error {
+	s.add(record.ShortID)
+	return s.Storage.SaveID(ctx, record)
+}
+
+func (s *FilteredStorage) GetOrCreate(ctx context.Context, record models.URLRecord) (string, bool, error) {
+	s.add(record.ShortID)
+	return s.Storage.GetOrCreate(ctx, record)
+}
+
+func (s *FilteredStorage) SaveBatch(ctx context.Context, records []models.URLRecord) ([]models.BatchResult, error) {
+	for _, record := range records {
+		s.add(record.ShortID)
+	}
+	return s.Storage.SaveBatch(ctx, records)
+}
+
+// Invalidate добавляет в фильтр ID, сохранённые в хранилище, в том числе другими экземплярами
+// сервиса. Пока оповещения прерваны, Get не фильтруется: ID других экземпляров были бы
+// отклонены как неизвестные. При сбросе изменения фильтр перестраивается по хранилищу.
+func (s *FilteredStorage) Invalidate(change models.URLChange) {
+	if change.Lost {
+		s.feedLost.Store(true)
+		s.logger.Warn("Change notifications lost, ID filter disabled until resync")
+		return
+	}
+	if change.Reset {
+		if err := s.rebuild(context.Background()); err != nil {
+			s.logger.Error("Failed to rebuild ID filter, filtering disabled", zap.Error(err))
+			return
+		}
+		s.feedLost.Store(false)
+		return
+	}
+	for _, id := range change.ShortIDs {
+		s.add(id)
+	}
+}
+
+// Close прерывает фоновое перестроение фильтра, дожидается его и закрывает хранилище.
+func (s *FilteredStorage) Close(ctx context.Context) error {
+	s.stopGrowth()
+	s.growth.Wait()
+	return s.Storage.Close(ctx)
+}
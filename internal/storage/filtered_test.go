@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/bloom"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/BrownBear56/contractor/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestFilteredStorage(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.NewMemoryStore()
+	require.NoError(t, backend.SaveID(ctx, models.URLRecord{ShortID: "old", OriginalURL: "https://example.com/old"}))
+
+	options := bloom.Options{FalsePositiveRate: 0.01, MaxBytes: 1 << 10, MinCapacity: 100}
+	store, err := NewFilteredStorage(ctx, backend, backend, options, logger.NewZapLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	// ID, сохранённый до построения фильтра, и ID, сохранённый через декоратор, доходят до хранилища.
+	require.NoError(t, store.SaveID(ctx, models.URLRecord{ShortID: "new", OriginalURL: "https://example.com/new"}))
+	for _, id := range []string{"old", "new"} {
+		_, err := store.Get(ctx, id)
+		require.NoError(t, err)
+	}
+
+	_, err = store.Get(ctx, "missing")
+	require.ErrorIs(t, err, errs.ErrNotFound)
+	assert.Equal(t, uint64(1), store.Stats().Rejected)
+}
+
+func TestFilteredStorageInvalidate(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.NewMemoryStore()
+	options := bloom.Options{FalsePositiveRate: 0.01, MaxBytes: 1 << 10, MinCapacity: 100}
+	store, err := NewFilteredStorage(ctx, backend, backend, options, logger.NewZapLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	// Ссылки, сохранённые в обход декоратора, как с другого экземпляра, фильтр не знает.
+	for _, id := range []string{"notified", "missed"} {
+		require.NoError(t, backend.SaveID(ctx, models.URLRecord{ShortID: id, OriginalURL: "https://example.com/" + id}))
+		_, err = store.Get(ctx, id)
+		require.ErrorIs(t, err, errs.ErrNotFound)
+	}
+
+	store.Invalidate(models.URLChange{ShortIDs: []string{"notified"}})
+	_, err = store.Get(ctx, "notified")
+	require.NoError(t, err)
+
+	// Сброс перестраивает фильтр по хранилищу.
+	store.Invalidate(models.URLChange{Reset: true})
+	_, err = store.Get(ctx, "missed")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), store.Stats().Added)
+}
+
+func TestFilteredStorageGrows(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.NewMemoryStore()
+	const minCapacity = 4
+	options := bloom.Options{FalsePositiveRate: 0.01, MaxBytes: 1 << 10, MinCapacity: minCapacity}
+	store, err := NewFilteredStorage(ctx, backend, backend, options, logger.NewZapLogger(zap.NewNop()))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, store.Close(ctx)) }()
+	require.Equal(t, minCapacity, store.Stats().Capacity)
+
+	const total = 20
+	for i := range total {
+		id := fmt.Sprintf("id%d", i)
+		require.NoError(t, store.SaveID(ctx, models.URLRecord{ShortID: id, OriginalURL: "https://example.com/" + id}))
+	}
+	// Перестроение идёт в фоне и может повторяться, пока сохранения обгоняют его.
+	assert.Eventually(t, func() bool {
+		stats := store.Stats()
+		return !store.growing.Load() && stats.Capacity >= total && stats.Added == total
+	}, time.Second, time.Millisecond)
+	for i := range total {
+		_, err := store.Get(ctx, fmt.Sprintf("id%d", i))
+		require.NoError(t, err)
+	}
+}
+
+func TestFilteredStorageFeedLost(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.NewMemoryStore()
+	options := bloom.Options{FalsePositiveRate: 0.01, MaxBytes: 1 << 10, MinCapacity: 100}
+	store, err := NewFilteredStorage(ctx, backend, backend, options, logger.NewZapLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	// Пока оповещения прерваны, ссылки других экземпляров доходят до хранилища.
+	store.Invalidate(models.URLChange{Lost: true})
+	require.NoError(t, backend.SaveID(ctx, models.URLRecord{ShortID: "remote", OriginalURL: "https://example.com/r"}))
+	_, err = store.Get(ctx, "remote")
+	require.NoError(t, err)
+	_, err = store.Get(ctx, "missing")
+	require.ErrorIs(t, err, errs.ErrNotFound)
+	assert.Zero(t, store.Stats().Rejected)
+
+	// После восстановления фильтр перестраивается и снова отклоняет неизвестные ID.
+	store.Invalidate(models.URLChange{Reset: true})
+	_, err = store.Get(ctx, "remote")
+	require.NoError(t, err)
+	_, err = store.Get(ctx, "missing")
+	require.ErrorIs(t, err, errs.ErrNotFound)
+	assert.Equal(t, uint64(1), store.Stats().Rejected)
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/BrownBear56/contractor/internal/storage/postgres"
+)
+
+const (
+	// DefaultMaxURLLength — наибольшая длина оригинального URL в байтах по умолчанию.
+	// Её хватает для подписанных ссылок S3 и ссылок с длинными метками отслеживания.
+	DefaultMaxURLLength = 32 << 10
+	// MaxURLLengthLimit — верхняя граница настройки: запись файлового хранилища в двоичном
+	// формате не может превышать 1 МиБ, и URL должен помещаться в неё с запасом.
+	MaxURLLengthLimit = 512 << 10
+	// MaxShortIDLength и MaxUserIDLength — наибольшие длины короткого ID и ID пользователя
+	// в байтах, которые помещаются в колонки PostgreSQL.
+	MaxShortIDLength = postgres.MaxShortIDLength
+	MaxUserIDLength  = postgres.MaxUserIDLength
+)
+
+// LimitedStorage — декоратор хранилища, отклоняющий сохранение URL длиннее заданного
+// одинаково для всех бэкендов, в том числе для PostgreSQL, где колонка не ограничена.
+type LimitedStorage struct {
+	Storage
+	maxURLLength int
+}
+
+// NewLimitedStorage оборачивает store проверкой длины URL в байтах.
+func NewLimitedStorage(store Storage, maxURLLength int) *LimitedStorage {
+	return &LimitedStorage{Storage: store, maxURLLength: maxURLLength}
+}
+
+func (s *LimitedStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, s.Storage)
+}
+
+func (s *LimitedStorage) check(originalURL string) error {
+	if len(originalURL) > s.maxURLLength {
+		return fmt.Errorf("%d bytes, limit %d: %w", len(originalURL), s.maxURLLength, errs.ErrURLTooLong)
+	}
+	return nil
+}
+
+func (s *LimitedStorage) SaveID(ctx context.Context, record models.URLRecord) error {
+	if err := s.check(record.OriginalURL); err != nil {
+		return err
+	}
+	return s.Storage.SaveID(ctx, record)
+}
+
+func (s *LimitedStorage) GetOrCreate(ctx context.Context, record models.URLRecord) (string, bool, error) {
+	if err := s.check(record.OriginalURL); err != nil {
+		return "", false, err
+	}
+	return s.Storage.GetOrCreate(ctx, record)
+}
+
+// SaveBatch отмечает слишком длинные URL как несохранённые и передаёт хранилищу остальные.
+func (s *LimitedStorage) SaveBatch(ctx context.Context, records []models.URLRecord) ([]models.BatchResult, error) {
+	results := make([]models.BatchResult, len(records))
+	accepted := make([]models.URLRecord, 0, len(records))
+	positions := make([]int, 0, len(records))
+	for i, record := range records {
+		if err := s.check(record.OriginalURL); err != nil {
+			results[i] = models.BatchResult{Status: models.BatchFailed, Err: err}
+			continue
+		}
+		accepted = append(accepted, record)
+		positions = append(positions, i)
+	}
+	if len(accepted) == 0 {
+		return results, nil
+	}
+
+	saved, err := s.Storage.SaveBatch(ctx, accepted)
+	if err != nil {
+		return nil, err
+	}
+	for j, result := range saved {
+		results[positions[j]] = result
+	}
+	return results, nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/cache"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/BrownBear56/contractor/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitedStorage(t *testing.T) {
+	ctx := context.Background()
+	const maxURLLength = 32
+	store := NewLimitedStorage(memory.NewMemoryStore(), maxURLLength)
+	longURL := "https://example.com/" + strings.Repeat("a", maxURLLength)
+
+	err := store.SaveID(ctx, models.URLRecord{ShortID: "long", OriginalURL: longURL})
+	require.ErrorIs(t, err, errs.ErrURLTooLong)
+	_, _, err = store.GetOrCreate(ctx, models.URLRecord{ShortID: "long", OriginalURL: longURL})
+	require.ErrorIs(t, err, errs.ErrURLTooLong)
+
+	// Длинный URL посреди пакета не сдвигает результаты остальных записей.
+	results, err := store.SaveBatch(ctx, []models.URLRecord{
+		{ShortID: "first", OriginalURL: "https://example.com/first"},
+		{ShortID: "long", OriginalURL: longURL},
+		{ShortID: "last", OriginalURL: "https://example.com/last"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, models.BatchResult{ShortID: "first", Status: models.BatchCreated}, results[0])
+	assert.Equal(t, models.BatchFailed, results[1].Status)
+	assert.ErrorIs(t, results[1].Err, errs.ErrURLTooLong)
+	assert.Equal(t, models.BatchResult{ShortID: "last", Status: models.BatchCreated}, results[2])
+
+	_, err = store.Get(ctx, "long")
+	assert.ErrorIs(t, err, errs.ErrNotFound)
+}
+
+// pingStorage — хранилище, проверка доступности которого возвращает err.
+type pingStorage struct {
+	Storage
+	err error
+}
+
+func (s *pingStorage) Ping(context.Context) error {
+	return s.err
+}
+
+func TestPingThroughDecorators(t *testing.T) {
+	ctx := context.Background()
+	unavailable := &pingStorage{Storage: memory.NewMemoryStore(), err: errs.ErrUnavailable}
+	store := NewLimitedStorage(NewCachedStorage(unavailable, cache.Options{Size: 1}), DefaultMaxURLLength)
+	assert.ErrorIs(t, Ping(ctx, store), errs.ErrUnavailable)
+
+	// Хранилище без Pinger всегда доступно.
+	assert.NoError(t, Ping(ctx, NewLimitedStorage(memory.NewMemoryStore(), DefaultMaxURLLength)))
+}
@@ -1,61 +1,573 @@
 package memory
 
 import (
+	"cmp"
+	"context"
 	"fmt"
+	"hash/maphash"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
 )
 
+// shardCount — число шардов каждого индекса. Степень двойки, чтобы номер шарда брался маской хеша.
+const shardCount = 64
+
+// storedRecord — запись с порядковым номером создания, по которому Snapshot восстанавливает порядок.
+type storedRecord struct {
+	record models.URLRecord
+	seq    uint64
+}
+
+// recordShard — часть записей, выбираемая по хешу короткого ID.
+type recordShard struct {
+	mu      *sync.RWMutex
+	records map[string]storedRecord
+}
+
+// urlShard — часть обратного индекса, выбираемая по хешу оригинального URL.
+type urlShard struct {
+	mu  *sync.RWMutex
+	ids map[string]string
+}
+
+// userShard — часть индекса ID ссылок по владельцу, выбираемая по хешу ID пользователя.
+type userShard struct {
+	mu  *sync.RWMutex
+	ids map[string][]string
+}
+
+// MemoryStore — хранилище в памяти, оптимизированное для чтения: записи, обратный индекс
+// и индекс по пользователям разбиты на шарды со своими RWMutex, поэтому чтения разных
+// и даже одних и тех же ссылок не блокируют друг друга.
+//
+// Изменения берут блокировки в порядке: шард URL, шард записи, шард пользователя.
+// Блокировка шарда URL делает атомарными проверку уникальности URL и вставку записи.
 type MemoryStore struct {
-	mu          *sync.Mutex
-	URLs        map[string]string
-	reverseURLs map[string]string
+	seed    maphash.Seed
+	seq     *atomic.Uint64
+	records []*recordShard
+	urls    []*urlShard
+	users   []*userShard
 }
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{
-		mu:          &sync.Mutex{},
-		URLs:        make(map[string]string),
-		reverseURLs: make(map[string]string),
+	s := &MemoryStore{
+		seed:    maphash.MakeSeed(),
+		seq:     &atomic.Uint64{},
+		records: make([]*recordShard, shardCount),
+		urls:    make([]*urlShard, shardCount),
+		users:   make([]*userShard, shardCount),
+	}
+	for i := range shardCount {
+		s.records[i] = &recordShard{mu: &sync.RWMutex{}, records: make(map[string]storedRecord)}
+		s.urls[i] = &urlShard{mu: &sync.RWMutex{}, ids: make(map[string]string)}
+		s.users[i] = &userShard{mu: &sync.RWMutex{}, ids: make(map[string][]string)}
+	}
+	return s
+}
+
+func (s *MemoryStore) shardIndex(key string) int {
+	return int(maphash.String(s.seed, key) & (shardCount - 1))
+}
+
+func (s *MemoryStore) recordShard(id string) *recordShard {
+	return s.records[s.shardIndex(id)]
+}
+
+func (s *MemoryStore) urlShard(originalURL string) *urlShard {
+	return s.urls[s.shardIndex(originalURL)]
+}
+
+func (s *MemoryStore) userShard(userID string) *userShard {
+	return s.users[s.shardIndex(userID)]
+}
+
+// lookup возвращает запись по ID под блокировкой чтения её шарда.
+func (s *MemoryStore) lookup(id string) (models.URLRecord, bool) {
+	shard := s.recordShard(id)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	stored, ok := shard.records[id]
+	return stored.record, ok
+}
+
+// liveID возвращает ID, под которым URL сохранён в живой ссылке. Удалённые и просроченные
+// ссылки не занимают URL: его можно сократить заново под новым ID, а старая ссылка
+// по-прежнему отвечает, что удалена или просрочена. Вызывается под блокировкой шарда URL.
+func (s *MemoryStore) liveID(urls *urlShard, originalURL string) (string, bool) {
+	id, ok := urls.ids[originalURL]
+	if !ok {
+		return "", false
+	}
+	record, ok := s.lookup(id)
+	if !ok || record.IsDeleted || record.Expired(time.Now()) {
+		return "", false
+	}
+	return id, true
+}
+
+// insert сохраняет запись, если её ID свободен. Вызывается под блокировкой шарда URL записи,
+// поэтому обратный индекс обновляется без повторной блокировки.
+func (s *MemoryStore) insert(urls *urlShard, record models.URLRecord) bool {
+	shard := s.recordShard(record.ShortID)
+	shard.mu.Lock()
+	if _, ok := shard.records[record.ShortID]; ok {
+		shard.mu.Unlock()
+		return false
+	}
+	shard.records[record.ShortID] = storedRecord{record: record, seq: s.seq.Add(1)}
+	shard.mu.Unlock()
+
+	if _, ok := s.liveID(urls, record.OriginalURL); !ok && !record.IsDeleted {
+		urls.ids[record.OriginalURL] = record.ShortID
+	}
+	if record.UserID != "" {
+		users := s.userShard(record.UserID)
+		users.mu.Lock()
+		users.ids[record.UserID] = append(users.ids[record.UserID], record.ShortID)
+		users.mu.Unlock()
+	}
+	return true
+}
+
+func (s *MemoryStore) SaveID(ctx context.Context, record models.URLRecord) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("save ID canceled: %w", err)
+	}
+
+	urls := s.urlShard(record.OriginalURL)
+	urls.mu.Lock()
+	defer urls.mu.Unlock()
+
+	if _, ok := s.liveID(urls, record.OriginalURL); ok {
+		return fmt.Errorf("%w: %s", errs.ErrURLConflict, record.OriginalURL)
+	}
+	if !s.insert(urls, record) {
+		return fmt.Errorf("%w: %s", errs.ErrIDConflict, record.ShortID)
+	}
+	return nil
+}
+
+// GetOrCreate атомарно возвращает ID уже сохранённого URL или сохраняет запись под её ID.
+// Второе значение сообщает, существовал ли URL до вызова.
+func (s *MemoryStore) GetOrCreate(ctx context.Context, record models.URLRecord) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, fmt.Errorf("get or create canceled: %w", err)
+	}
+
+	return s.getOrCreate(record)
+}
+
+// getOrCreate сохраняет запись, если URL не занят живой ссылкой. Удалённая запись,
+// перенесённая из другого хранилища, URL не занимает и конфликтует только по ID,
+// а если под её ID уже сохранён тот же URL, считается существующей.
+func (s *MemoryStore) getOrCreate(record models.URLRecord) (string, bool, error) {
+	urls := s.urlShard(record.OriginalURL)
+	urls.mu.Lock()
+	defer urls.mu.Unlock()
+
+	if existingID, ok := s.liveID(urls, record.OriginalURL); ok && !record.IsDeleted {
+		return existingID, true, nil
+	}
+	if !s.insert(urls, record) {
+		if existing, ok := s.lookup(record.ShortID); ok && record.IsDeleted && existing.OriginalURL == record.OriginalURL {
+			return record.ShortID, true, nil
+		}
+		return "", false, fmt.Errorf("%w: %s", errs.ErrIDConflict, record.ShortID)
+	}
+	return record.ShortID, false, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (string, error) {
+	originalURL, _, err := s.GetWithExpiry(ctx, id)
+	return originalURL, err
+}
+
+// GetWithExpiry возвращает URL ссылки и момент истечения её срока, нулевой для бессрочной.
+func (s *MemoryStore) GetWithExpiry(ctx context.Context, id string) (string, time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return "", time.Time{}, fmt.Errorf("get URL canceled: %w", err)
+	}
+
+	record, ok := s.lookup(id)
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("ID %s: %w", id, errs.ErrNotFound)
+	}
+	if record.IsDeleted {
+		return "", time.Time{}, fmt.Errorf("ID %s: %w", id, errs.ErrDeleted)
+	}
+	if record.Expired(time.Now()) {
+		return "", time.Time{}, fmt.Errorf("ID %s: %w", id, errs.ErrExpired)
+	}
+	return record.OriginalURL, record.ExpiresAt, nil
+}
+
+func (s *MemoryStore) GetIDByURL(ctx context.Context, originalURL string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("get ID canceled: %w", err)
+	}
+
+	urls := s.urlShard(originalURL)
+	urls.mu.RLock()
+	defer urls.mu.RUnlock()
+	id, ok := s.liveID(urls, originalURL)
+	if !ok {
+		return "", fmt.Errorf("URL %s: %w", originalURL, errs.ErrNotFound)
+	}
+	return id, nil
+}
+
+// GetUserURLs возвращает ссылки, сохранённые пользователем, в порядке создания.
+func (s *MemoryStore) GetUserURLs(ctx context.Context, userID string) ([]models.URLRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("get user URLs canceled: %w", err)
+	}
+
+	users := s.userShard(userID)
+	users.mu.RLock()
+	ids := slices.Clone(users.ids[userID])
+	users.mu.RUnlock()
+
+	now := time.Now()
+	records := make([]models.URLRecord, 0, len(ids))
+	for _, id := range ids {
+		if record, ok := s.lookup(id); ok && !record.IsDeleted && !record.Expired(now) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// DeleteExpired удаляет ссылки, срок жизни которых истёк к моменту now, и возвращает их число.
+func (s *MemoryStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("delete expired canceled: %w", err)
+	}
+
+	return len(s.PurgeExpired(now)), nil
+}
+
+// PurgeExpired удаляет ссылки, срок жизни которых истёк к моменту now, и возвращает их ID.
+// Кандидаты собираются под блокировками чтения, а удаляются в общем порядке блокировок.
+func (s *MemoryStore) PurgeExpired(now time.Time) []string {
+	var expired []models.URLRecord
+	for _, shard := range s.records {
+		shard.mu.RLock()
+		for _, stored := range shard.records {
+			if stored.record.Expired(now) {
+				expired = append(expired, stored.record)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+
+	purged := make([]string, 0, len(expired))
+	for _, record := range expired {
+		if s.remove(record.ShortID, record.OriginalURL, func(stored models.URLRecord) bool {
+			return stored.Expired(now)
+		}) {
+			purged = append(purged, record.ShortID)
+		}
 	}
+	return purged
 }
 
-func (s *MemoryStore) SaveID(id, originalURL string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Remove удаляет только что сохранённую запись, например если её не удалось записать в журнал.
+func (s *MemoryStore) Remove(record models.URLRecord) {
+	s.remove(record.ShortID, record.OriginalURL, func(stored models.URLRecord) bool {
+		return stored.OriginalURL == record.OriginalURL
+	})
+}
+
+// remove удаляет запись из всех индексов, если она всё ещё удовлетворяет match.
+func (s *MemoryStore) remove(id, originalURL string, match func(models.URLRecord) bool) bool {
+	urls := s.urlShard(originalURL)
+	urls.mu.Lock()
+	defer urls.mu.Unlock()
+
+	shard := s.recordShard(id)
+	shard.mu.Lock()
+	stored, ok := shard.records[id]
+	if !ok || !match(stored.record) {
+		shard.mu.Unlock()
+		return false
+	}
+	delete(shard.records, id)
+	shard.mu.Unlock()
+
+	if urls.ids[originalURL] == id {
+		delete(urls.ids, originalURL)
+	}
+	if userID := stored.record.UserID; userID != "" {
+		users := s.userShard(userID)
+		users.mu.Lock()
+		users.ids[userID] = slices.DeleteFunc(users.ids[userID], func(userURLID string) bool {
+			return userURLID == id
+		})
+		if len(users.ids[userID]) == 0 {
+			delete(users.ids, userID)
+		}
+		users.mu.Unlock()
+	}
+	return true
+}
 
-	if _, ok := s.URLs[id]; ok {
-		return fmt.Errorf("ID %s already exists", id)
+// RecordClicks прибавляет накопленные переходы к счётчикам ссылок. Неизвестные ID пропускаются.
+func (s *MemoryStore) RecordClicks(ctx context.Context, clicks []models.ClickStat) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("record clicks canceled: %w", err)
 	}
 
-	s.URLs[id] = originalURL
-	s.reverseURLs[originalURL] = id
+	s.ApplyClicks(clicks)
 	return nil
 }
 
-func (s *MemoryStore) Get(id string) (string, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	originalURL, ok := s.URLs[id]
-	return originalURL, ok
+// ApplyClicks прибавляет переходы к счётчикам и возвращает те из них, что относятся к существующим ссылкам.
+func (s *MemoryStore) ApplyClicks(clicks []models.ClickStat) []models.ClickStat {
+	applied := make([]models.ClickStat, 0, len(clicks))
+	for _, click := range clicks {
+		shard := s.recordShard(click.ShortID)
+		shard.mu.Lock()
+		stored, ok := shard.records[click.ShortID]
+		if ok {
+			stored.record.Clicks += click.Clicks
+			if click.LastAccessedAt.After(stored.record.LastAccessedAt) {
+				stored.record.LastAccessedAt = click.LastAccessedAt
+			}
+			shard.records[click.ShortID] = stored
+			applied = append(applied, click)
+		}
+		shard.mu.Unlock()
+	}
+	return applied
 }
 
-func (s *MemoryStore) GetIDByURL(originalURL string) (string, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	id, ok := s.reverseURLs[originalURL]
-	return id, ok
+// SetClicks заменяет статистику переходов по ссылкам, например чтобы откатить ApplyClicks,
+// если приращения не удалось сохранить. Неизвестные ID пропускаются.
+func (s *MemoryStore) SetClicks(stats []models.ClickStat) {
+	for _, stat := range stats {
+		shard := s.recordShard(stat.ShortID)
+		shard.mu.Lock()
+		if stored, ok := shard.records[stat.ShortID]; ok {
+			stored.record.Clicks, stored.record.LastAccessedAt = stat.Clicks, stat.LastAccessedAt
+			shard.records[stat.ShortID] = stored
+		}
+		shard.mu.Unlock()
+	}
 }
 
-func (s *MemoryStore) SaveBatch(pairs map[string]string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// GetStats возвращает статистику переходов по ссылке.
+func (s *MemoryStore) GetStats(ctx context.Context, id string) (models.ClickStat, error) {
+	if err := ctx.Err(); err != nil {
+		return models.ClickStat{}, fmt.Errorf("get stats canceled: %w", err)
+	}
+
+	record, ok := s.lookup(id)
+	if !ok {
+		return models.ClickStat{}, fmt.Errorf("ID %s: %w", id, errs.ErrNotFound)
+	}
+	return models.ClickStat{ShortID: id, Clicks: record.Clicks, LastAccessedAt: record.LastAccessedAt}, nil
+}
+
+// DeleteURLs помечает удалёнными ссылки, принадлежащие авторам запросов.
+// Чужие, несуществующие и уже удалённые ссылки пропускаются.
+func (s *MemoryStore) DeleteURLs(ctx context.Context, requests []models.DeleteRequest) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("delete URLs canceled: %w", err)
+	}
+
+	s.MarkDeleted(requests)
+	return nil
+}
+
+// MarkDeleted помечает удалёнными ссылки, принадлежащие авторам запросов,
+// освобождает их URL в обратном индексе и возвращает запросы, которые действительно что-то удалили.
+func (s *MemoryStore) MarkDeleted(requests []models.DeleteRequest) []models.DeleteRequest {
+	applied := make([]models.DeleteRequest, 0, len(requests))
+	for _, request := range requests {
+		shard := s.recordShard(request.ShortID)
+		shard.mu.Lock()
+		stored, ok := shard.records[request.ShortID]
+		deleted := ok && !stored.record.IsDeleted && request.UserID != "" && stored.record.UserID == request.UserID
+		if deleted {
+			stored.record.IsDeleted = true
+			shard.records[request.ShortID] = stored
+			applied = append(applied, request)
+		}
+		shard.mu.Unlock()
+
+		// Шард URL блокируется после шарда записи, поэтому индекс освобождается отдельно,
+		// если URL тем временем не сократили заново.
+		if deleted {
+			urls := s.urlShard(stored.record.OriginalURL)
+			urls.mu.Lock()
+			if urls.ids[stored.record.OriginalURL] == request.ShortID {
+				delete(urls.ids, stored.record.OriginalURL)
+			}
+			urls.mu.Unlock()
+		}
+	}
+	return applied
+}
+
+// UnmarkDeleted снимает пометки удаления, поставленные MarkDeleted, например если их
+// не удалось сохранить. Индекс снова указывает на ссылку, если URL тем временем не сократили заново.
+func (s *MemoryStore) UnmarkDeleted(requests []models.DeleteRequest) {
+	for _, request := range requests {
+		shard := s.recordShard(request.ShortID)
+		shard.mu.Lock()
+		stored, ok := shard.records[request.ShortID]
+		restored := ok && stored.record.IsDeleted
+		if restored {
+			stored.record.IsDeleted = false
+			shard.records[request.ShortID] = stored
+		}
+		shard.mu.Unlock()
+
+		if restored {
+			urls := s.urlShard(stored.record.OriginalURL)
+			urls.mu.Lock()
+			if _, ok := s.liveID(urls, stored.record.OriginalURL); !ok {
+				urls.ids[stored.record.OriginalURL] = request.ShortID
+			}
+			urls.mu.Unlock()
+		}
+	}
+}
 
-	for id, originalURL := range pairs {
-		if _, ok := s.URLs[id]; ok {
-			return fmt.Errorf("ID %s already exists", id)
+// SaveBatch сохраняет пакет записей и возвращает результат для каждой из них в том же порядке.
+// Повторы URL внутри пакета получают ID первого вхождения. Каждая запись сохраняется атомарно,
+// но пакет целиком — нет: параллельные запросы могут видеть его частично сохранённым.
+func (s *MemoryStore) SaveBatch(ctx context.Context, records []models.URLRecord) ([]models.BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("save batch canceled: %w", err)
+	}
+
+	results := make([]models.BatchResult, len(records))
+	for i, record := range records {
+		id, existed, err := s.getOrCreate(record)
+		switch {
+		case err != nil:
+			results[i] = models.BatchResult{Status: models.BatchFailed, Err: err}
+		case existed:
+			results[i] = models.BatchResult{ShortID: id, Status: models.BatchExisted}
+		default:
+			results[i] = models.BatchResult{ShortID: id, Status: models.BatchCreated}
+		}
+	}
+	return results, nil
+}
+
+// Restore восстанавливает запись из журнала без проверок уникальности.
+// Если URL уже сохранён под другим ID живой ссылки, обратный индекс указывает на первый из них.
+func (s *MemoryStore) Restore(record models.URLRecord) {
+	urls := s.urlShard(record.OriginalURL)
+	urls.mu.Lock()
+	defer urls.mu.Unlock()
+
+	s.insert(urls, record)
+}
+
+// Len возвращает число хранимых записей, включая удалённые и ещё не вычищенные просроченные.
+func (s *MemoryStore) Len() int {
+	var n int
+	for _, shard := range s.records {
+		shard.mu.RLock()
+		n += len(shard.records)
+		shard.mu.RUnlock()
+	}
+	return n
+}
+
+// Snapshot возвращает копию всех хранимых записей, включая удалённые, в порядке создания,
+// чтобы Restore восстановил тот же порядок ссылок пользователей.
+// Снимок согласован, только если на время вызова изменения остановлены.
+func (s *MemoryStore) Snapshot() []models.URLRecord {
+	var stored []storedRecord
+	for _, shard := range s.records {
+		shard.mu.RLock()
+		for _, record := range shard.records {
+			stored = append(stored, record)
+		}
+		shard.mu.RUnlock()
+	}
+
+	slices.SortFunc(stored, func(a, b storedRecord) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	records := make([]models.URLRecord, len(stored))
+	for i, record := range stored {
+		records[i] = record.record
+	}
+	return records
+}
+
+// ForEachID вызывает fn для ID каждой хранимой записи, включая удалённые, и прекращает обход
+// при первой ошибке fn. Шард блокируется только на время копирования его ID.
+func (s *MemoryStore) ForEachID(ctx context.Context, fn func(id string) error) error {
+	for _, shard := range s.records {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("iterate IDs canceled: %w", err)
+		}
+
+		shard.mu.RLock()
+		ids := make([]string, 0, len(shard.records))
+		for id := range shard.records {
+			ids = append(ids, id)
+		}
+		shard.mu.RUnlock()
+
+		for _, id := range ids {
+			if err := fn(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush ничего не делает: данные хранятся только в памяти.
+func (s *MemoryStore) Flush(context.Context) error {
+	return nil
+}
+
+// Close ничего не делает: у хранилища в памяти нет внешних ресурсов.
+func (s *MemoryStore) Close(context.Context) error {
+	return nil
+}
+
+// Iterate вызывает fn для каждой записи с ID больше afterID в порядке возрастания ID.
+// Сортируются только ID, а записи читаются по одной, поэтому обход не блокирует хранилище.
+func (s *MemoryStore) Iterate(ctx context.Context, afterID string, fn func(models.URLRecord) error) error {
+	var ids []string
+	if err := s.ForEachID(ctx, func(id string) error {
+		if id > afterID {
+			ids = append(ids, id)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	slices.Sort(ids)
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("iterate records canceled: %w", err)
+		}
+		// Запись могла быть вычищена после сбора ID.
+		record, ok := s.lookup(id)
+		if !ok {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
 		}
-		s.URLs[id] = originalURL
-		s.reverseURLs[originalURL] = id
 	}
 	return nil
 }
@@ -0,0 +1,169 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentGetOrCreate(t *testing.T) {
+	const workers = 32
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	// Все горутины сокращают один URL под разными ID: сохраниться должна ровно одна запись.
+	ids := make([]string, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, _, err := store.GetOrCreate(ctx, models.URLRecord{
+				ShortID:     fmt.Sprintf("id%d", i),
+				OriginalURL: "https://example.com",
+				UserID:      "user",
+			})
+			assert.NoError(t, err)
+			ids[i] = id
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		assert.Equal(t, ids[0], id)
+	}
+	assert.Equal(t, 1, store.Len())
+	records, err := store.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+}
+
+func TestPurgeExpired(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+
+	require.NoError(t, store.SaveID(ctx, models.URLRecord{
+		ShortID: "old", OriginalURL: "https://example.com/old", UserID: "user", ExpiresAt: now.Add(-time.Minute),
+	}))
+	require.NoError(t, store.SaveID(ctx, models.URLRecord{
+		ShortID: "live", OriginalURL: "https://example.com/live", UserID: "user",
+	}))
+
+	assert.Equal(t, []string{"old"}, store.PurgeExpired(now))
+	_, err := store.Get(ctx, "old")
+	require.ErrorIs(t, err, errs.ErrNotFound)
+	_, err = store.GetIDByURL(ctx, "https://example.com/old")
+	require.ErrorIs(t, err, errs.ErrNotFound)
+
+	// После вычистки URL можно сократить заново.
+	require.NoError(t, store.SaveID(ctx, models.URLRecord{ShortID: "new", OriginalURL: "https://example.com/old"}))
+
+	snapshot := store.Snapshot()
+	require.Len(t, snapshot, 2)
+	assert.Equal(t, "live", snapshot[0].ShortID)
+	assert.Equal(t, "new", snapshot[1].ShortID)
+}
+
+func newBenchmarkStore(b *testing.B, count int) *MemoryStore {
+	b.Helper()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	for i := range count {
+		err := store.SaveID(ctx, models.URLRecord{
+			ShortID:     fmt.Sprintf("id%d", i),
+			OriginalURL: fmt.Sprintf("https://example.com/%d", i),
+			UserID:      fmt.Sprintf("user%d", i%100),
+		})
+		require.NoError(b, err)
+	}
+	return store
+}
+
+// runReaders делит b.N вызовов read между readers горутинами.
+func runReaders(b *testing.B, readers int, read func(i int)) {
+	b.Helper()
+
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for r := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := r; i < b.N; i += readers {
+				read(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkGet(b *testing.B) {
+	const count = 10000
+	ctx := context.Background()
+	store := newBenchmarkStore(b, count)
+	ids := make([]string, count)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("id%d", i)
+	}
+
+	for _, readers := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("readers=%d", readers), func(b *testing.B) {
+			runReaders(b, readers, func(i int) {
+				if _, err := store.Get(ctx, ids[i%count]); err != nil {
+					b.Error(err)
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkGetWithWriter измеряет чтения на фоне непрерывных переходов и новых ссылок.
+func BenchmarkGetWithWriter(b *testing.B) {
+	const count = 10000
+	ctx := context.Background()
+	ids := make([]string, count)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("id%d", i)
+	}
+
+	for _, readers := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("readers=%d", readers), func(b *testing.B) {
+			store := newBenchmarkStore(b, count)
+			stop := make(chan struct{})
+			writerDone := make(chan struct{})
+			go func() {
+				defer close(writerDone)
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					store.ApplyClicks([]models.ClickStat{{ShortID: ids[i%count], Clicks: 1}})
+					_, _, _ = store.GetOrCreate(ctx, models.URLRecord{
+						ShortID:     fmt.Sprintf("w%d", i),
+						OriginalURL: fmt.Sprintf("https://example.com/w%d", i),
+					})
+				}
+			}()
+
+			runReaders(b, readers, func(i int) {
+				if _, err := store.Get(ctx, ids[i%count]); err != nil {
+					b.Error(err)
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			<-writerDone
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockID — ключ advisory-блокировки, под которой применяются миграции,
+// чтобы несколько экземпляров сервиса не выполняли их одновременно.
+const migrationLockID int64 = 7262349001
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration — версия схемы с SQL для применения и отката.
+type Migration struct {
+	Name    string
+	Up      string
+	Down    string
+	Version int64
+}
+
+// MigrationStatus — состояние миграции в базе данных.
+type MigrationStatus struct {
+	AppliedAt time.Time
+	Name      string
+	Version   int64
+	Applied   bool
+}
+
+// Migrator применяет и откатывает встроенные миграции схемы.
+type Migrator struct {
+	pool       *pgxpool.Pool
+	logger     logger.Logger
+	migrations []Migration
+}
+
+// NewMigrator создаёт Migrator со встроенными в бинарник миграциями.
+func NewMigrator(pool *pgxpool.Pool, parentLogger logger.Logger) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{pool: pool, logger: parentLogger, migrations: migrations}, nil
+}
+
+// loadMigrations читает пары файлов <версия>_<имя>.up.sql и .down.sql и сортирует их по версии.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileRe.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file name %q", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up применяет все ещё не применённые миграции по возрастанию версии.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration.Up, func(tx pgx.Tx) error {
+				query := `INSERT INTO schema_migrations (version, name) VALUES ($1, $2);`
+				if _, err := tx.Exec(ctx, query, migration.Version, migration.Name); err != nil {
+					return fmt.Errorf("failed to record version: %w", classifyError(err))
+				}
+				return nil
+			}); err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			m.logger.Info("Migration applied",
+				zap.Int64("version", migration.Version), zap.String("name", migration.Name))
+		}
+		return nil
+	})
+}
+
+// Down откатывает steps последних применённых миграций.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	known := make(map[int64]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, version := range versions[:min(steps, len(versions))] {
+			migration, ok := known[version]
+			if !ok || migration.Down == "" {
+				return fmt.Errorf("no down script for migration %d", version)
+			}
+			if err := m.apply(ctx, conn, migration.Down, func(tx pgx.Tx) error {
+				query := `DELETE FROM schema_migrations WHERE version = $1;`
+				if _, err := tx.Exec(ctx, query, version); err != nil {
+					return fmt.Errorf("failed to remove version: %w", classifyError(err))
+				}
+				return nil
+			}); err != nil {
+				return fmt.Errorf("failed to roll back migration %d_%s: %w", version, migration.Name, err)
+			}
+			m.logger.Info("Migration rolled back",
+				zap.Int64("version", version), zap.String("name", migration.Name))
+		}
+		return nil
+	})
+}
+
+// Status возвращает состояние всех известных и применённых миграций по возрастанию версии.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if a, ok := applied[migration.Version]; ok {
+				status.Applied = true
+				status.AppliedAt = a.AppliedAt
+				delete(applied, migration.Version)
+			}
+			statuses = append(statuses, status)
+		}
+		// Миграции, применённые более новой версией сервиса.
+		for _, a := range applied {
+			statuses = append(statuses, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// withLock выполняет fn на одном соединении под advisory-блокировкой,
+// предварительно создав таблицу версий.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", classifyError(err))
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1);`, migrationLockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", classifyError(err))
+	}
+	defer func() {
+		// Снимаем блокировку даже при отменённом контексте, иначе она останется до закрытия соединения.
+		unlockCtx := context.WithoutCancel(ctx)
+		if _, err := conn.Exec(unlockCtx, `SELECT pg_advisory_unlock($1);`, migrationLockID); err != nil {
+			m.logger.Error("Failed to release migration lock", zap.Error(err))
+		}
+	}()
+
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	`
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", classifyError(err))
+	}
+
+	return fn(conn)
+}
+
+// apply выполняет скрипт миграции и обновление таблицы версий в одной транзакции.
+func (m *Migrator) apply(ctx context.Context, conn *pgxpool.Conn, script string, record func(tx pgx.Tx) error) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", classifyError(err))
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			m.logger.Error("Failed to rollback transaction: %v\n", zap.Error(err))
+		}
+	}()
+
+	// Миграция может переписывать всю таблицу, поэтому ограничение времени запроса из настроек
+	// пула на неё не распространяется.
+	if _, err := tx.Exec(ctx, `SET LOCAL statement_timeout = 0;`); err != nil {
+		return fmt.Errorf("failed to disable statement timeout: %w", classifyError(err))
+	}
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return fmt.Errorf("failed to execute script: %w", classifyError(err))
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", classifyError(err))
+	}
+	return nil
+}
+
+func appliedMigrations(ctx context.Context, conn *pgxpool.Conn) (map[int64]MigrationStatus, error) {
+	rows, err := conn.Query(ctx, `SELECT version, name, applied_at FROM schema_migrations;`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", classifyError(err))
+	}
+
+	statuses, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (MigrationStatus, error) {
+		status := MigrationStatus{Applied: true}
+		if err := row.Scan(&status.Version, &status.Name, &status.AppliedAt); err != nil {
+			return status, fmt.Errorf("failed to scan row: %w", err)
+		}
+		return status, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", classifyError(err))
+	}
+
+	applied := make(map[int64]MigrationStatus, len(statuses))
+	for _, status := range statuses {
+		applied[status.Version] = status
+	}
+	return applied, nil
+}
@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name             string
+		files            fstest.MapFS
+		expectedVersions []int64
+		expectError      bool
+	}{
+		{
+			name: "Sorted by version",
+			files: fstest.MapFS{
+				"m/0010_b.up.sql":   {Data: []byte("SELECT 10;")},
+				"m/0002_a.up.sql":   {Data: []byte("SELECT 2;")},
+				"m/0002_a.down.sql": {Data: []byte("SELECT -2;")},
+			},
+			expectedVersions: []int64{2, 10},
+		},
+		{
+			name:        "Unexpected file name",
+			files:       fstest.MapFS{"m/readme.txt": {Data: []byte("")}},
+			expectError: true,
+		},
+		{
+			name:        "Down without up",
+			files:       fstest.MapFS{"m/0001_a.down.sql": {Data: []byte("SELECT 1;")}},
+			expectError: true,
+		},
+		{
+			name: "Conflicting names",
+			files: fstest.MapFS{
+				"m/0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+				"m/0001_b.down.sql": {Data: []byte("SELECT 1;")},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files, "m")
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			versions := make([]int64, 0, len(migrations))
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+			}
+			assert.Equal(t, tt.expectedVersions, versions)
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationsFS, "migrations")
+	assert.NoError(t, err)
+
+	// Версии идут подряд, и у каждой миграции есть откат.
+	for i, m := range migrations {
+		assert.Equal(t, int64(i+1), m.Version, "unexpected migration version")
+		assert.NotEmpty(t, m.Down, "migration %d_%s has no down script", m.Version, m.Name)
+	}
+}
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS urls (
+    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    short_id VARCHAR(12) UNIQUE NOT NULL,
+    original_url VARCHAR(255) UNIQUE NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE urls DROP COLUMN IF EXISTS last_accessed_at;
+ALTER TABLE urls DROP COLUMN IF EXISTS clicks;
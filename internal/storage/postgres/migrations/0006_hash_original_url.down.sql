@@ -0,0 +1,5 @@
+-- Откат не удастся, если уже сохранены URL длиннее 255 символов.
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_original_url_hash_key;
+ALTER TABLE urls DROP COLUMN IF EXISTS original_url_hash;
+ALTER TABLE urls ALTER COLUMN original_url TYPE VARCHAR(255);
+ALTER TABLE urls ADD CONSTRAINT urls_original_url_key UNIQUE (original_url);
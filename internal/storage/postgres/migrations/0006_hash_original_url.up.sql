@@ -0,0 +1,8 @@
+-- Индекс B-дерева не принимает значения длиннее трети страницы, поэтому уникальность
+-- URL проверяется по его хешу, а сам URL хранится без ограничения длины.
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_original_url_key;
+ALTER TABLE urls ALTER COLUMN original_url TYPE TEXT;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS original_url_hash BYTEA;
+UPDATE urls SET original_url_hash = sha256(convert_to(original_url, 'UTF8')) WHERE original_url_hash IS NULL;
+ALTER TABLE urls ALTER COLUMN original_url_hash SET NOT NULL;
+ALTER TABLE urls ADD CONSTRAINT urls_original_url_hash_key UNIQUE (original_url_hash);
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS urls_notify_truncate ON urls;
+DROP TRIGGER IF EXISTS urls_notify_delete ON urls;
+DROP TRIGGER IF EXISTS urls_notify_update ON urls;
+DROP TRIGGER IF EXISTS urls_notify_insert ON urls;
+DROP FUNCTION IF EXISTS notify_urls_changes();
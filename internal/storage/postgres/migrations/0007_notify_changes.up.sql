@@ -0,0 +1,63 @@
+-- Оповещает экземпляры сервиса об изменённых ссылках, чтобы они сбросили локальные кеши.
+-- Оповещение отправляется при фиксации транзакции изменения и содержит JSON-массив short_id.
+-- Размер оповещения ограничен 8000 байт, поэтому о крупных изменениях, как и об очистке
+-- таблицы, сообщает пустое оповещение: подписчики сбрасывают кеши целиком.
+CREATE OR REPLACE FUNCTION notify_urls_changes() RETURNS trigger AS $$
+DECLARE
+    max_ids CONSTANT INT := 500;
+    max_payload CONSTANT INT := 7900;
+    changed TEXT[];
+    payload TEXT := '';
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        SELECT array_agg(short_id) INTO changed
+        FROM (SELECT short_id FROM new_urls LIMIT max_ids + 1) AS ids;
+    ELSIF TG_OP = 'UPDATE' THEN
+        -- Счётчики переходов меняются постоянно и на кеши не влияют.
+        SELECT array_agg(short_id) INTO changed
+        FROM (
+            SELECT new_urls.short_id FROM new_urls JOIN old_urls ON old_urls.id = new_urls.id
+            WHERE (old_urls.short_id, old_urls.original_url, old_urls.is_deleted, old_urls.expires_at)
+                IS DISTINCT FROM (new_urls.short_id, new_urls.original_url, new_urls.is_deleted, new_urls.expires_at)
+            LIMIT max_ids + 1
+        ) AS ids;
+    ELSIF TG_OP = 'DELETE' THEN
+        SELECT array_agg(short_id) INTO changed
+        FROM (SELECT short_id FROM old_urls LIMIT max_ids + 1) AS ids;
+    END IF;
+
+    IF TG_OP <> 'TRUNCATE' THEN
+        IF changed IS NULL THEN
+            RETURN NULL;
+        END IF;
+        IF cardinality(changed) <= max_ids THEN
+            payload := array_to_json(changed)::TEXT;
+        END IF;
+        IF octet_length(payload) > max_payload THEN
+            payload := '';
+        END IF;
+    END IF;
+
+    PERFORM pg_notify('urls_changes', payload);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS urls_notify_insert ON urls;
+CREATE TRIGGER urls_notify_insert AFTER INSERT ON urls
+    REFERENCING NEW TABLE AS new_urls
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_urls_changes();
+
+DROP TRIGGER IF EXISTS urls_notify_update ON urls;
+CREATE TRIGGER urls_notify_update AFTER UPDATE ON urls
+    REFERENCING OLD TABLE AS old_urls NEW TABLE AS new_urls
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_urls_changes();
+
+DROP TRIGGER IF EXISTS urls_notify_delete ON urls;
+CREATE TRIGGER urls_notify_delete AFTER DELETE ON urls
+    REFERENCING OLD TABLE AS old_urls
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_urls_changes();
+
+DROP TRIGGER IF EXISTS urls_notify_truncate ON urls;
+CREATE TRIGGER urls_notify_truncate AFTER TRUNCATE ON urls
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_urls_changes();
@@ -0,0 +1,8 @@
+-- Из копий одного URL остаётся живая ссылка, а если её нет — последняя удалённая.
+DROP INDEX IF EXISTS urls_original_url_hash_key;
+DELETE FROM urls AS d
+WHERE d.is_deleted AND EXISTS (
+    SELECT 1 FROM urls AS o
+    WHERE o.original_url_hash = d.original_url_hash AND o.id <> d.id AND (NOT o.is_deleted OR o.id > d.id)
+);
+ALTER TABLE urls ADD CONSTRAINT urls_original_url_hash_key UNIQUE (original_url_hash);
@@ -0,0 +1,4 @@
+-- Удалённая ссылка не занимает URL: его можно сократить заново под новым ID,
+-- а удалённая ссылка продолжает отвечать, что удалена.
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_original_url_hash_key;
+CREATE UNIQUE INDEX IF NOT EXISTS urls_original_url_hash_key ON urls (original_url_hash) WHERE NOT is_deleted;
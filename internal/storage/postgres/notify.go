@@ -0,0 +1,192 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// changesChannel — канал, в который триггеры таблицы urls публикуют изменённые ID.
+const changesChannel = "urls_changes"
+
+// Настройки соединения, на котором слушаются изменения.
+const (
+	// listenerPingInterval — время без оповещений, после которого соединение проверяется:
+	// иначе разрыв без закрытия TCP-соединения остался бы незамеченным.
+	listenerPingInterval = 30 * time.Second
+	listenerPingTimeout  = 5 * time.Second
+	listenerDialTimeout  = 10 * time.Second
+	listenerBackoff      = time.Second
+	listenerMaxBackoff   = 30 * time.Second
+)
+
+// changeListener слушает оповещения об изменениях на выделенном соединении
+// и переподключается при его потере.
+type changeListener struct {
+	config *pgx.ConnConfig
+	logger logger.Logger
+	handle func(models.URLChange)
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Subscribe начинает слушать изменения ссылок, сделанные любым экземпляром сервиса,
+// и передаёт их fn, последовательно из одной горутины. Оповещения отправляют триггеры
+// таблицы urls при фиксации изменения, поэтому fn не видит неудавшихся изменений,
+// но видит изменения этого же экземпляра.
+//
+// При потере соединения хранилище передаёт fn изменение с Lost, переподключается в фоне
+// и передаёт fn сброс: оповещения за время разрыва потеряны. Если первое подключение
+// не удалось, Subscribe так же передаёт fn изменение с Lost, возвращает ошибку и повторяет
+// подключение в фоне. Повторный вызов возвращает ошибку.
+//
+// Сброшенная запись может быть тут же прочитана с реплики, ещё не получившей изменение,
+// поэтому при чтении с реплик кеши могут отставать на время отставания реплик.
+func (p *PostgresStore) Subscribe(fn func(models.URLChange)) error {
+	if p.listener != nil {
+		return errors.New("already subscribed to changes")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.listener = &changeListener{
+		config: p.conn.Config().ConnConfig.Copy(),
+		logger: p.logger.Named("Listener"),
+		handle: fn,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	conn, err := p.listener.connect(ctx)
+	if err != nil {
+		fn(models.URLChange{Lost: true})
+	}
+	go p.listener.run(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("failed to listen for changes: %w", classifyError(err))
+	}
+	return nil
+}
+
+// Close прекращает слушать изменения и дожидается завершения обработчика.
+func (l *changeListener) Close(ctx context.Context) error {
+	l.cancel()
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("close listener canceled: %w", ctx.Err())
+	}
+}
+
+// run обрабатывает оповещения до отмены ctx. Если conn равно nil, сначала подключается.
+func (l *changeListener) run(ctx context.Context, conn *pgx.Conn) {
+	defer close(l.done)
+
+	for {
+		if conn == nil {
+			conn = l.reconnect(ctx)
+			if conn == nil {
+				return
+			}
+			l.logger.Info("Listening for changes again, resetting caches")
+			l.handle(models.URLChange{Reset: true})
+		}
+
+		err := l.listen(ctx, conn)
+		closeCtx, cancel := context.WithTimeout(context.Background(), listenerPingTimeout)
+		_ = conn.Close(closeCtx)
+		cancel()
+		conn = nil
+		if ctx.Err() != nil {
+			return
+		}
+		l.logger.Warn("Lost connection for change notifications", zap.Error(err))
+		l.handle(models.URLChange{Lost: true})
+	}
+}
+
+// connect устанавливает соединение и подписывается на канал изменений.
+func (l *changeListener) connect(ctx context.Context) (*pgx.Conn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, listenerDialTimeout)
+	defer cancel()
+
+	conn, err := pgx.ConnectConfig(dialCtx, l.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	if _, err := conn.Exec(dialCtx, "LISTEN "+changesChannel); err != nil {
+		_ = conn.Close(context.Background())
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	return conn, nil
+}
+
+// reconnect подключается, пока не получится или не будет отменён ctx.
+// При отмене возвращает nil.
+func (l *changeListener) reconnect(ctx context.Context) *pgx.Conn {
+	retry := RetryOptions{MaxAttempts: math.MaxInt, InitialBackoff: listenerBackoff, MaxBackoff: listenerMaxBackoff}
+	var conn *pgx.Conn
+	err := retry.do(ctx, func() error {
+		var err error
+		conn, err = l.connect(ctx)
+		return err
+	}, func(error) bool {
+		return ctx.Err() == nil
+	}, func(attempt int, err error) {
+		l.logger.Warn("Failed to listen for changes, retrying", zap.Int("attempt", attempt), zap.Error(err))
+	})
+	if err != nil {
+		return nil
+	}
+	return conn
+}
+
+// listen передаёт оповещения обработчику, пока соединение живо, и возвращает ошибку, из-за которой
+// слушать дальше нельзя. Если оповещений долго нет, соединение проверяется запросом.
+func (l *changeListener) listen(ctx context.Context, conn *pgx.Conn) error {
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, listenerPingInterval)
+		notification, err := conn.WaitForNotification(waitCtx)
+		cancel()
+
+		if err != nil {
+			// Истечение ожидания соединение не закрывает.
+			if ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("failed to wait for notification: %w", err)
+			}
+			pingCtx, cancel := context.WithTimeout(ctx, listenerPingTimeout)
+			err = conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("failed to ping: %w", err)
+			}
+			continue
+		}
+
+		change, err := parseChange(notification.Payload)
+		if err != nil {
+			l.logger.Warn("Malformed change notification, resetting caches", zap.Error(err))
+		}
+		l.handle(change)
+	}
+}
+
+// parseChange разбирает оповещение триггера: JSON-массив изменённых ID или пустую строку,
+// если изменений слишком много. Нераспознанное оповещение тоже означает сброс.
+func parseChange(payload string) (models.URLChange, error) {
+	if payload == "" {
+		return models.URLChange{Reset: true}, nil
+	}
+	var ids []string
+	if err := json.Unmarshal([]byte(payload), &ids); err != nil {
+		return models.URLChange{Reset: true}, fmt.Errorf("failed to parse change notification: %w", err)
+	}
+	return models.URLChange{ShortIDs: ids}, nil
+}
@@ -0,0 +1,28 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseChange(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     string
+		expected    models.URLChange
+		expectError bool
+	}{
+		{name: "IDs", payload: `["a","b"]`, expected: models.URLChange{ShortIDs: []string{"a", "b"}}},
+		{name: "Too many changes", payload: "", expected: models.URLChange{Reset: true}},
+		{name: "Malformed", payload: "a,b", expected: models.URLChange{Reset: true}, expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change, err := parseChange(tt.payload)
+			assert.Equal(t, tt.expected, change)
+			assert.Equal(t, tt.expectError, err != nil)
+		})
+	}
+}
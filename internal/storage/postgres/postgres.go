@@ -4,105 +4,548 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
 )
 
+// Options — настройки хранилища.
+type Options struct {
+	// CopyThreshold — размер пакета, начиная с которого SaveBatch загружает записи через COPY.
+	// Нулевое значение отключает загрузку через COPY.
+	CopyThreshold int
+	// ReplicaDSNs — строки подключения реплик, на которые направляются Get и GetIDByURL.
+	ReplicaDSNs []string
+	// ReplicaCheckInterval — период проверки доступности реплик.
+	ReplicaCheckInterval time.Duration
+	// ReadYourWritesWindow — время после сохранения ссылки, в течение которого чтения того же
+	// клиента, см. WithClient, идут на основной сервер. Нулевое значение отключает закрепление.
+	ReadYourWritesWindow time.Duration
+	// Pool — настройки пулов основного сервера и реплик.
+	Pool PoolOptions
+	// ConnectRetry — повторы проверки соединения при запуске.
+	ConnectRetry RetryOptions
+	// QueryRetry — повторы идемпотентных запросов при временных ошибках.
+	QueryRetry RetryOptions
+}
+
+// DefaultOptions возвращает настройки хранилища по умолчанию. При запуске база ожидается
+// около минуты, идемпотентные запросы выполняются не более трёх раз.
+func DefaultOptions() Options {
+	const (
+		defaultCopyThreshold        = 1000
+		defaultReplicaCheckInterval = 5 * time.Second
+		defaultMaxConns             = 10
+		defaultConnectAttempts      = 10
+		defaultConnectBackoff       = 500 * time.Millisecond
+		defaultConnectMaxBackoff    = 10 * time.Second
+		defaultQueryAttempts        = 3
+		defaultQueryBackoff         = 50 * time.Millisecond
+		defaultQueryMaxBackoff      = time.Second
+	)
+	return Options{
+		CopyThreshold:        defaultCopyThreshold,
+		ReplicaCheckInterval: defaultReplicaCheckInterval,
+		Pool:                 PoolOptions{MaxConns: defaultMaxConns},
+		ConnectRetry: RetryOptions{
+			MaxAttempts:    defaultConnectAttempts,
+			InitialBackoff: defaultConnectBackoff,
+			MaxBackoff:     defaultConnectMaxBackoff,
+		},
+		QueryRetry: RetryOptions{
+			MaxAttempts:    defaultQueryAttempts,
+			InitialBackoff: defaultQueryBackoff,
+			MaxBackoff:     defaultQueryMaxBackoff,
+		},
+	}
+}
+
 type PostgresStore struct {
 	conn   *pgxpool.Pool
 	logger logger.Logger
+	// replicas равен nil, если реплики не заданы.
+	replicas *replicaSet
+	// listener равен nil до вызова Subscribe.
+	listener *changeListener
+	options  Options
 }
 
-func NewPostgresStore(dsn string, parentLogger logger.Logger) (*PostgresStore, error) {
-	config, err := pgxpool.ParseConfig(dsn)
+func NewPostgresStore(dsn string, options Options, parentLogger logger.Logger) (*PostgresStore, error) {
+	pool, err := Connect(context.Background(), dsn, options, parentLogger)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse database DSN: %w", err)
+		return nil, err
 	}
 
-	pool, err := pgxpool.New(context.Background(), config.ConnString())
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	store := &PostgresStore{
+		conn:    pool,
+		logger:  parentLogger,
+		options: options,
 	}
 
-	pool.Config().MaxConns = 10
-
-	store := &PostgresStore{
-		conn:   pool,
-		logger: parentLogger,
+	migrator, err := NewMigrator(pool, parentLogger)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
 	}
 
-	if err := store.createSchema(); err != nil {
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	if len(options.ReplicaDSNs) > 0 {
+		store.replicas, err = newReplicaSet(options.ReplicaDSNs, options, parentLogger.Named("Replicas"))
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
 	}
 
 	return store, nil
 }
 
-func (p *PostgresStore) createSchema() error {
+// readRow выполняет запрос одной строки на доступной реплике, а если реплик нет, клиент
+// закреплён за основным сервером или реплика не ответила, — на основном сервере.
+// Ошибки запроса, кроме недоступности реплики, возвращаются без повтора.
+func (p *PostgresStore) readRow(ctx context.Context, query string, args []any, dest ...any) error {
+	if p.replicas != nil {
+		if r := p.replicas.pick(ctx); r != nil {
+			err := r.pool.QueryRow(ctx, query, args...).Scan(dest...)
+			if err == nil || !replicaFailed(err) {
+				return err
+			}
+			p.replicas.markDown(r, err)
+		}
+	}
+	return p.conn.QueryRow(ctx, query, args...).Scan(dest...)
+}
+
+// pinWriter закрепляет автора записи из ctx за основным сервером, если реплики заданы.
+func (p *PostgresStore) pinWriter(ctx context.Context) {
+	if p.replicas != nil {
+		p.replicas.pin(ctx)
+	}
+}
+
+// retireExpiredQuery удаляет просроченные живые ссылки на URL из $1, как их удалил бы
+// DeleteExpired: просроченная ссылка не занимает URL, и его можно сократить заново.
+const retireExpiredQuery = `
+DELETE FROM urls
+WHERE original_url_hash IN (SELECT sha256(convert_to(u, 'UTF8')) FROM unnest($1::text[]) AS u)
+	AND NOT is_deleted AND expires_at <= now();
+`
+
+func (p *PostgresStore) SaveID(ctx context.Context, record models.URLRecord) error {
 	query := `
-	CREATE TABLE IF NOT EXISTS urls (
-		id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
-		short_id VARCHAR(12) UNIQUE NOT NULL,
-		original_url VARCHAR(255) UNIQUE NOT NULL
-	);
+	INSERT INTO urls (short_id, original_url, original_url_hash, user_id, expires_at)
+	VALUES ($1, $2, sha256(convert_to($2, 'UTF8')), NULLIF($3, ''), $4);
 	`
-	if _, err := p.conn.Exec(context.Background(), query); err != nil {
-		return fmt.Errorf("failed to create schema: %w", err)
+	err := pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, retireExpiredQuery, []string{record.OriginalURL}); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, query,
+			record.ShortID, record.OriginalURL, record.UserID, nullableTime(record.ExpiresAt))
+		return err
+	})
+	if err != nil {
+		err = classifyError(err)
+		if !errors.Is(err, errs.ErrIDConflict) && !errors.Is(err, errs.ErrURLConflict) {
+			p.logger.Error("Не удалось сохранить ID", zap.Error(err))
+		}
+		return fmt.Errorf("ошибка при сохранении ID: %w", err)
 	}
+	p.pinWriter(ctx)
 	return nil
 }
 
-func (p *PostgresStore) SaveID(id, originalURL string) error {
-	query := `INSERT INTO urls (short_id, original_url) VALUES ($1, $2) ON CONFLICT DO NOTHING;`
-	_, err := p.conn.Exec(context.Background(), query, id, originalURL)
+// GetOrCreate сохраняет URL под переданным ID или возвращает ID живой ссылки, под которым URL
+// уже сохранён. При конфликте по хешу URL строка не меняется, но RETURNING отдаёт её short_id,
+// поэтому результат атомарен и при параллельных вставках. Повтор после разрыва соединения
+// безопасен: если первая попытка успела сохранить запись, повтор вернёт тот же ID.
+func (p *PostgresStore) GetOrCreate(ctx context.Context, record models.URLRecord) (string, bool, error) {
+	query := `
+	INSERT INTO urls (short_id, original_url, original_url_hash, user_id, expires_at)
+	VALUES ($1, $2, sha256(convert_to($2, 'UTF8')), NULLIF($3, ''), $4)
+	ON CONFLICT (original_url_hash) WHERE NOT is_deleted
+	DO UPDATE SET original_url_hash = EXCLUDED.original_url_hash
+	RETURNING short_id;
+	`
+	var actualID string
+	err := p.retry(ctx, "get or create", func() error {
+		return pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, retireExpiredQuery, []string{record.OriginalURL}); err != nil {
+				return err
+			}
+			return tx.QueryRow(ctx, query,
+				record.ShortID, record.OriginalURL, record.UserID, nullableTime(record.ExpiresAt)).Scan(&actualID)
+		})
+	})
 	if err != nil {
-		// Здесь можно проверить, если ошибка обернута, и распаковать ее
-		if wrappedErr := errors.Unwrap(err); wrappedErr != nil {
-			p.logger.Error("Не удалось сохранить ID с внутренней ошибкой", zap.Error(wrappedErr))
-		} else {
-			p.logger.Error("Не удалось сохранить ID", zap.Error(err))
+		err = classifyError(err)
+		if !errors.Is(err, errs.ErrIDConflict) {
+			p.logger.Error("Failed to get or create ID", zap.Error(err))
 		}
-		return fmt.Errorf("ошибка при сохранении ID: %w", err) // Обернуть ошибку правильно
+		return "", false, fmt.Errorf("failed to get or create ID: %w", err)
 	}
-	return nil
+	p.pinWriter(ctx)
+	return actualID, actualID != record.ShortID, nil
+}
+
+func (p *PostgresStore) Get(ctx context.Context, id string) (string, error) {
+	originalURL, _, err := p.GetWithExpiry(ctx, id)
+	return originalURL, err
 }
 
-func (p *PostgresStore) Get(id string) (string, bool) {
-	query := `SELECT original_url FROM urls WHERE short_id = $1;`
+// GetWithExpiry возвращает URL ссылки и момент истечения её срока, нулевой для бессрочной.
+// Истечение проверяется по часам сервера базы.
+func (p *PostgresStore) GetWithExpiry(ctx context.Context, id string) (string, time.Time, error) {
+	query := `
+	SELECT original_url, is_deleted, expires_at, COALESCE(expires_at <= now(), FALSE) FROM urls WHERE short_id = $1;
+	`
 	var originalURL string
-	err := p.conn.QueryRow(context.Background(), query, id).Scan(&originalURL)
+	var expiresAt *time.Time
+	var isDeleted, isExpired bool
+	err := p.retry(ctx, "get", func() error {
+		return p.readRow(ctx, query, []any{id}, &originalURL, &isDeleted, &expiresAt, &isExpired)
+	})
 	if err != nil {
-		if err.Error() == "no rows in result set" {
-			return "", false
+		err = classifyError(err)
+		if !errors.Is(err, errs.ErrNotFound) {
+			p.logger.Error("Failed to get URL", zap.Error(err))
 		}
-		p.logger.Error("Failed to get URL", zap.Error(err))
-		return "", false
+		return "", time.Time{}, fmt.Errorf("failed to get URL by ID %s: %w", id, err)
+	}
+	if isDeleted {
+		return "", time.Time{}, fmt.Errorf("ID %s: %w", id, errs.ErrDeleted)
+	}
+	if isExpired {
+		return "", time.Time{}, fmt.Errorf("ID %s: %w", id, errs.ErrExpired)
+	}
+	if expiresAt == nil {
+		return originalURL, time.Time{}, nil
 	}
-	return originalURL, true
+	return originalURL, *expiresAt, nil
 }
 
-func (p *PostgresStore) GetIDByURL(originalURL string) (string, bool) {
-	query := `SELECT short_id FROM urls WHERE original_url = $1;`
+// GetIDByURL ищет живую ссылку на URL по уникальному индексу его хеша.
+func (p *PostgresStore) GetIDByURL(ctx context.Context, originalURL string) (string, error) {
+	query := `
+	SELECT short_id FROM urls
+	WHERE original_url_hash = sha256(convert_to($1, 'UTF8')) AND NOT is_deleted
+		AND (expires_at IS NULL OR expires_at > now());
+	`
 	var id string
-	err := p.conn.QueryRow(context.Background(), query, originalURL).Scan(&id)
+	err := p.retry(ctx, "get id by url", func() error {
+		return p.readRow(ctx, query, []any{originalURL}, &id)
+	})
 	if err != nil {
-		if err.Error() == "no rows in result set" {
-			return "", false
+		err = classifyError(err)
+		if !errors.Is(err, errs.ErrNotFound) {
+			p.logger.Error("Failed to get ID", zap.Error(err))
 		}
-		p.logger.Error("Failed to get ID", zap.Error(err))
-		return "", false
+		return "", fmt.Errorf("failed to get ID by URL: %w", err)
 	}
-	return id, true
+	return id, nil
 }
 
-func (p *PostgresStore) SaveBatch(pairs map[string]string) error {
-	ctx := context.Background()
+// GetUserURLs возвращает ссылки, сохранённые пользователем, в порядке создания.
+func (p *PostgresStore) GetUserURLs(ctx context.Context, userID string) ([]models.URLRecord, error) {
+	query := `
+	SELECT short_id, original_url, expires_at FROM urls
+	WHERE user_id = $1 AND NOT is_deleted AND (expires_at IS NULL OR expires_at > now())
+	ORDER BY id;
+	`
+	var records []models.URLRecord
+	err := p.retry(ctx, "get user urls", func() error {
+		rows, err := p.conn.Query(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+		records, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.URLRecord, error) {
+			record := models.URLRecord{UserID: userID}
+			var expiresAt *time.Time
+			if err := row.Scan(&record.ShortID, &record.OriginalURL, &expiresAt); err != nil {
+				return record, fmt.Errorf("failed to scan row: %w", err)
+			}
+			if expiresAt != nil {
+				record.ExpiresAt = *expiresAt
+			}
+			return record, nil
+		})
+		return err
+	})
+	if err != nil {
+		err = classifyError(err)
+		p.logger.Error("Failed to read user URLs", zap.Error(err))
+		return nil, fmt.Errorf("failed to read user URLs: %w", err)
+	}
+	return records, nil
+}
+
+// ForEachID вызывает fn для ID каждой хранимой записи, включая удалённые.
+// Строки читаются потоком, не собираясь в память целиком.
+func (p *PostgresStore) ForEachID(ctx context.Context, fn func(id string) error) error {
+	rows, err := p.conn.Query(ctx, `SELECT short_id FROM urls;`)
+	if err != nil {
+		err = classifyError(err)
+		p.logger.Error("Failed to list IDs", zap.Error(err))
+		return fmt.Errorf("failed to list IDs: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		id    string
+		fnErr error
+	)
+	_, err = pgx.ForEachRow(rows, []any{&id}, func() error {
+		fnErr = fn(id)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to iterate IDs: %w", classifyError(err))
+	}
+	return nil
+}
+
+// Iterate вызывает fn для каждой записи с ID больше afterID в порядке возрастания ID.
+// Записи читаются страницами по индексу short_id, поэтому обход не держит долгую транзакцию.
+func (p *PostgresStore) Iterate(ctx context.Context, afterID string, fn func(models.URLRecord) error) error {
+	const pageSize = 1000
+	query := `
+	SELECT short_id, original_url, COALESCE(user_id, ''), expires_at, clicks, last_accessed_at, is_deleted
+	FROM urls WHERE short_id > $1 ORDER BY short_id LIMIT $2;
+	`
+	for {
+		var page []models.URLRecord
+		err := p.retry(ctx, "iterate", func() error {
+			rows, err := p.conn.Query(ctx, query, afterID, pageSize)
+			if err != nil {
+				return err
+			}
+			page, err = pgx.CollectRows(rows, scanRecord)
+			return err
+		})
+		if err != nil {
+			err = classifyError(err)
+			p.logger.Error("Failed to read records", zap.Error(err))
+			return fmt.Errorf("failed to read records: %w", err)
+		}
+
+		for _, record := range page {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+		afterID = page[len(page)-1].ShortID
+	}
+}
+
+// scanRecord читает запись из строки с колонками в порядке запроса Iterate.
+func scanRecord(row pgx.CollectableRow) (models.URLRecord, error) {
+	var (
+		record                    models.URLRecord
+		expiresAt, lastAccessedAt *time.Time
+	)
+	err := row.Scan(&record.ShortID, &record.OriginalURL, &record.UserID, &expiresAt,
+		&record.Clicks, &lastAccessedAt, &record.IsDeleted)
+	if err != nil {
+		return record, fmt.Errorf("failed to scan row: %w", err)
+	}
+	if expiresAt != nil {
+		record.ExpiresAt = *expiresAt
+	}
+	if lastAccessedAt != nil {
+		record.LastAccessedAt = *lastAccessedAt
+	}
+	return record, nil
+}
+
+// DeleteURLs одним запросом помечает удалёнными ссылки, принадлежащие авторам запросов.
+func (p *PostgresStore) DeleteURLs(ctx context.Context, requests []models.DeleteRequest) error {
+	userIDs := make([]string, len(requests))
+	shortIDs := make([]string, len(requests))
+	for i, request := range requests {
+		userIDs[i] = request.UserID
+		shortIDs[i] = request.ShortID
+	}
+
+	query := `
+	UPDATE urls SET is_deleted = TRUE
+	FROM unnest($1::text[], $2::text[]) AS d(user_id, short_id)
+	WHERE urls.short_id = d.short_id AND urls.user_id = d.user_id AND NOT urls.is_deleted;
+	`
+	if err := p.retry(ctx, "delete urls", func() error {
+		_, err := p.conn.Exec(ctx, query, userIDs, shortIDs)
+		return err
+	}); err != nil {
+		err = classifyError(err)
+		p.logger.Error("Failed to delete URLs", zap.Error(err))
+		return fmt.Errorf("failed to delete URLs: %w", err)
+	}
+	return nil
+}
+
+// RecordClicks одним запросом прибавляет накопленные переходы к счётчикам ссылок.
+func (p *PostgresStore) RecordClicks(ctx context.Context, clicks []models.ClickStat) error {
+	shortIDs := make([]string, len(clicks))
+	counts := make([]int64, len(clicks))
+	accessedAt := make([]time.Time, len(clicks))
+	for i, click := range clicks {
+		shortIDs[i] = click.ShortID
+		counts[i] = click.Clicks
+		accessedAt[i] = click.LastAccessedAt
+	}
+
+	query := `
+	UPDATE urls SET
+		clicks = urls.clicks + d.clicks,
+		last_accessed_at = GREATEST(urls.last_accessed_at, d.accessed_at)
+	FROM unnest($1::text[], $2::bigint[], $3::timestamptz[]) AS d(short_id, clicks, accessed_at)
+	WHERE urls.short_id = d.short_id;
+	`
+	if _, err := p.conn.Exec(ctx, query, shortIDs, counts, accessedAt); err != nil {
+		err = classifyError(err)
+		p.logger.Error("Failed to record clicks", zap.Error(err))
+		return fmt.Errorf("failed to record clicks: %w", err)
+	}
+	return nil
+}
+
+// GetStats возвращает статистику переходов по ссылке.
+func (p *PostgresStore) GetStats(ctx context.Context, id string) (models.ClickStat, error) {
+	query := `SELECT clicks, last_accessed_at FROM urls WHERE short_id = $1;`
+	stat := models.ClickStat{ShortID: id}
+	var lastAccessedAt *time.Time
+	if err := p.retry(ctx, "get stats", func() error {
+		return p.conn.QueryRow(ctx, query, id).Scan(&stat.Clicks, &lastAccessedAt)
+	}); err != nil {
+		err = classifyError(err)
+		if !errors.Is(err, errs.ErrNotFound) {
+			p.logger.Error("Failed to get stats", zap.Error(err))
+		}
+		return models.ClickStat{}, fmt.Errorf("failed to get stats by ID %s: %w", id, err)
+	}
+	if lastAccessedAt != nil {
+		stat.LastAccessedAt = *lastAccessedAt
+	}
+	return stat, nil
+}
+
+// DeleteExpired удаляет ссылки, срок жизни которых истёк к моменту now, и возвращает их число.
+func (p *PostgresStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	var tag pgconn.CommandTag
+	err := p.retry(ctx, "delete expired", func() error {
+		var err error
+		tag, err = p.conn.Exec(ctx, `DELETE FROM urls WHERE expires_at <= $1;`, now)
+		return err
+	})
+	if err != nil {
+		err = classifyError(err)
+		p.logger.Error("Failed to delete expired URLs", zap.Error(err))
+		return 0, fmt.Errorf("failed to delete expired URLs: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// SaveBatch сохраняет пакет записей в одной транзакции и возвращает результат для каждой записи.
+// Пакеты от Options.CopyThreshold записей загружаются через COPY, меньшие — отдельными вставками.
+// Записи, не помещающиеся в колонки, отмечаются несохранёнными заранее: иначе ошибка
+// одной записи откатила бы весь пакет.
+func (p *PostgresStore) SaveBatch(ctx context.Context, records []models.URLRecord) ([]models.BatchResult, error) {
+	defer p.pinWriter(ctx)
+
+	results := make([]models.BatchResult, len(records))
+	fitting := make([]models.URLRecord, 0, len(records))
+	positions := make([]int, 0, len(records))
+	for i, record := range records {
+		if err := checkColumns(record); err != nil {
+			results[i] = models.BatchResult{Status: models.BatchFailed, Err: err}
+			continue
+		}
+		fitting = append(fitting, record)
+		positions = append(positions, i)
+	}
+	if len(fitting) == 0 {
+		return results, nil
+	}
+
+	var (
+		saved []models.BatchResult
+		err   error
+	)
+	if p.options.useCopy(len(fitting)) {
+		saved, err = p.copyBatch(ctx, fitting)
+	} else {
+		saved, err = p.insertBatch(ctx, fitting)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for j, result := range saved {
+		results[positions[j]] = result
+	}
+	return results, nil
+}
+
+// useCopy сообщает, что пакет из size записей загружается через COPY.
+func (o Options) useCopy(size int) bool {
+	return o.CopyThreshold > 0 && size >= o.CopyThreshold
+}
+
+// batchResult переводит ответ запроса сохранения в результат записи пакета. actualID — ID,
+// под которым URL сохранён, пустой, если запись не сохранена из-за занятого ID;
+// inserted сообщает, что запись вставлена этим запросом.
+func batchResult(record models.URLRecord, actualID string, inserted bool) models.BatchResult {
+	switch {
+	case actualID == "":
+		return models.BatchResult{
+			Status: models.BatchFailed,
+			Err:    fmt.Errorf("%w: %s", errs.ErrIDConflict, record.ShortID),
+		}
+	case inserted:
+		return models.BatchResult{ShortID: actualID, Status: models.BatchCreated}
+	default:
+		return models.BatchResult{ShortID: actualID, Status: models.BatchExisted}
+	}
+}
+
+// MaxShortIDLength и MaxUserIDLength — длины колонок short_id и user_id таблицы urls.
+const (
+	MaxShortIDLength = 12
+	MaxUserIDLength  = 64
+)
+
+// checkColumns проверяет, что ID записи помещаются в колонки VARCHAR таблицы urls.
+func checkColumns(record models.URLRecord) error {
+	if len(record.ShortID) > MaxShortIDLength {
+		return fmt.Errorf("short ID %q longer than %d bytes: %w", record.ShortID, MaxShortIDLength,
+			errs.ErrValueTooLong)
+	}
+	if len(record.UserID) > MaxUserIDLength {
+		return fmt.Errorf("user ID longer than %d bytes: %w", MaxUserIDLength, errs.ErrValueTooLong)
+	}
+	return nil
+}
+
+// insertBatch ставит в пакет pgx по вставке на запись. Каждая вставка игнорирует конфликты
+// и тут же читает ID, под которым URL сохранён, поэтому повторы URL не прерывают транзакцию.
+// Отсутствие строки означает, что занят сам ID.
+func (p *PostgresStore) insertBatch(ctx context.Context, records []models.URLRecord) ([]models.BatchResult, error) {
 	tx, err := p.conn.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", classifyError(err))
 	}
 	defer func() {
 		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
@@ -110,25 +553,271 @@ func (p *PostgresStore) SaveBatch(pairs map[string]string) error {
 		}
 	}()
 
+	if _, err := tx.Exec(ctx, retireExpiredQuery, originalURLs(records)); err != nil {
+		p.logger.Error("Failed to delete expired duplicates", zap.Error(err))
+		return nil, fmt.Errorf("failed to delete expired duplicates: %w", classifyError(err))
+	}
+
+	// Удалённая запись, перенесённая из другого хранилища, не попадает в уникальный индекс
+	// живых URL и конфликтует только по ID; тот же URL под её ID считается уже сохранённым.
+	query := `
+	WITH inserted AS (
+		INSERT INTO urls (short_id, original_url, original_url_hash, user_id, expires_at, is_deleted)
+		VALUES ($1, $2, sha256(convert_to($2, 'UTF8')), NULLIF($3, ''), $4, $5)
+		ON CONFLICT DO NOTHING
+		RETURNING short_id
+	)
+	SELECT short_id, true FROM inserted
+	UNION ALL
+	SELECT short_id, false FROM urls
+	WHERE original_url_hash = sha256(convert_to($2, 'UTF8'))
+		AND CASE WHEN $5 THEN short_id = $1 ELSE NOT is_deleted END
+	LIMIT 1;
+	`
 	batch := &pgx.Batch{}
-	for id, originalURL := range pairs {
-		batch.Queue(`INSERT INTO urls (short_id, original_url) VALUES ($1, $2) ON CONFLICT DO NOTHING`, id, originalURL)
+	for _, record := range records {
+		batch.Queue(query,
+			record.ShortID, record.OriginalURL, record.UserID, nullableTime(record.ExpiresAt), record.IsDeleted)
 	}
 
-	err = tx.SendBatch(ctx, batch).Close()
-	if err != nil {
+	results := make([]models.BatchResult, len(records))
+	batchResults := tx.SendBatch(ctx, batch)
+	for i, record := range records {
+		var (
+			actualID string
+			inserted bool
+		)
+		err := batchResults.QueryRow().Scan(&actualID, &inserted)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			_ = batchResults.Close()
+			p.logger.Error("SendBatch error: %v\n", zap.Error(err))
+			return nil, fmt.Errorf("send batch error: %w", classifyError(err))
+		}
+		results[i] = batchResult(record, actualID, inserted)
+	}
+
+	if err := batchResults.Close(); err != nil {
 		p.logger.Error("SendBatch error: %v\n", zap.Error(err))
-		return fmt.Errorf("send batch error: %w", err)
+		return nil, fmt.Errorf("send batch error: %w", classifyError(err))
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		p.logger.Error("Failed to commit transaction", zap.Error(err))
+		return nil, fmt.Errorf("failed to commit transaction: %w", classifyError(err))
+	}
+
+	return results, nil
+}
+
+// copyBatch загружает пакет через COPY во временную таблицу, удаляемую при фиксации,
+// и одним запросом переносит её в urls. Результаты совпадают с insertBatch: из повторов
+// одного URL в пакете сохраняется первый, остальные получают его ID.
+//
+// Запрос переноса видит urls на момент своего начала, поэтому URL, одновременно
+// сохранённый другой транзакцией, попадает в результат как конфликт ID, и
+// вызывающий, как и при любом конфликте ID, повторяет запись с новым ID.
+func (p *PostgresStore) copyBatch(ctx context.Context, records []models.URLRecord) ([]models.BatchResult, error) {
+	tx, err := p.conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", classifyError(err))
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			p.logger.Error("Failed to rollback transaction", zap.Error(err))
+		}
+	}()
+
+	createQuery := `
+	CREATE TEMP TABLE urls_staging (
+		pos INT NOT NULL,
+		short_id TEXT NOT NULL,
+		original_url TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		expires_at TIMESTAMPTZ,
+		is_deleted BOOLEAN NOT NULL
+	) ON COMMIT DROP;
+	`
+	if _, err := tx.Exec(ctx, createQuery); err != nil {
+		p.logger.Error("Failed to create staging table", zap.Error(err))
+		return nil, fmt.Errorf("failed to create staging table: %w", classifyError(err))
+	}
+
+	columns := []string{"pos", "short_id", "original_url", "user_id", "expires_at", "is_deleted"}
+	rows := pgx.CopyFromSlice(len(records), func(i int) ([]any, error) {
+		record := records[i]
+		return []any{
+			i, record.ShortID, record.OriginalURL, record.UserID, nullableTime(record.ExpiresAt), record.IsDeleted,
+		}, nil
+	})
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"urls_staging"}, columns, rows); err != nil {
+		p.logger.Error("Failed to copy batch", zap.Error(err))
+		return nil, fmt.Errorf("failed to copy batch: %w", classifyError(err))
+	}
+
+	retireQuery := `
+	DELETE FROM urls
+	WHERE original_url_hash IN (SELECT sha256(convert_to(original_url, 'UTF8')) FROM urls_staging)
+		AND NOT is_deleted AND expires_at <= now();
+	`
+	if _, err := tx.Exec(ctx, retireQuery); err != nil {
+		p.logger.Error("Failed to delete expired duplicates", zap.Error(err))
+		return nil, fmt.Errorf("failed to delete expired duplicates: %w", classifyError(err))
+	}
+
+	// Удалённые записи, как и в insertBatch, URL не занимают: они не схлопываются
+	// с живыми повторами URL и сопоставляются с urls по ID.
+	mergeQuery := `
+	WITH staged AS (
+		SELECT pos, short_id, original_url, sha256(convert_to(original_url, 'UTF8')) AS hash,
+			user_id, expires_at, is_deleted
+		FROM urls_staging
+	), inserted AS (
+		INSERT INTO urls (short_id, original_url, original_url_hash, user_id, expires_at, is_deleted)
+		SELECT short_id, original_url, hash, NULLIF(user_id, ''), expires_at, is_deleted
+		FROM (
+			(SELECT DISTINCT ON (hash) * FROM staged WHERE NOT is_deleted ORDER BY hash, pos)
+			UNION ALL
+			SELECT * FROM staged WHERE is_deleted
+		) AS deduplicated
+		ORDER BY pos
+		ON CONFLICT DO NOTHING
+		RETURNING short_id, original_url_hash, is_deleted
+	)
+	SELECT staged.pos, COALESCE(inserted.short_id, urls.short_id, ''),
+		COALESCE(inserted.short_id = staged.short_id, false)
+	FROM staged
+	LEFT JOIN inserted ON inserted.original_url_hash = staged.hash
+		AND CASE WHEN staged.is_deleted THEN inserted.short_id = staged.short_id ELSE NOT inserted.is_deleted END
+	LEFT JOIN urls ON urls.original_url_hash = staged.hash
+		AND CASE WHEN staged.is_deleted THEN urls.short_id = staged.short_id ELSE NOT urls.is_deleted END;
+	`
+	merged, err := tx.Query(ctx, mergeQuery)
+	if err != nil {
+		p.logger.Error("Failed to merge batch", zap.Error(err))
+		return nil, fmt.Errorf("failed to merge batch: %w", classifyError(err))
+	}
+
+	results := make([]models.BatchResult, len(records))
+	var (
+		pos      int
+		actualID string
+		inserted bool
+	)
+	_, err = pgx.ForEachRow(merged, []any{&pos, &actualID, &inserted}, func() error {
+		results[pos] = batchResult(records[pos], actualID, inserted)
+		return nil
+	})
+	if err != nil {
+		p.logger.Error("Failed to read merge results", zap.Error(err))
+		return nil, fmt.Errorf("failed to read merge results: %w", classifyError(err))
 	}
 
 	if err := tx.Commit(ctx); err != nil {
 		p.logger.Error("Failed to commit transaction", zap.Error(err))
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return nil, fmt.Errorf("failed to commit transaction: %w", classifyError(err))
 	}
+	return results, nil
+}
+
+// Ping проверяет соединение с основным сервером.
+func (p *PostgresStore) Ping(ctx context.Context) error {
+	if err := p.conn.Ping(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", classifyError(err))
+	}
+	return nil
+}
 
+// Flush ничего не делает: каждая запись фиксируется в базе до возврата из метода.
+func (p *PostgresStore) Flush(context.Context) error {
 	return nil
 }
 
-func (p *PostgresStore) Close() {
+// Close прекращает слушать изменения, останавливает проверку реплик и закрывает пулы
+// соединений, дожидаясь возврата занятых соединений. Пулы закрываются и после ошибок
+// предыдущих шагов, а ошибки объединяются.
+func (p *PostgresStore) Close(ctx context.Context) error {
+	var failures []error
+	if p.listener != nil {
+		if err := p.listener.Close(ctx); err != nil {
+			failures = append(failures, err)
+		}
+	}
+	if p.replicas != nil {
+		if err := p.replicas.Close(ctx); err != nil {
+			failures = append(failures, err)
+		}
+	}
 	p.conn.Close()
+	return errors.Join(failures...)
+}
+
+// originalURLs возвращает URL записей для запросов с массивом URL.
+func originalURLs(records []models.URLRecord) []string {
+	urls := make([]string, len(records))
+	for i, record := range records {
+		urls[i] = record.OriginalURL
+	}
+	return urls
+}
+
+// nullableTime превращает нулевое время в NULL.
+func nullableTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Коды ошибок PostgreSQL, которые различает хранилище.
+const (
+	codeUniqueViolation = "23505"
+	codeStringTooLong   = "22001"
+	// codeQueryCanceled входит в класс вмешательства оператора, но означает отмену запроса,
+	// например по statement_timeout: сервер доступен, а повтор упрётся в тот же таймаут.
+	codeQueryCanceled = "57014"
+	// Классы ошибок: проблемы соединения, нехватка ресурсов, вмешательство оператора.
+	classConnectionException   = "08"
+	classInsufficientResources = "53"
+	classOperatorIntervention  = "57"
+)
+
+// Имена ограничений уникальности таблицы urls.
+const (
+	constraintShortID     = "urls_short_id_key"
+	constraintOriginalURL = "urls_original_url_hash_key"
+)
+
+// classifyError приводит ошибку pgx к ошибкам пакета errs,
+// сохраняя исходную ошибку в цепочке.
+func classifyError(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %w", errs.ErrNotFound, err)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		// Ошибка не пришла от сервера: соединение не установлено или разорвано.
+		return fmt.Errorf("%w: %w", errs.ErrUnavailable, err)
+	}
+
+	const classLen = 2
+	switch {
+	case pgErr.Code == codeUniqueViolation && pgErr.ConstraintName == constraintShortID:
+		return fmt.Errorf("%w: %w", errs.ErrIDConflict, err)
+	case pgErr.Code == codeUniqueViolation && pgErr.ConstraintName == constraintOriginalURL:
+		return fmt.Errorf("%w: %w", errs.ErrURLConflict, err)
+	case pgErr.Code == codeStringTooLong:
+		return fmt.Errorf("%w: %w", errs.ErrValueTooLong, err)
+	case pgErr.Code == codeQueryCanceled:
+		return err
+	case len(pgErr.Code) >= classLen && (pgErr.Code[:classLen] == classConnectionException ||
+		pgErr.Code[:classLen] == classInsufficientResources ||
+		pgErr.Code[:classLen] == classOperatorIntervention):
+		return fmt.Errorf("%w: %w", errs.ErrUnavailable, err)
+	default:
+		return err
+	}
 }
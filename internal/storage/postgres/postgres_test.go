@@ -0,0 +1,165 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestClassifyError(t *testing.T) {
+	queryCanceled := &pgconn.PgError{Code: codeQueryCanceled}
+	tests := []struct {
+		err       error
+		expected  error
+		transient bool
+	}{
+		{err: &pgconn.PgError{Code: codeUniqueViolation, ConstraintName: constraintShortID}, expected: errs.ErrIDConflict},
+		{
+			err:      &pgconn.PgError{Code: codeUniqueViolation, ConstraintName: constraintOriginalURL},
+			expected: errs.ErrURLConflict,
+		},
+		{err: &pgconn.PgError{Code: codeStringTooLong}, expected: errs.ErrValueTooLong},
+		{err: &pgconn.PgError{Code: "08006"}, expected: errs.ErrUnavailable, transient: true},
+		{err: &pgconn.PgError{Code: "57P01"}, expected: errs.ErrUnavailable, transient: true},
+		// Отмена по statement_timeout не означает недоступности сервера и не повторяется.
+		{err: queryCanceled, expected: queryCanceled},
+		{err: errors.New("connection refused"), expected: errs.ErrUnavailable, transient: true},
+	}
+	for _, tt := range tests {
+		err := classifyError(tt.err)
+		assert.ErrorIs(t, err, tt.expected)
+		assert.ErrorIs(t, err, tt.err)
+		assert.Equal(t, tt.transient, transient(tt.err), tt.err.Error())
+	}
+	assert.NotErrorIs(t, classifyError(queryCanceled), errs.ErrUnavailable)
+}
+
+func TestCheckColumns(t *testing.T) {
+	assert.NoError(t, checkColumns(models.URLRecord{ShortID: "abcdefghijkl", UserID: strings.Repeat("u", 64)}))
+	assert.ErrorIs(t, checkColumns(models.URLRecord{ShortID: "abcdefghijklm"}), errs.ErrValueTooLong)
+	assert.ErrorIs(t, checkColumns(models.URLRecord{ShortID: "a", UserID: strings.Repeat("u", 65)}),
+		errs.ErrValueTooLong)
+}
+
+func TestCloseClosesAllPools(t *testing.T) {
+	// Соединения пула устанавливаются лениво, поэтому сервер не нужен.
+	newPool := func() *pgxpool.Pool {
+		pool, err := pgxpool.New(context.Background(), "postgres://user@127.0.0.1:1/db")
+		require.NoError(t, err)
+		return pool
+	}
+	primaryPool, replicaPool := newPool(), newPool()
+	// Ни слушатель, ни проверка реплик не завершаются, поэтому оба шага вернут ошибку.
+	store := &PostgresStore{
+		conn:     primaryPool,
+		listener: &changeListener{cancel: func() {}, done: make(chan struct{})},
+		replicas: &replicaSet{
+			stop:      make(chan struct{}),
+			done:      make(chan struct{}),
+			closeOnce: &sync.Once{},
+			replicas:  []*replica{{pool: replicaPool}},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := store.Close(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "close listener canceled")
+	assert.ErrorContains(t, err, "close replicas canceled")
+	for _, pool := range []*pgxpool.Pool{primaryPool, replicaPool} {
+		assert.ErrorContains(t, pool.Ping(context.Background()), "closed pool")
+	}
+}
+
+func TestUseCopy(t *testing.T) {
+	assert.False(t, Options{}.useCopy(1000))
+	assert.False(t, Options{CopyThreshold: 10}.useCopy(9))
+	assert.True(t, Options{CopyThreshold: 10}.useCopy(10))
+	assert.True(t, Options{CopyThreshold: 10}.useCopy(11))
+}
+
+func TestBatchResult(t *testing.T) {
+	record := models.URLRecord{ShortID: "id", OriginalURL: "https://example.com"}
+	assert.Equal(t, models.BatchResult{ShortID: "id", Status: models.BatchCreated}, batchResult(record, "id", true))
+	// Запись уже сохранена под тем же ID, например при повторном переносе.
+	assert.Equal(t, models.BatchResult{ShortID: "id", Status: models.BatchExisted}, batchResult(record, "id", false))
+	assert.Equal(t, models.BatchResult{ShortID: "other", Status: models.BatchExisted},
+		batchResult(record, "other", false))
+
+	result := batchResult(record, "", false)
+	assert.Equal(t, models.BatchFailed, result.Status)
+	assert.ErrorIs(t, result.Err, errs.ErrIDConflict)
+}
+
+// TestSaveBatch проверяет оба способа сохранения пакета на настоящей базе.
+// Без строки подключения тест пропускается.
+func TestSaveBatch(t *testing.T) {
+	testDBConnString := ""
+	if testDBConnString == "" {
+		t.Skip("database DSN is not set")
+	}
+
+	ctx := context.Background()
+	for name, copyThreshold := range map[string]int{"insert": 0, "copy": 1} {
+		t.Run(name, func(t *testing.T) {
+			options := DefaultOptions()
+			options.CopyThreshold = copyThreshold
+			store, err := NewPostgresStore(testDBConnString, options, logger.NewZapLogger(zap.NewNop()))
+			require.NoError(t, err)
+			defer func() {
+				assert.NoError(t, store.Close(ctx))
+			}()
+
+			prefix := fmt.Sprintf("%s%d", name[:1], time.Now().UnixNano()%1e9)
+			url := func(suffix string) string {
+				return "https://example.com/" + prefix + "/" + suffix
+			}
+			require.NoError(t, store.SaveID(ctx, models.URLRecord{ShortID: prefix + "t", OriginalURL: url("taken")}))
+
+			batch := []models.URLRecord{
+				{ShortID: prefix + "a", OriginalURL: url("a")},
+				{ShortID: prefix + "b", OriginalURL: url("a")},
+				{ShortID: prefix + "c", OriginalURL: url("taken")},
+				{ShortID: prefix + "t", OriginalURL: url("other")},
+				{ShortID: prefix + "d", OriginalURL: url("shared"), UserID: "user", IsDeleted: true},
+				{ShortID: prefix + "e", OriginalURL: url("shared")},
+			}
+			results, err := store.SaveBatch(ctx, batch)
+			require.NoError(t, err)
+			require.Len(t, results, len(batch))
+			assert.Equal(t, models.BatchResult{ShortID: prefix + "a", Status: models.BatchCreated}, results[0])
+			assert.Equal(t, models.BatchResult{ShortID: prefix + "a", Status: models.BatchExisted}, results[1])
+			assert.Equal(t, models.BatchResult{ShortID: prefix + "t", Status: models.BatchExisted}, results[2])
+			assert.Equal(t, models.BatchFailed, results[3].Status)
+			assert.ErrorIs(t, results[3].Err, errs.ErrIDConflict)
+			assert.Equal(t, models.BatchResult{ShortID: prefix + "d", Status: models.BatchCreated}, results[4])
+			assert.Equal(t, models.BatchResult{ShortID: prefix + "e", Status: models.BatchCreated}, results[5])
+
+			_, err = store.Get(ctx, prefix+"d")
+			require.ErrorIs(t, err, errs.ErrDeleted)
+			id, err := store.GetIDByURL(ctx, url("shared"))
+			require.NoError(t, err)
+			assert.Equal(t, prefix+"e", id)
+
+			// Повтор пакета находит сохранённые записи под их ID.
+			results, err = store.SaveBatch(ctx, batch[4:])
+			require.NoError(t, err)
+			assert.Equal(t, models.BatchResult{ShortID: prefix + "d", Status: models.BatchExisted}, results[0])
+			assert.Equal(t, models.BatchResult{ShortID: prefix + "e", Status: models.BatchExisted}, results[1])
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// replicaPingTimeout ограничивает проверку доступности одной реплики.
+const replicaPingTimeout = 2 * time.Second
+
+// clientKey — ключ контекста с ID клиента для read-your-writes.
+type clientKey struct{}
+
+// WithClient возвращает контекст запросов клиента clientID. После записи клиента его чтения
+// в течение Options.ReadYourWritesWindow идут на основной сервер, а не на реплики.
+func WithClient(ctx context.Context, clientID string) context.Context {
+	return context.WithValue(ctx, clientKey{}, clientID)
+}
+
+// clientFromContext возвращает ID клиента, установленный WithClient, или пустую строку.
+func clientFromContext(ctx context.Context) string {
+	clientID, _ := ctx.Value(clientKey{}).(string)
+	return clientID
+}
+
+// replica — пул соединений с репликой и результат её последней проверки.
+type replica struct {
+	pool    *pgxpool.Pool
+	healthy *atomic.Bool
+	// index — номер реплики в настройках: в журнал не попадает DSN с паролем.
+	index int
+}
+
+// replicaSet распределяет чтения по доступным репликам по кругу и в фоне проверяет их доступность.
+// Пока реплика не прошла первую проверку, она считается недоступной.
+//
+// Для read-your-writes запоминает клиентов, недавно сохранивших ссылки: их чтения
+// в течение окна идут на основной сервер, чтобы не упереться в отставание реплики.
+// Клиент определяется по ID, переданному в контексте запроса через WithClient.
+type replicaSet struct {
+	logger    logger.Logger
+	next      *atomic.Uint64
+	pinsMu    *sync.Mutex
+	pins      map[string]time.Time
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce *sync.Once
+	replicas  []*replica
+	interval  time.Duration
+	pinWindow time.Duration
+}
+
+// newReplicaSet создаёт пулы реплик и запускает фоновую проверку их доступности.
+// Соединения устанавливаются лениво, поэтому недоступная при старте реплика не мешает запуску.
+func newReplicaSet(dsns []string, options Options, parentLogger logger.Logger) (*replicaSet, error) {
+	s := &replicaSet{
+		logger:    parentLogger,
+		next:      &atomic.Uint64{},
+		pinsMu:    &sync.Mutex{},
+		pins:      make(map[string]time.Time),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
+		interval:  options.ReplicaCheckInterval,
+		pinWindow: options.ReadYourWritesWindow,
+	}
+	for i, dsn := range dsns {
+		config, err := poolConfig(dsn, options.Pool)
+		if err != nil {
+			s.closePools()
+			return nil, fmt.Errorf("invalid DSN of replica %d: %w", i, err)
+		}
+		pool, err := pgxpool.NewWithConfig(context.Background(), config)
+		if err != nil {
+			s.closePools()
+			return nil, fmt.Errorf("failed to create pool for replica %d: %w", i, err)
+		}
+		s.replicas = append(s.replicas, &replica{pool: pool, healthy: &atomic.Bool{}, index: i})
+	}
+	go s.run()
+	return s, nil
+}
+
+// pick возвращает следующую доступную реплику или nil, если чтение должно идти на основной сервер.
+func (s *replicaSet) pick(ctx context.Context) *replica {
+	if s.pinned(ctx) {
+		return nil
+	}
+	// Очередь идёт по доступным репликам, чтобы нагрузка недоступной не ложилась на соседнюю.
+	var healthy uint64
+	for _, r := range s.replicas {
+		if r.healthy.Load() {
+			healthy++
+		}
+	}
+	if healthy == 0 {
+		return nil
+	}
+	k := s.next.Add(1) % healthy
+	for _, r := range s.replicas {
+		if !r.healthy.Load() {
+			continue
+		}
+		if k == 0 {
+			return r
+		}
+		k--
+	}
+	// Реплика стала недоступной между подсчётом и выбором.
+	return nil
+}
+
+// markDown исключает реплику из чтений до следующей успешной проверки.
+func (s *replicaSet) markDown(r *replica, err error) {
+	if r.healthy.Swap(false) {
+		s.logger.Warn("Replica is unavailable, reading from primary", zap.Int("replica", r.index), zap.Error(err))
+	}
+}
+
+// pin направляет чтения клиента из ctx на основной сервер в течение окна read-your-writes.
+func (s *replicaSet) pin(ctx context.Context) {
+	clientID := clientFromContext(ctx)
+	if s.pinWindow <= 0 || clientID == "" {
+		return
+	}
+	s.pinsMu.Lock()
+	defer s.pinsMu.Unlock()
+	s.pins[clientID] = time.Now().Add(s.pinWindow)
+}
+
+func (s *replicaSet) pinned(ctx context.Context) bool {
+	clientID := clientFromContext(ctx)
+	if s.pinWindow <= 0 || clientID == "" {
+		return false
+	}
+	s.pinsMu.Lock()
+	defer s.pinsMu.Unlock()
+	until, ok := s.pins[clientID]
+	if ok && time.Now().After(until) {
+		delete(s.pins, clientID)
+		return false
+	}
+	return ok
+}
+
+// Close останавливает проверки и закрывает пулы реплик, даже если проверка не успела
+// завершиться до отмены ctx: незавершённая проверка получит ошибку закрытого пула.
+func (s *replicaSet) Close(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+
+	var err error
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		err = fmt.Errorf("close replicas canceled: %w", ctx.Err())
+	}
+	s.closePools()
+	return err
+}
+
+func (s *replicaSet) closePools() {
+	for _, r := range s.replicas {
+		r.pool.Close()
+	}
+}
+
+func (s *replicaSet) run() {
+	defer close(s.done)
+
+	s.check()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.check()
+			s.expirePins()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// check проверяет все реплики и записывает в журнал изменения их доступности.
+func (s *replicaSet) check() {
+	for _, r := range s.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), replicaPingTimeout)
+		err := r.pool.Ping(ctx)
+		cancel()
+
+		if err != nil {
+			s.markDown(r, err)
+			continue
+		}
+		if !r.healthy.Swap(true) {
+			s.logger.Info("Replica is available", zap.Int("replica", r.index))
+		}
+	}
+}
+
+// expirePins удаляет истёкшие закрепления клиентов, которые больше ничего не читали.
+func (s *replicaSet) expirePins() {
+	now := time.Now()
+	s.pinsMu.Lock()
+	defer s.pinsMu.Unlock()
+	for userID, until := range s.pins {
+		if now.After(until) {
+			delete(s.pins, userID)
+		}
+	}
+}
+
+// replicaFailed сообщает, что ошибку чтения с реплики стоит повторить на основном сервере.
+func replicaFailed(err error) bool {
+	return errors.Is(classifyError(err), errs.ErrUnavailable)
+}
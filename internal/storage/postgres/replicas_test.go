@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestReplicaSetPick(t *testing.T) {
+	s := &replicaSet{
+		logger:    logger.NewZapLogger(zap.NewNop()),
+		next:      &atomic.Uint64{},
+		pinsMu:    &sync.Mutex{},
+		pins:      make(map[string]time.Time),
+		pinWindow: time.Minute,
+	}
+	for i := range 3 {
+		s.replicas = append(s.replicas, &replica{healthy: &atomic.Bool{}, index: i})
+	}
+	ctx := context.Background()
+
+	// До первой проверки реплики недоступны.
+	assert.Nil(t, s.pick(ctx))
+
+	for _, r := range s.replicas {
+		r.healthy.Store(true)
+	}
+	s.markDown(s.replicas[1], errors.New("connection refused"))
+	picked := make(map[int]int)
+	for range 10 {
+		picked[s.pick(ctx).index]++
+	}
+	assert.Equal(t, map[int]int{0: 5, 2: 5}, picked, "expected round-robin over healthy replicas")
+
+	// Автор записи читает с основного сервера, остальные — с реплик.
+	writer := WithClient(ctx, "writer")
+	s.pin(writer)
+	assert.Nil(t, s.pick(writer))
+	assert.NotNil(t, s.pick(WithClient(ctx, "reader")))
+
+	s.pins["writer"] = time.Now().Add(-time.Second)
+	assert.NotNil(t, s.pick(writer), "expected expired pin to be ignored")
+	assert.Empty(t, s.pins)
+}
@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// PoolOptions — настройки пула соединений. Нулевые значения оставляют значения pgxpool по умолчанию.
+type PoolOptions struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	// StatementTimeout ограничивает выполнение одного запроса на сервере, кроме миграций.
+	StatementTimeout time.Duration
+}
+
+// RetryOptions — настройки повторов с экспоненциально растущей задержкой.
+type RetryOptions struct {
+	// MaxAttempts — число попыток, включая первую. Значение меньше 2 отключает повторы.
+	MaxAttempts int
+	// InitialBackoff — задержка перед второй попыткой, каждая следующая вдвое дольше.
+	InitialBackoff time.Duration
+	// MaxBackoff — наибольшая задержка между попытками.
+	MaxBackoff time.Duration
+}
+
+// do выполняет op, повторяя её, пока retryable признаёт ошибку временной и попытки не исчерпаны.
+// Задержка случайна в пределах текущей ступени, чтобы клиенты не повторяли запросы одновременно.
+func (r RetryOptions) do(
+	ctx context.Context, op func() error, retryable func(error) bool, onRetry func(attempt int, err error),
+) error {
+	backoff := r.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= r.MaxAttempts || !retryable(err) {
+			return err
+		}
+		onRetry(attempt, err)
+
+		timer := time.NewTimer(backoff/2 + rand.N(backoff/2+1))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		}
+		backoff = min(backoff*2, r.MaxBackoff)
+	}
+}
+
+// Коды ошибок, после которых запрос может пройти при повторе.
+const (
+	codeSerializationFailure = "40001"
+	codeDeadlockDetected     = "40P01"
+)
+
+// transient сообщает, что ошибка временная: соединение разорвано или недоступно,
+// либо транзакция отменена из-за конфликта с параллельной.
+func transient(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == codeSerializationFailure || pgErr.Code == codeDeadlockDetected) {
+		return true
+	}
+	return errors.Is(classifyError(err), errs.ErrUnavailable)
+}
+
+// retry выполняет идемпотентный запрос op с повтором при временных ошибках.
+// Неидемпотентные запросы, например прибавление переходов, так не выполняются:
+// при разрыве после фиксации повтор применил бы их дважды.
+func (p *PostgresStore) retry(ctx context.Context, name string, op func() error) error {
+	return p.options.QueryRetry.do(ctx, op, transient, func(attempt int, err error) {
+		p.logger.Warn("Retrying query", zap.String("query", name), zap.Int("attempt", attempt), zap.Error(err))
+	})
+}
+
+// poolConfig разбирает DSN и применяет к нему настройки пула.
+func poolConfig(dsn string, options PoolOptions) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse database DSN: %w", err)
+	}
+	if options.MaxConns > 0 {
+		config.MaxConns = options.MaxConns
+	}
+	if options.MinConns > 0 {
+		config.MinConns = options.MinConns
+	}
+	if options.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = options.MaxConnLifetime
+	}
+	if options.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = options.MaxConnIdleTime
+	}
+	if options.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = options.HealthCheckPeriod
+	}
+	if options.StatementTimeout > 0 {
+		timeout := strconv.FormatInt(options.StatementTimeout.Milliseconds(), 10)
+		config.ConnConfig.RuntimeParams["statement_timeout"] = timeout
+	}
+	return config, nil
+}
+
+// Connect создаёт пул с настройками options.Pool и дожидается доступности базы,
+// повторяя проверку по options.ConnectRetry: при старте база может быть ещё не готова.
+func Connect(ctx context.Context, dsn string, options Options, parentLogger logger.Logger) (*pgxpool.Pool, error) {
+	config, err := poolConfig(dsn, options.Pool)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection pool: %w", err)
+	}
+
+	err = options.ConnectRetry.do(ctx, func() error {
+		return pool.Ping(ctx)
+	}, transient, func(attempt int, err error) {
+		parentLogger.Warn("Database is unavailable, retrying", zap.Int("attempt", attempt), zap.Error(err))
+	})
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", classifyError(err))
+	}
+	return pool, nil
+}
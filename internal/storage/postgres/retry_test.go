@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryOptionsDo(t *testing.T) {
+	retry := RetryOptions{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	deadlock := &pgconn.PgError{Code: codeDeadlockDetected}
+	ctx := context.Background()
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantErr      error
+		wantAttempts int
+	}{
+		{name: "success", errs: []error{nil}, wantAttempts: 1},
+		{name: "transient then success", errs: []error{deadlock, deadlock, nil}, wantAttempts: 3},
+		{name: "attempts exhausted", errs: []error{deadlock, deadlock, deadlock}, wantErr: deadlock, wantAttempts: 3},
+		{
+			name:         "permanent",
+			errs:         []error{&pgconn.PgError{Code: "23505"}},
+			wantErr:      &pgconn.PgError{Code: "23505"},
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts, retries := 0, 0
+			err := retry.do(ctx, func() error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			}, transient, func(int, error) { retries++ })
+
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantAttempts, attempts)
+			assert.Equal(t, attempts-1, retries)
+		})
+	}
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		slow := RetryOptions{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+		attempts := 0
+		err := slow.do(ctx, func() error {
+			attempts++
+			return deadlock
+		}, transient, func(int, error) {})
+
+		require.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, err, deadlock)
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestPoolConfig(t *testing.T) {
+	config, err := poolConfig("postgres://user@localhost/db?pool_max_conns=4", PoolOptions{
+		MaxConns:         25,
+		MaxConnLifetime:  time.Hour,
+		StatementTimeout: 1500 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 25, config.MaxConns)
+	assert.Equal(t, time.Hour, config.MaxConnLifetime)
+	assert.Equal(t, "1500", config.ConnConfig.RuntimeParams["statement_timeout"])
+
+	// Нулевые значения оставляют настройки из DSN.
+	config, err = poolConfig("postgres://user@localhost/db?pool_max_conns=4", PoolOptions{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, config.MaxConns)
+	assert.NotContains(t, config.ConnConfig.RuntimeParams, "statement_timeout")
+
+	_, err = poolConfig("postgres://user@localhost:port/db", PoolOptions{})
+	assert.Error(t, err)
+}
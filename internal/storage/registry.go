@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Factory создаёт хранилище по URI. Путь, хост и параметры URI трактует сама фабрика,
+// общие настройки передаются в cfg.
+type Factory func(uri *url.URL, cfg Config, storageLogger logger.Logger) (Storage, error)
+
+var (
+	registryMu = &sync.RWMutex{}
+	factories  = make(map[string]Factory)
+)
+
+// Register регистрирует фабрику хранилища для схемы URI. Сторонние хранилища вызывают её
+// из init своего пакета, который достаточно импортировать в main.
+// Пустая фабрика и повторная регистрация схемы — ошибка программиста, поэтому вызывают панику.
+func Register(scheme string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if factory == nil {
+		panic("storage: Register factory is nil for scheme " + scheme)
+	}
+	if _, ok := factories[scheme]; ok {
+		panic("storage: Register called twice for scheme " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// Schemes возвращает зарегистрированные схемы в алфавитном порядке.
+func Schemes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	slices.Sort(schemes)
+	return schemes
+}
+
+// Open создаёт хранилище фабрикой, зарегистрированной для схемы cfg.URI, без кеша и фильтра.
+func Open(cfg Config, storageLogger logger.Logger) (Storage, error) {
+	uri, err := ParseURI(cfg.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	registryMu.RLock()
+	factory, ok := factories[uri.Scheme]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage scheme %q, registered: %s", uri.Scheme, strings.Join(Schemes(), ", "))
+	}
+
+	store, err := factory(uri, cfg, storageLogger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s storage: %w", uri.Scheme, err)
+	}
+	storageLogger.Info("Storage opened", zap.String("scheme", uri.Scheme))
+	return store, nil
+}
+
+// ParseURI разбирает URI хранилища и приводит схему к нижнему регистру.
+func ParseURI(raw string) (*url.URL, error) {
+	uri, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage URI: %w", err)
+	}
+	if uri.Scheme == "" {
+		return nil, fmt.Errorf("storage URI %q has no scheme", raw)
+	}
+	uri.Scheme = strings.ToLower(uri.Scheme)
+	return uri, nil
+}
+
+// URIPath возвращает путь из URI вида scheme:relative/path или scheme:///absolute/path.
+// Запись scheme://relative/path тоже принимается: первый сегмент пути разбирается как хост.
+func URIPath(uri *url.URL) string {
+	if uri.Opaque != "" {
+		return uri.Opaque
+	}
+	return uri.Host + uri.Path
+}
+
+// PathURI собирает URI хранилища с путём в файловой системе, которое URIPath разберёт обратно.
+func PathURI(scheme, path string) string {
+	if filepath.IsAbs(path) {
+		return (&url.URL{Scheme: scheme, Path: filepath.ToSlash(path)}).String()
+	}
+	return scheme + ":" + filepath.ToSlash(path)
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/file"
+	"github.com/BrownBear56/contractor/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOpen(t *testing.T) {
+	testLogger := logger.NewZapLogger(zap.NewNop())
+	filePath := filepath.Join(t.TempDir(), "storage.json")
+
+	var customURI *url.URL
+	Register("custom-test", func(uri *url.URL, _ Config, _ logger.Logger) (Storage, error) {
+		customURI = uri
+		return memory.NewMemoryStore(), nil
+	})
+	assert.Panics(t, func() {
+		Register("custom-test", openMemory)
+	})
+
+	tests := []struct {
+		name        string
+		uri         string
+		expectedErr bool
+	}{
+		{name: "Memory", uri: "memory://"},
+		{name: "Absolute file path", uri: PathURI(SchemeFile, filePath)},
+		{name: "Registered custom backend", uri: "CUSTOM-TEST://host/path?x=1"},
+		{name: "Unknown scheme", uri: "redis://localhost", expectedErr: true},
+		{name: "No scheme", uri: "storage.json", expectedErr: true},
+		{name: "File without path", uri: "file://", expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := Open(Config{URI: tt.uri, File: file.Options{}}, testLogger)
+			if tt.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, store.SaveID(context.Background(), models.URLRecord{
+				ShortID: "id", OriginalURL: "https://example.com",
+			}))
+		})
+	}
+
+	require.NotNil(t, customURI)
+	assert.Equal(t, "host", customURI.Host)
+	assert.Equal(t, "1", customURI.Query().Get("x"))
+}
+
+func TestPathURI(t *testing.T) {
+	for _, path := range []string{"storage.json", "data/storage.json", "/var/lib/shortener/storage.json"} {
+		uri, err := ParseURI(PathURI(SchemeFile, path))
+		require.NoError(t, err)
+		assert.Equal(t, path, URIPath(uri))
+	}
+
+	// Запись с двумя косыми чертами и относительным путём тоже понимается.
+	uri, err := ParseURI("file://data/storage.json")
+	require.NoError(t, err)
+	assert.Equal(t, "data/storage.json", URIPath(uri))
+}
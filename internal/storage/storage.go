@@ -1,23 +1,122 @@
 package storage
 
 import (
+	"context"
 	"log"
+	"time"
 
 	"github.com/BrownBear56/contractor/internal/logger"
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/bitcask"
+	"github.com/BrownBear56/contractor/internal/storage/bloom"
+	"github.com/BrownBear56/contractor/internal/storage/cache"
 	"github.com/BrownBear56/contractor/internal/storage/file"
-	"github.com/BrownBear56/contractor/internal/storage/memory"
 	"github.com/BrownBear56/contractor/internal/storage/postgres"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// Storage — хранилище сокращённых ссылок.
+// Все методы принимают контекст запроса и прекращают работу при его отмене.
+// Ошибки реализаций оборачивают ошибки пакета errs: ErrNotFound, ErrDeleted, ErrExpired,
+// ErrIDConflict, ErrURLConflict и ErrUnavailable.
 type Storage interface {
-	SaveID(id, originalURL string) error
-	Get(id string) (string, bool)
-	GetIDByURL(originalURL string) (string, bool)
-	SaveBatch(pairs map[string]string) error
+	SaveID(ctx context.Context, record models.URLRecord) error
+	// GetOrCreate атомарно сохраняет запись или возвращает ID, под которым её URL уже сохранён.
+	// Второе значение равно true, если URL существовал. При занятом ID возвращает errs.ErrIDConflict.
+	GetOrCreate(ctx context.Context, record models.URLRecord) (string, bool, error)
+	Get(ctx context.Context, id string) (string, error)
+	GetIDByURL(ctx context.Context, originalURL string) (string, error)
+	// GetUserURLs возвращает ссылки, владельцем которых является пользователь.
+	GetUserURLs(ctx context.Context, userID string) ([]models.URLRecord, error)
+	// DeleteURLs помечает удалёнными ссылки, владельцы которых совпадают с авторами запросов.
+	// Get для удалённой ссылки возвращает errs.ErrDeleted.
+	DeleteURLs(ctx context.Context, requests []models.DeleteRequest) error
+	// DeleteExpired удаляет ссылки, срок жизни которых истёк к моменту now, и возвращает их число.
+	// До удаления Get для просроченной ссылки возвращает errs.ErrExpired.
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+	// RecordClicks прибавляет приращения счётчиков переходов к ссылкам. Неизвестные ID пропускаются.
+	RecordClicks(ctx context.Context, clicks []models.ClickStat) error
+	// GetStats возвращает статистику переходов по ссылке.
+	GetStats(ctx context.Context, id string) (models.ClickStat, error)
+	// SaveBatch сохраняет пакет записей и возвращает результат для каждой записи в том же порядке.
+	// Ошибка возвращается, только если пакет не удалось обработать целиком. Запись с IsDeleted
+	// сохраняется удалённой: она не занимает URL и конфликтует только по ID.
+	SaveBatch(ctx context.Context, records []models.URLRecord) ([]models.BatchResult, error)
+	// Iterate вызывает fn для каждой записи, включая удалённые и просроченные, в порядке
+	// возрастания ShortID начиная с первого ID больше afterID; пустой afterID означает начало.
+	// Порядок строк определяет хранилище (у PostgreSQL — правило сортировки базы), поэтому
+	// afterID имеет смысл только для того же хранилища, в котором получен.
+	// Записи читаются по мере обхода, а не собираются в память целиком, поэтому записи,
+	// изменённые во время обхода, могут попасть в него в любом из состояний.
+	// Ошибка fn прекращает обход и возвращается без изменений.
+	Iterate(ctx context.Context, afterID string, fn func(models.URLRecord) error) error
+	// Flush сбрасывает на постоянный носитель всё, что хранилище уже приняло.
+	Flush(ctx context.Context) error
+	// Close сбрасывает данные, останавливает фоновые задачи и освобождает ресурсы хранилища.
+	// После Close хранилищем пользоваться нельзя, повторный вызов ничего не делает.
+	Close(ctx context.Context) error
 }
 
-func NewStorage(filePath string, useFile bool, dbDSN string, parentLogger logger.Logger) Storage {
+// ChangeNotifier — необязательный интерфейс хранилища, общего для нескольких экземпляров сервиса,
+// которое оповещает об изменениях ссылок. По оповещениям обёртки сбрасывают локальное состояние,
+// устаревшее из-за изменений, сделанных другими экземплярами.
+type ChangeNotifier interface {
+	Subscribe(fn func(models.URLChange)) error
+}
+
+// Pinger — необязательный интерфейс хранилища, доступность которого проверяется запросом,
+// например к базе данных. Обёртки передают проверку хранилищу.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Ping проверяет доступность store. Хранилища без Pinger работают в том же процессе
+// и всегда доступны.
+func Ping(ctx context.Context, store Storage) error {
+	if pinger, ok := store.(Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// WithClient возвращает контекст запросов клиента clientID. Хранилище с репликами читает
+// недавние записи клиента с основного сервера, чтобы он сразу видел собственные ссылки.
+func WithClient(ctx context.Context, clientID string) context.Context {
+	return postgres.WithClient(ctx, clientID)
+}
+
+// ExpiryGetter — необязательный интерфейс хранилища, возвращающего вместе с URL ссылки момент
+// истечения её срока, нулевой для бессрочной. По нему кеш не отдаёт ссылку после истечения.
+type ExpiryGetter interface {
+	GetWithExpiry(ctx context.Context, id string) (string, time.Time, error)
+}
+
+// invalidator — обёртка с локальным состоянием, построенным по хранилищу.
+type invalidator interface {
+	Invalidate(change models.URLChange)
+}
+
+// Config — выбор и настройки хранилища.
+type Config struct {
+	// URI выбирает хранилище по схеме: memory://, file:///path, bitcask:///dir, postgres://...
+	// Схемы регистрируются через Register.
+	URI string
+	// DatabaseDSN — строка подключения PostgreSQL. Если задана, используется вместо URI
+	// со схемой postgres, что позволяет передать DSN в формате ключ=значение.
+	DatabaseDSN string
+	File        file.Options
+	Bitcask     bitcask.Options
+	Postgres    postgres.Options
+	// Cache — настройки кеша чтения поверх выбранного хранилища. Нулевой размер отключает кеш.
+	Cache cache.Options
+	// Filter — настройки фильтра известных ID. Нулевая доля ложных срабатываний отключает фильтр.
+	Filter bloom.Options
+	// MaxURLLength — наибольшая длина сохраняемого URL в байтах. Нулевое значение отключает проверку.
+	MaxURLLength int
+}
+
+func NewStorage(cfg Config, parentLogger logger.Logger) Storage {
 	// Настройки для нового логгера.
 	customEncoderConfig := zapcore.EncoderConfig{
 		TimeKey:       "timestamp",
@@ -42,16 +141,54 @@ func NewStorage(filePath string, useFile bool, dbDSN string, parentLogger logger
 		log.Fatalf("Failed to reconfigure logger: %v", err)
 	}
 
-	if dbDSN != "" {
-		pgStore, err := postgres.NewPostgresStore(dbDSN, storageLogger)
+	backend, err := Open(cfg, storageLogger)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	store := backend
+	var invalidators []invalidator
+	if cfg.Cache.Size > 0 {
+		storageLogger.Info("Read cache enabled",
+			zap.Int("size", cfg.Cache.Size), zap.Duration("ttl", cfg.Cache.TTL))
+		cached := NewCachedStorage(store, cfg.Cache)
+		store = cached
+		invalidators = append(invalidators, cached)
+	}
+
+	// Фильтр снаружи кеша: запросы несуществующих ID не доходят даже до кеша.
+	var filtered *FilteredStorage
+	if cfg.Filter.FalsePositiveRate > 0 {
+		if lister, ok := backend.(IDLister); ok {
+			filtered = newFilteredStorage(store, lister, cfg.Filter, storageLogger)
+			store = filtered
+			invalidators = append(invalidators, filtered)
+		} else {
+			storageLogger.Warn("Storage cannot list IDs, ID filter disabled")
+		}
+	}
+
+	// Подписка до построения фильтра: ID, сохранённые другими экземплярами во время построения,
+	// попадут в фильтр. Если подписаться не удалось, фильтр не применяется до переподключения.
+	// Хранилища без ChangeNotifier не общие для нескольких экземпляров, и фильтр знает все их ID.
+	if notifier, ok := backend.(ChangeNotifier); ok && len(invalidators) > 0 {
+		err := notifier.Subscribe(func(change models.URLChange) {
+			for _, target := range invalidators {
+				target.Invalidate(change)
+			}
+		})
 		if err != nil {
-			log.Fatalf("Failed to initialize PostgresStore: %v", err)
+			storageLogger.Warn("Failed to subscribe to storage changes, retrying in background", zap.Error(err))
+		}
+	}
+	if filtered != nil {
+		if err := filtered.rebuild(context.Background()); err != nil {
+			log.Fatalf("Failed to build ID filter: %v", err)
 		}
-		return pgStore
 	}
 
-	if useFile {
-		return file.NewFileStore(filePath, storageLogger)
+	// Проверка длины снаружи всех обёрток: отклонённые записи не доходят ни до фильтра, ни до кеша.
+	if cfg.MaxURLLength > 0 {
+		store = NewLimitedStorage(store, cfg.MaxURLLength)
 	}
-	return memory.NewMemoryStore()
+	return store
 }
@@ -0,0 +1,381 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage"
+)
+
+// Format — формат выгрузки ссылок.
+type Format string
+
+const (
+	// FormatNDJSON — по одному JSON-объекту на строку.
+	FormatNDJSON Format = "ndjson"
+	// FormatCSV — CSV с заголовком из имён полей.
+	FormatCSV Format = "csv"
+)
+
+// ErrMalformed — запись выгрузки не удалось разобрать или она некорректна.
+var ErrMalformed = errors.New("malformed record")
+
+// ParseFormat разбирает название формата. Пустая строка означает FormatNDJSON.
+func ParseFormat(value string) (Format, error) {
+	switch Format(value) {
+	case "", FormatNDJSON:
+		return FormatNDJSON, nil
+	case FormatCSV:
+		return FormatCSV, nil
+	default:
+		return "", fmt.Errorf("unknown format %q: expected %s or %s", value, FormatNDJSON, FormatCSV)
+	}
+}
+
+// ContentType возвращает MIME-тип формата.
+func (f Format) ContentType() string {
+	if f == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// Имена полей записи, общие для NDJSON и заголовка CSV.
+const (
+	fieldShortID        = "short_id"
+	fieldOriginalURL    = "original_url"
+	fieldUserID         = "user_id"
+	fieldExpiresAt      = "expires_at"
+	fieldClicks         = "clicks"
+	fieldLastAccessedAt = "last_accessed_at"
+	fieldIsDeleted      = "is_deleted"
+)
+
+// csvHeader — порядок колонок CSV при выгрузке.
+var csvHeader = []string{
+	fieldShortID, fieldOriginalURL, fieldUserID, fieldExpiresAt, fieldClicks, fieldLastAccessedAt, fieldIsDeleted,
+}
+
+// jsonRecord — запись выгрузки в формате NDJSON.
+type jsonRecord struct {
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+	ShortID        string     `json:"short_id"`
+	OriginalURL    string     `json:"original_url"`
+	UserID         string     `json:"user_id,omitempty"`
+	Clicks         int64      `json:"clicks,omitempty"`
+	IsDeleted      bool       `json:"is_deleted,omitempty"`
+}
+
+// Writer записывает ссылки в выгрузку. Данные могут буферизоваться до вызова Flush.
+type Writer interface {
+	Write(record models.URLRecord) error
+	Flush() error
+}
+
+// NewWriter возвращает Writer выгрузки в формате format.
+func NewWriter(w io.Writer, format Format) Writer {
+	if format == FormatCSV {
+		return &csvWriter{writer: csv.NewWriter(w)}
+	}
+	buffered := bufio.NewWriter(w)
+	return &jsonWriter{buffered: buffered, encoder: json.NewEncoder(buffered)}
+}
+
+type jsonWriter struct {
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+func (w *jsonWriter) Write(record models.URLRecord) error {
+	if err := w.encoder.Encode(jsonRecord{
+		ExpiresAt:      optionalTime(record.ExpiresAt),
+		LastAccessedAt: optionalTime(record.LastAccessedAt),
+		ShortID:        record.ShortID,
+		OriginalURL:    record.OriginalURL,
+		UserID:         record.UserID,
+		Clicks:         record.Clicks,
+		IsDeleted:      record.IsDeleted,
+	}); err != nil {
+		return fmt.Errorf("failed to write record %s: %w", record.ShortID, err)
+	}
+	return nil
+}
+
+func (w *jsonWriter) Flush() error {
+	if err := w.buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to flush export: %w", err)
+	}
+	return nil
+}
+
+type csvWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvWriter) Write(record models.URLRecord) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	if err := w.writer.Write([]string{
+		record.ShortID,
+		record.OriginalURL,
+		record.UserID,
+		formatTime(record.ExpiresAt),
+		strconv.FormatInt(record.Clicks, 10),
+		formatTime(record.LastAccessedAt),
+		strconv.FormatBool(record.IsDeleted),
+	}); err != nil {
+		return fmt.Errorf("failed to write record %s: %w", record.ShortID, err)
+	}
+	return nil
+}
+
+// Flush дописывает заголовок, если записей не было, чтобы пустая выгрузка оставалась корректным CSV.
+func (w *csvWriter) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		return fmt.Errorf("failed to flush export: %w", err)
+	}
+	return nil
+}
+
+func (w *csvWriter) writeHeader() error {
+	if w.headerWritten {
+		return nil
+	}
+	w.headerWritten = true
+	if err := w.writer.Write(csvHeader); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	return nil
+}
+
+// Reader читает ссылки из выгрузки. В конце выгрузки Read возвращает io.EOF,
+// для некорректной записи — ошибку ErrMalformed с номером строки.
+type Reader interface {
+	Read() (models.URLRecord, error)
+}
+
+// NewReader возвращает Reader выгрузки в формате format. Колонки CSV сопоставляются
+// по заголовку, поэтому их порядок произволен, а необязательные можно опустить.
+func NewReader(r io.Reader, format Format) (Reader, error) {
+	if format != FormatCSV {
+		return &jsonReader{reader: bufio.NewReader(r)}, nil
+	}
+
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("missing CSV header: %w", ErrMalformed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w: %w", ErrMalformed, err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		switch name {
+		case fieldShortID, fieldOriginalURL, fieldUserID, fieldExpiresAt, fieldClicks, fieldLastAccessedAt,
+			fieldIsDeleted:
+		default:
+			return nil, fmt.Errorf("unknown CSV column %q: %w", name, ErrMalformed)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("duplicate CSV column %q: %w", name, ErrMalformed)
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{fieldShortID, fieldOriginalURL} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing CSV column %q: %w", name, ErrMalformed)
+		}
+	}
+	return &csvReader{reader: reader, columns: columns}, nil
+}
+
+type jsonReader struct {
+	reader *bufio.Reader
+	line   int
+}
+
+func (r *jsonReader) Read() (models.URLRecord, error) {
+	for {
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			return models.URLRecord{}, io.EOF
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return models.URLRecord{}, fmt.Errorf("failed to read import: %w", err)
+		}
+		r.line++
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var decoded jsonRecord
+		if err := json.Unmarshal(line, &decoded); err != nil {
+			return models.URLRecord{}, fmt.Errorf("line %d: %w: %w", r.line, ErrMalformed, err)
+		}
+
+		record := models.URLRecord{
+			ShortID:     decoded.ShortID,
+			OriginalURL: decoded.OriginalURL,
+			UserID:      decoded.UserID,
+			Clicks:      decoded.Clicks,
+			IsDeleted:   decoded.IsDeleted,
+		}
+		if decoded.ExpiresAt != nil {
+			record.ExpiresAt = *decoded.ExpiresAt
+		}
+		if decoded.LastAccessedAt != nil {
+			record.LastAccessedAt = *decoded.LastAccessedAt
+		}
+		if err := validate(record); err != nil {
+			return models.URLRecord{}, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return record, nil
+	}
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func (r *csvReader) Read() (models.URLRecord, error) {
+	fields, err := r.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return models.URLRecord{}, io.EOF
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return models.URLRecord{}, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	if err != nil {
+		return models.URLRecord{}, fmt.Errorf("failed to read import: %w", err)
+	}
+
+	line, _ := r.reader.FieldPos(0)
+	record, err := r.parse(fields)
+	if err == nil {
+		err = validate(record)
+	}
+	if err != nil {
+		return models.URLRecord{}, fmt.Errorf("line %d: %w", line, err)
+	}
+	return record, nil
+}
+
+func (r *csvReader) parse(fields []string) (models.URLRecord, error) {
+	field := func(name string) string {
+		if i, ok := r.columns[name]; ok {
+			return fields[i]
+		}
+		return ""
+	}
+
+	record := models.URLRecord{
+		ShortID:     field(fieldShortID),
+		OriginalURL: field(fieldOriginalURL),
+		UserID:      field(fieldUserID),
+	}
+	var err error
+	if record.ExpiresAt, err = parseTime(field(fieldExpiresAt)); err != nil {
+		return record, fmt.Errorf("invalid %s: %w: %w", fieldExpiresAt, ErrMalformed, err)
+	}
+	if record.LastAccessedAt, err = parseTime(field(fieldLastAccessedAt)); err != nil {
+		return record, fmt.Errorf("invalid %s: %w: %w", fieldLastAccessedAt, ErrMalformed, err)
+	}
+	if value := field(fieldClicks); value != "" {
+		if record.Clicks, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return record, fmt.Errorf("invalid %s: %w: %w", fieldClicks, ErrMalformed, err)
+		}
+	}
+	if value := field(fieldIsDeleted); value != "" {
+		if record.IsDeleted, err = strconv.ParseBool(value); err != nil {
+			return record, fmt.Errorf("invalid %s: %w: %w", fieldIsDeleted, ErrMalformed, err)
+		}
+	}
+	return record, nil
+}
+
+// validate отклоняет записи, которые сервис не смог бы создать сам. Настроенное ограничение
+// длины URL проверяет хранилище и возвращает по записи, здесь проверяется только его верхняя граница.
+func validate(record models.URLRecord) error {
+	if record.ShortID == "" {
+		return fmt.Errorf("empty %s: %w", fieldShortID, ErrMalformed)
+	}
+	if len(record.ShortID) > storage.MaxShortIDLength {
+		return fmt.Errorf("%s longer than %d bytes: %w", fieldShortID, storage.MaxShortIDLength, ErrMalformed)
+	}
+	if !validShortID(record.ShortID) {
+		return fmt.Errorf("invalid characters in %s: %w", fieldShortID, ErrMalformed)
+	}
+	if len(record.UserID) > storage.MaxUserIDLength {
+		return fmt.Errorf("%s longer than %d bytes: %w", fieldUserID, storage.MaxUserIDLength, ErrMalformed)
+	}
+	if len(record.OriginalURL) > storage.MaxURLLengthLimit {
+		return fmt.Errorf("%s longer than %d bytes: %w", fieldOriginalURL, storage.MaxURLLengthLimit, ErrMalformed)
+	}
+	if _, err := url.ParseRequestURI(record.OriginalURL); err != nil {
+		return fmt.Errorf("invalid %s: %w: %w", fieldOriginalURL, ErrMalformed, err)
+	}
+	if record.Clicks < 0 {
+		return fmt.Errorf("negative %s: %w", fieldClicks, ErrMalformed)
+	}
+	return nil
+}
+
+// validShortID сообщает, состоит ли ID только из символов base64 для URL, которыми
+// сервис кодирует сгенерированные ID.
+func validShortID(shortID string) bool {
+	for _, c := range shortID {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse time: %w", err)
+	}
+	return t, nil
+}
@@ -0,0 +1,303 @@
+// Package transfer переносит ссылки между хранилищами с сохранением коротких ID.
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+)
+
+// DefaultBatchSize — число записей, сохраняемых в целевое хранилище за один SaveBatch.
+const DefaultBatchSize = 500
+
+// ConflictKind — причина, по которой запись не перенесена.
+type ConflictKind string
+
+const (
+	// ConflictURL — URL уже сохранён в целевом хранилище под другим ID.
+	ConflictURL ConflictKind = "url saved under another id"
+	// ConflictID — ID уже занят в целевом хранилище другим URL.
+	ConflictID ConflictKind = "id taken by another url"
+	// ConflictTooLong — URL длиннее допустимого в целевом хранилище.
+	ConflictTooLong ConflictKind = "url too long"
+	// ConflictValueTooLong — ID или ID пользователя не помещается в целевое хранилище.
+	ConflictValueTooLong ConflictKind = "id or user id too long"
+)
+
+// Conflict — запись исходного хранилища, которую не удалось перенести.
+type Conflict struct {
+	Kind   ConflictKind
+	Record models.URLRecord
+	// ExistingID — ID, под которым URL сохранён в целевом хранилище, для ConflictURL.
+	ExistingID string
+}
+
+// Options — настройки переноса.
+type Options struct {
+	// Checkpoint вызывается после каждой перенесённой порции с ID её последней записи.
+	// Переданный ID можно указать в AfterID, чтобы продолжить прерванный перенос.
+	Checkpoint func(lastID string) error
+	// AfterID — ID, после которого начинается перенос. Пустое значение означает начало.
+	AfterID string
+	// BatchSize — размер порции. Неположительное значение заменяется DefaultBatchSize.
+	BatchSize int
+}
+
+// Report — итог переноса.
+type Report struct {
+	Conflicts []Conflict
+	// LastID — ID последней обработанной записи.
+	LastID string
+	// Read — число прочитанных из исходного хранилища записей.
+	Read int
+	// Created — число записей, сохранённых в целевое хранилище.
+	Created int
+	// AlreadyPresent — число записей, уже сохранённых в целевом хранилище под тем же ID,
+	// например при повторном запуске прерванного переноса.
+	AlreadyPresent int
+	// Expired — число пропущенных просроченных записей.
+	Expired int
+	// Deleted — число перенесённых записей, помеченных удалёнными.
+	Deleted int
+}
+
+// Copy переносит записи из src в dst порциями в порядке ID исходного хранилища.
+// Удалённые ссылки переносятся и помечаются удалёнными, просроченные пропускаются,
+// счётчики переходов переносятся только для впервые сохранённых записей, чтобы
+// повторный запуск не удвоил их. Конфликты не прерывают перенос и попадают в отчёт.
+func Copy(ctx context.Context, src, dst storage.Storage, options Options) (Report, error) {
+	b := newBatcher(dst, options.BatchSize, options.Checkpoint)
+	b.report.LastID = options.AfterID
+
+	var saveErr error
+	err := src.Iterate(ctx, options.AfterID, func(record models.URLRecord) error {
+		saveErr = b.add(ctx, record)
+		return saveErr
+	})
+	if saveErr != nil {
+		return b.report, saveErr
+	}
+	if err != nil {
+		return b.report, fmt.Errorf("failed to read source storage: %w", err)
+	}
+	if err := b.flush(ctx); err != nil {
+		return b.report, err
+	}
+	return b.report, nil
+}
+
+// Import загружает в dst записи выгрузки по тем же правилам, что и Copy. Порции,
+// сохранённые до ошибки, остаются в dst; повторная загрузка той же выгрузки их
+// только учтёт в AlreadyPresent.
+func Import(ctx context.Context, reader Reader, dst storage.Storage, batchSize int) (Report, error) {
+	b := newBatcher(dst, batchSize, nil)
+	for {
+		if err := ctx.Err(); err != nil {
+			return b.report, fmt.Errorf("import canceled: %w", err)
+		}
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return b.report, err
+		}
+		if err := b.add(ctx, record); err != nil {
+			return b.report, err
+		}
+	}
+	if err := b.flush(ctx); err != nil {
+		return b.report, err
+	}
+	return b.report, nil
+}
+
+// batcher накапливает записи и сохраняет их в dst порциями.
+type batcher struct {
+	dst        storage.Storage
+	checkpoint func(lastID string) error
+	batch      []models.URLRecord
+	report     Report
+	batchSize  int
+}
+
+func newBatcher(dst storage.Storage, batchSize int, checkpoint func(lastID string) error) *batcher {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &batcher{
+		dst:        dst,
+		checkpoint: checkpoint,
+		batch:      make([]models.URLRecord, 0, batchSize),
+		batchSize:  batchSize,
+	}
+}
+
+func (b *batcher) add(ctx context.Context, record models.URLRecord) error {
+	b.report.Read++
+	b.batch = append(b.batch, record)
+	if len(b.batch) < b.batchSize {
+		return nil
+	}
+	return b.flush(ctx)
+}
+
+func (b *batcher) flush(ctx context.Context) error {
+	if len(b.batch) == 0 {
+		return nil
+	}
+	if err := copyBatch(ctx, b.dst, b.batch, &b.report); err != nil {
+		return err
+	}
+	b.report.LastID = b.batch[len(b.batch)-1].ShortID
+	b.batch = b.batch[:0]
+	if b.checkpoint != nil {
+		if err := b.checkpoint(b.report.LastID); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyBatch сохраняет порцию в dst и переносит пометки удаления и счётчики переходов.
+func copyBatch(ctx context.Context, dst storage.Storage, batch []models.URLRecord, report *Report) error {
+	now := time.Now()
+	records := make([]models.URLRecord, 0, len(batch))
+	for _, record := range batch {
+		if record.Expired(now) {
+			report.Expired++
+			continue
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	// Хранилища по-разному обращаются со счётчиком в сохраняемой записи, поэтому он сбрасывается
+	// и переносится отдельным вызовом. Пометка удаления сохраняется вместе с записью: удалённая
+	// ссылка не должна даже на время занимать URL, иначе живая ссылка с тем же URL станет конфликтом.
+	fresh := make([]models.URLRecord, len(records))
+	for i, record := range records {
+		record.Clicks, record.LastAccessedAt = 0, time.Time{}
+		fresh[i] = record
+	}
+	results, err := dst.SaveBatch(ctx, fresh)
+	if err != nil {
+		return fmt.Errorf("failed to save batch: %w", err)
+	}
+
+	var (
+		clicks    []models.ClickStat
+		deletions []models.DeleteRequest
+	)
+	for i, result := range results {
+		record := records[i]
+		switch {
+		case result.Status == models.BatchCreated:
+			report.Created++
+			if record.Clicks > 0 && !record.IsDeleted {
+				clicks = append(clicks, models.ClickStat{
+					ShortID: record.ShortID, Clicks: record.Clicks, LastAccessedAt: record.LastAccessedAt,
+				})
+			}
+		case result.Status == models.BatchExisted && result.ShortID == record.ShortID:
+			report.AlreadyPresent++
+		case result.Status == models.BatchExisted:
+			report.Conflicts = append(report.Conflicts,
+				Conflict{Kind: ConflictURL, Record: record, ExistingID: result.ShortID})
+			continue
+		case errors.Is(result.Err, errs.ErrIDConflict):
+			report.Conflicts = append(report.Conflicts, Conflict{Kind: ConflictID, Record: record})
+			continue
+		case errors.Is(result.Err, errs.ErrURLTooLong):
+			report.Conflicts = append(report.Conflicts, Conflict{Kind: ConflictTooLong, Record: record})
+			continue
+		case errors.Is(result.Err, errs.ErrValueTooLong):
+			report.Conflicts = append(report.Conflicts, Conflict{Kind: ConflictValueTooLong, Record: record})
+			continue
+		default:
+			return fmt.Errorf("failed to save %s: %w", record.ShortID, result.Err)
+		}
+
+		if !record.IsDeleted {
+			continue
+		}
+		report.Deleted++
+		// Прошлый запуск мог перенести запись живой, поэтому для уже перенесённых записей
+		// пометка удаления повторяется: она идемпотентна.
+		if result.Status == models.BatchExisted && record.UserID != "" {
+			deletions = append(deletions, models.DeleteRequest{UserID: record.UserID, ShortID: record.ShortID})
+		}
+	}
+
+	if len(clicks) > 0 {
+		if err := dst.RecordClicks(ctx, clicks); err != nil {
+			return fmt.Errorf("failed to copy clicks: %w", err)
+		}
+	}
+	if len(deletions) > 0 {
+		if err := dst.DeleteURLs(ctx, deletions); err != nil {
+			return fmt.Errorf("failed to copy deletions: %w", err)
+		}
+	}
+	return nil
+}
+
+// Mismatch — запись, которую целевое хранилище возвращает не так, как исходное.
+type Mismatch struct {
+	ShortID string
+	// Expected — ожидаемый URL или ошибка, Actual — полученный.
+	Expected string
+	Actual   string
+}
+
+// Verification — итог сверки хранилищ.
+type Verification struct {
+	Mismatches []Mismatch
+	// Checked — число сверенных записей, просроченные не сверяются.
+	Checked int
+}
+
+// Verify проверяет, что для каждой непросроченной записи src хранилище dst возвращает
+// тот же URL, а для удалённой — errs.ErrDeleted.
+func Verify(ctx context.Context, src, dst storage.Storage) (Verification, error) {
+	var verification Verification
+	now := time.Now()
+	err := src.Iterate(ctx, "", func(record models.URLRecord) error {
+		if record.Expired(now) {
+			return nil
+		}
+		verification.Checked++
+
+		originalURL, err := dst.Get(ctx, record.ShortID)
+		expected, actual := record.OriginalURL, originalURL
+		switch {
+		case record.IsDeleted && errors.Is(err, errs.ErrDeleted):
+			return nil
+		case record.IsDeleted:
+			expected = errs.ErrDeleted.Error()
+		case err == nil && originalURL == record.OriginalURL:
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("verification canceled: %w", ctx.Err())
+			}
+			actual = err.Error()
+		}
+		verification.Mismatches = append(verification.Mismatches,
+			Mismatch{ShortID: record.ShortID, Expected: expected, Actual: actual})
+		return nil
+	})
+	if err != nil {
+		return verification, fmt.Errorf("failed to read source storage: %w", err)
+	}
+	return verification, nil
+}
@@ -0,0 +1,227 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BrownBear56/contractor/internal/models"
+	"github.com/BrownBear56/contractor/internal/storage/errs"
+	"github.com/BrownBear56/contractor/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	src := memory.NewMemoryStore()
+	for i := range 5 {
+		require.NoError(t, src.SaveID(ctx, models.URLRecord{
+			ShortID: fmt.Sprintf("id%d", i), OriginalURL: fmt.Sprintf("https://example.com/%d", i), UserID: "user",
+		}))
+	}
+	require.NoError(t, src.SaveID(ctx, models.URLRecord{
+		ShortID: "expired", OriginalURL: "https://example.com/expired", ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+	require.NoError(t, src.RecordClicks(ctx, []models.ClickStat{{ShortID: "id0", Clicks: 3}}))
+	require.NoError(t, src.DeleteURLs(ctx, []models.DeleteRequest{{UserID: "user", ShortID: "id1"}}))
+
+	dst := memory.NewMemoryStore()
+	// URL id2 уже сохранён под другим ID, а ID id3 занят другим URL.
+	require.NoError(t, dst.SaveID(ctx, models.URLRecord{ShortID: "other", OriginalURL: "https://example.com/2"}))
+	require.NoError(t, dst.SaveID(ctx, models.URLRecord{ShortID: "id3", OriginalURL: "https://example.com/taken"}))
+
+	var checkpoints []string
+	report, err := Copy(ctx, src, dst, Options{
+		BatchSize: 2,
+		Checkpoint: func(lastID string) error {
+			checkpoints = append(checkpoints, lastID)
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 6, report.Read)
+	assert.Equal(t, 3, report.Created)
+	assert.Equal(t, 1, report.Expired)
+	assert.Equal(t, 1, report.Deleted)
+	assert.Equal(t, "id4", report.LastID)
+	assert.Equal(t, []string{"id0", "id2", "id4"}, checkpoints)
+	require.Len(t, report.Conflicts, 2)
+	assert.Equal(t, Conflict{Kind: ConflictURL, Record: report.Conflicts[0].Record, ExistingID: "other"},
+		report.Conflicts[0])
+	assert.Equal(t, ConflictID, report.Conflicts[1].Kind)
+
+	stats, err := dst.GetStats(ctx, "id0")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Clicks)
+	_, err = dst.Get(ctx, "id1")
+	require.ErrorIs(t, err, errs.ErrDeleted)
+
+	verification, err := Verify(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 5, verification.Checked)
+	require.Len(t, verification.Mismatches, 2)
+	assert.Equal(t, "id2", verification.Mismatches[0].ShortID)
+	assert.Equal(t, "id3", verification.Mismatches[1].ShortID)
+}
+
+func TestCopyDeletedSharingURL(t *testing.T) {
+	ctx := context.Background()
+	const originalURL = "https://example.com/shared"
+	src := memory.NewMemoryStore()
+	require.NoError(t, src.SaveID(ctx, models.URLRecord{ShortID: "aaa", OriginalURL: originalURL, UserID: "user"}))
+	require.NoError(t, src.DeleteURLs(ctx, []models.DeleteRequest{{UserID: "user", ShortID: "aaa"}}))
+	require.NoError(t, src.SaveID(ctx, models.URLRecord{ShortID: "bbb", OriginalURL: originalURL, UserID: "user"}))
+
+	// Удалённая ссылка переносится раньше живой и не должна занять её URL.
+	dst := memory.NewMemoryStore()
+	report, err := Copy(ctx, src, dst, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Created)
+	assert.Equal(t, 1, report.Deleted)
+	assert.Empty(t, report.Conflicts)
+
+	got, err := dst.Get(ctx, "bbb")
+	require.NoError(t, err)
+	assert.Equal(t, originalURL, got)
+	_, err = dst.Get(ctx, "aaa")
+	require.ErrorIs(t, err, errs.ErrDeleted)
+	id, err := dst.GetIDByURL(ctx, originalURL)
+	require.NoError(t, err)
+	assert.Equal(t, "bbb", id)
+
+	// Повторный перенос находит обе записи на месте.
+	report, err = Copy(ctx, src, dst, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.AlreadyPresent)
+	assert.Empty(t, report.Conflicts)
+
+	verification, err := Verify(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Empty(t, verification.Mismatches)
+}
+
+func TestCopyResume(t *testing.T) {
+	ctx := context.Background()
+	src := memory.NewMemoryStore()
+	for i := range 4 {
+		require.NoError(t, src.SaveID(ctx, models.URLRecord{
+			ShortID: fmt.Sprintf("id%d", i), OriginalURL: fmt.Sprintf("https://example.com/%d", i),
+		}))
+	}
+	require.NoError(t, src.RecordClicks(ctx, []models.ClickStat{{ShortID: "id1", Clicks: 2}}))
+
+	// Первый запуск прерывается после первой порции.
+	dst := memory.NewMemoryStore()
+	interrupted := fmt.Errorf("interrupted")
+	report, err := Copy(ctx, src, dst, Options{
+		BatchSize:  2,
+		Checkpoint: func(string) error { return interrupted },
+	})
+	require.ErrorIs(t, err, interrupted)
+	assert.Equal(t, "id1", report.LastID)
+
+	// Повтор с начала не удваивает счётчики уже перенесённых записей.
+	report, err = Copy(ctx, src, dst, Options{BatchSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.AlreadyPresent)
+	assert.Equal(t, 2, report.Created)
+	stats, err := dst.GetStats(ctx, "id1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Clicks)
+
+	// Продолжение с сохранённого ID читает только оставшиеся записи.
+	report, err = Copy(ctx, src, dst, Options{AfterID: "id1"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Read)
+
+	verification, err := Verify(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Empty(t, verification.Mismatches)
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      Format
+		input       string
+		expected    []models.URLRecord
+		expectError string
+	}{
+		{
+			name:   "NDJSON with blank lines",
+			format: FormatNDJSON,
+			input: "{\"short_id\":\"a\",\"original_url\":\"https://example.com/a\",\"clicks\":2}\n\n" +
+				"{\"short_id\":\"b\",\"original_url\":\"https://example.com/b\",\"is_deleted\":true}",
+			expected: []models.URLRecord{
+				{ShortID: "a", OriginalURL: "https://example.com/a", Clicks: 2},
+				{ShortID: "b", OriginalURL: "https://example.com/b", IsDeleted: true},
+			},
+		},
+		{
+			name:     "CSV with reordered and omitted columns",
+			format:   FormatCSV,
+			input:    "original_url,short_id,user_id\nhttps://example.com/a,a,owner\n",
+			expected: []models.URLRecord{{ShortID: "a", OriginalURL: "https://example.com/a", UserID: "owner"}},
+		},
+		{
+			name:        "NDJSON invalid URL",
+			format:      FormatNDJSON,
+			input:       "{\"short_id\":\"a\",\"original_url\":\"https://example.com/a\"}\n{\"short_id\":\"b\"}\n",
+			expectError: "line 2",
+		},
+		{
+			name:        "CSV invalid clicks",
+			format:      FormatCSV,
+			input:       "short_id,original_url,clicks\na,https://example.com/a,many\n",
+			expectError: "line 2",
+		},
+		{
+			name:        "NDJSON too long short ID",
+			format:      FormatNDJSON,
+			input:       "{\"short_id\":\"abcdefghijklm\",\"original_url\":\"https://example.com/a\"}\n",
+			expectError: "short_id longer than 12 bytes",
+		},
+		{
+			name:        "CSV invalid short ID characters",
+			format:      FormatCSV,
+			input:       "short_id,original_url\na/b,https://example.com/a\n",
+			expectError: "invalid characters in short_id",
+		},
+		{
+			name:        "CSV too long user ID",
+			format:      FormatCSV,
+			input:       "short_id,original_url,user_id\na,https://example.com/a," + strings.Repeat("u", 65) + "\n",
+			expectError: "user_id longer than 64 bytes",
+		},
+		{
+			name:        "CSV unknown column",
+			format:      FormatCSV,
+			input:       "short_id,original_url,extra\n",
+			expectError: "unknown CSV column",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var records []models.URLRecord
+			reader, err := NewReader(strings.NewReader(tt.input), tt.format)
+			for err == nil {
+				var record models.URLRecord
+				if record, err = reader.Read(); err == nil {
+					records = append(records, record)
+				}
+			}
+			if tt.expectError != "" {
+				require.ErrorIs(t, err, ErrMalformed)
+				assert.Contains(t, err.Error(), tt.expectError)
+				return
+			}
+			require.ErrorIs(t, err, io.EOF)
+			assert.Equal(t, tt.expected, records)
+		})
+	}
+}